@@ -0,0 +1,103 @@
+// Package assets serves static files under URLs that carry a hash of their
+// content, so browsers can cache them until they change.
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// VersionParam is the query parameter asset URLs carry their hash in.
+const VersionParam = "v"
+
+// Assets is a set of static files.
+type Assets struct {
+	files fs.FS
+	live  bool // Files may change while running, so nothing is cached for long
+
+	mutex  sync.Mutex
+	hashes map[string]string // By file name, filled as files are asked for
+}
+
+// New serves files. With live set, files are hashed each time they are asked
+// for, so edits show up on the next page load; use it for a directory being
+// worked on.
+func New(files fs.FS, live bool) (*Assets, error) {
+	a := &Assets{files: files, live: live, hashes: make(map[string]string)}
+	if live {
+		return a, nil
+	}
+	// Hash everything up front, so a broken embed fails at startup.
+	err := fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		_, err = a.hash(name)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hashing static files: %w", err)
+	}
+	return a, nil
+}
+
+// Path returns name with its content hash as a query parameter, relative to
+// where Handler is mounted. Files that cannot be read are left unversioned.
+func (a *Assets) Path(name string) string {
+	hash, err := a.hash(name)
+	if err != nil {
+		return name
+	}
+	return name + "?" + VersionParam + "=" + hash
+}
+
+// Handler serves the files. Requests carrying the current hash may be cached
+// for a year; others are revalidated against the hash each time.
+func (a *Assets) Handler() http.Handler {
+	files := http.FileServerFS(a.files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash, err := a.hash(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"`+hash+`"`)
+		if !a.live && r.URL.Query().Get(VersionParam) == hash {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// hash returns the first 16 hex digits of the SHA-256 of the named file.
+func (a *Assets) hash(name string) (string, error) {
+	if !a.live {
+		a.mutex.Lock()
+		hash, ok := a.hashes[name]
+		a.mutex.Unlock()
+		if ok {
+			return hash, nil
+		}
+	}
+
+	b, err := fs.ReadFile(a.files, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:8])
+
+	if !a.live {
+		a.mutex.Lock()
+		a.hashes[name] = hash
+		a.mutex.Unlock()
+	}
+	return hash, nil
+}
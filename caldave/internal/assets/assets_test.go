@@ -0,0 +1,77 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHandler(t *testing.T) {
+	files := fstest.MapFS{"script.js": {Data: []byte("console.log(1);")}}
+	a, err := New(files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versioned := a.Path("script.js")
+	name, hash, ok := strings.Cut(versioned, "?v=")
+	if !ok || name != "script.js" || len(hash) != 16 {
+		t.Fatalf("Unexpected path %q", versioned)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		etag        string
+		status      int
+		cacheHeader string
+	}{
+		{name: "Current version", path: versioned, status: http.StatusOK, cacheHeader: "public, max-age=31536000, immutable"},
+		{name: "Old version", path: "script.js?v=0123456789abcdef", status: http.StatusOK, cacheHeader: "no-cache"},
+		{name: "Unversioned", path: "script.js", status: http.StatusOK, cacheHeader: "no-cache"},
+		{name: "Revalidated", path: "script.js", etag: `"` + hash + `"`, status: http.StatusNotModified, cacheHeader: "no-cache"},
+		{name: "Missing", path: "style.css", status: http.StatusNotFound},
+		{name: "Directory", path: "", status: http.StatusNotFound},
+	}
+
+	handler := http.StripPrefix("/static/", a.Handler())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/static/"+tt.path, nil)
+			if tt.etag != "" {
+				r.Header.Set("If-None-Match", tt.etag)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if got := w.Header().Get("Cache-Control"); tt.cacheHeader != "" && got != tt.cacheHeader {
+				t.Errorf("Expected Cache-Control %q, got %q", tt.cacheHeader, got)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != "console.log(1);" {
+				t.Errorf("Unexpected body %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	files := fstest.MapFS{"script.js": {Data: []byte("console.log(1);")}}
+	a, err := New(files, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := a.Path("script.js")
+	files["script.js"] = &fstest.MapFile{Data: []byte("console.log(2);")}
+	if after := a.Path("script.js"); after == before {
+		t.Errorf("Expected the version to change with the file, got %q both times", after)
+	}
+
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+a.Path("script.js"), nil))
+	if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Expected live files not to be cached, got %q", got)
+	}
+}
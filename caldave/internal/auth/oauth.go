@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"caldave/internal/config"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	stateCookie = "caldave_oauth_state"
+	stateTTL    = 10 * time.Minute
+)
+
+// Manager runs the Google OAuth redirect flow for each host and hands out
+// clients backed by the stored tokens.
+type Manager struct {
+	config    *oauth2.Config
+	secure    bool   // Whether cookies are only sent over HTTPS
+	basePath  string // Prefix of the booking page the callback redirects to
+	store     *TokenStore
+	hosts     map[string]bool
+	mutex     sync.Mutex
+	states    map[string]pendingState
+	onConnect []func(ctx context.Context, host string)
+}
+
+// pendingState is an issued OAuth state parameter awaiting its callback.
+type pendingState struct {
+	host    string
+	expires time.Time
+}
+
+func NewManager(cfg *config.Config, hosts []config.HostConfig) (*Manager, error) {
+	b, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %w", err)
+	}
+
+	oauthConfig, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
+	}
+	oauthConfig.RedirectURL = strings.TrimSuffix(cfg.BaseURL, "/") + "/auth/google/callback"
+
+	store, err := NewTokenStore(cfg.TokenDir, cfg.TokenSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		known[host.ID] = true
+	}
+
+	return &Manager{
+		config:   oauthConfig,
+		secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		basePath: cfg.BasePath,
+		store:    store,
+		hosts:    known,
+		states:   make(map[string]pendingState),
+	}, nil
+}
+
+// OnConnect registers fn to be called after a new token has been stored for
+// a host.
+func (m *Manager) OnConnect(fn func(ctx context.Context, host string)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.onConnect = append(m.onConnect, fn)
+}
+
+// Client returns an HTTP client for host that refreshes the stored token
+// when it expires and writes refreshed tokens back to the store. It returns
+// ErrNoToken if the host has not completed the OAuth flow yet.
+func (m *Manager) Client(ctx context.Context, host string) (*http.Client, error) {
+	tok, err := m.store.Load(host)
+	if err != nil {
+		return nil, err
+	}
+	src := &persistingTokenSource{
+		base:  m.config.TokenSource(ctx, tok),
+		store: m.store,
+		host:  host,
+		last:  tok.AccessToken,
+	}
+	return oauth2.NewClient(ctx, src), nil
+}
+
+// StartHandler redirects a logged in admin to Google's consent screen to
+// connect the calendar of the host in the {host} path value. It must be
+// posted from a page behind sessions, as whoever completes the flow decides
+// whose calendar the host's availability comes from.
+func (m *Manager) StartHandler(sessions *Sessions, loginPath string) http.Handler {
+	return sessions.Require(loginPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.PathValue("host")
+		if !m.hosts[host] {
+			http.Error(w, "Unknown host", http.StatusNotFound)
+			return
+		}
+
+		state, err := m.newState(host)
+		if err != nil {
+			http.Error(w, "Unable to start authorization", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookie,
+			Value:    state,
+			Path:     m.cookiePath(),
+			MaxAge:   int(stateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil || m.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		authURL := m.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+		http.Redirect(w, r, authURL, http.StatusSeeOther)
+	}))
+}
+
+// cookiePath is where the browser sends the state cookie back to, the
+// callback under the base path.
+func (m *Manager) cookiePath() string {
+	return m.basePath + "/auth/google"
+}
+
+// CallbackHandler exchanges the authorization code for a token and stores it.
+func (m *Manager) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := r.URL.Query().Get("state")
+		cookie, err := r.Cookie(stateCookie)
+		if err != nil || cookie.Value != state {
+			http.Error(w, "Invalid OAuth state", http.StatusBadRequest)
+			return
+		}
+		host, ok := m.consumeState(state)
+		if !ok {
+			http.Error(w, "Invalid OAuth state", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: m.cookiePath(), MaxAge: -1})
+
+		if errParam := r.URL.Query().Get("error"); errParam != "" {
+			http.Error(w, "Authorization denied: "+errParam, http.StatusForbidden)
+			return
+		}
+
+		err = m.authorize(r.Context(), host, r.URL.Query().Get("code"))
+		if errors.Is(err, errExchange) {
+			slog.ErrorContext(r.Context(), "Unable to retrieve token from web", "host", host, "error", err)
+			http.Error(w, "Unable to retrieve token", http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Unable to store oauth token", "host", host, "error", err)
+			http.Error(w, "Unable to store token", http.StatusInternalServerError)
+			return
+		}
+
+		m.mutex.Lock()
+		callbacks := append([]func(context.Context, string){}, m.onConnect...)
+		m.mutex.Unlock()
+		// The callbacks outlive the request but keep its ID for logging.
+		ctx := context.WithoutCancel(r.Context())
+		for _, fn := range callbacks {
+			go fn(ctx, host)
+		}
+
+		http.Redirect(w, r, m.basePath+"/book/"+host, http.StatusFound)
+	})
+}
+
+// ConsentURL is the address of Google's consent screen for host, for
+// connecting a calendar without going through the server, such as from the
+// command line. Google redirects to the callback URL with a state and a code
+// to pass to Connect; the server need not be running for them to show up in
+// the browser's address bar.
+func (m *Manager) ConsentURL(host string) (authURL, state string, err error) {
+	if !m.hosts[host] {
+		return "", "", fmt.Errorf("unknown host %q", host)
+	}
+	state, err = m.newState(host)
+	if err != nil {
+		return "", "", err
+	}
+	return m.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce), state, nil
+}
+
+// Connect exchanges the code Google redirected to the callback with and
+// stores the token for the host state was issued to by ConsentURL.
+func (m *Manager) Connect(ctx context.Context, state, code string) (string, error) {
+	host, ok := m.consumeState(state)
+	if !ok {
+		return "", errors.New("invalid or expired OAuth state")
+	}
+	return host, m.authorize(ctx, host, code)
+}
+
+var errExchange = errors.New("unable to retrieve token")
+
+// authorize exchanges an authorization code for a token and stores it for
+// host.
+func (m *Manager) authorize(ctx context.Context, host, code string) error {
+	tok, err := m.config.Exchange(ctx, code)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errExchange, err)
+	}
+	if err := m.store.Save(host, tok); err != nil {
+		return fmt.Errorf("unable to store token: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) newState(host string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for s, pending := range m.states {
+		if now.After(pending.expires) {
+			delete(m.states, s)
+		}
+	}
+	m.states[state] = pendingState{host: host, expires: now.Add(stateTTL)}
+	return state, nil
+}
+
+// consumeState returns the host state was issued for, if it was issued by
+// this server and has not expired. A state can only be used once.
+func (m *Manager) consumeState(state string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	pending, ok := m.states[state]
+	delete(m.states, state)
+	if !ok || time.Now().After(pending.expires) {
+		return "", false
+	}
+	return pending.host, true
+}
+
+// persistingTokenSource saves the token whenever the underlying source
+// refreshes it, so restarts pick up the latest access token.
+type persistingTokenSource struct {
+	base  oauth2.TokenSource
+	store *TokenStore
+	host  string
+	mutex sync.Mutex
+	last  string
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if tok.AccessToken != s.last {
+		if err := s.store.Save(s.host, tok); err != nil {
+			slog.Error("Unable to store refreshed oauth token", "host", s.host, "error", err)
+		} else {
+			s.last = tok.AccessToken
+		}
+	}
+	return tok, nil
+}
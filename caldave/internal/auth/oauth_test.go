@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newTestManager connects hosts against a token endpoint that hands out a
+// token for any code.
+func newTestManager(t *testing.T, basePath string) *Manager {
+	t.Helper()
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"a1","refresh_token":"r1","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(tokens.Close)
+
+	store, err := NewTokenStore(t.TempDir(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{
+		config: &oauth2.Config{
+			ClientID:    "client",
+			RedirectURL: "https://cal.example.com" + basePath + "/auth/google/callback",
+			Endpoint:    oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: tokens.URL},
+		},
+		secure:   true,
+		basePath: basePath,
+		store:    store,
+		hosts:    map[string]bool{"dave": true, "ada": true},
+		states:   make(map[string]pendingState),
+	}
+}
+
+// login starts an admin session and returns its cookie and CSRF token.
+func login(t *testing.T, sessions *Sessions) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := sessions.Start(w, httptest.NewRequest(http.MethodPost, "/admin/login", nil)); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	sess := sessions.sessions[cookie.Value]
+	return cookie, sess.csrf
+}
+
+func TestOAuthStartRequiresAdmin(t *testing.T) {
+	m := newTestManager(t, "")
+	sessions := NewSessions(time.Hour, "/admin")
+	mux := http.NewServeMux()
+	mux.Handle("POST /admin/hosts/{host}/connect", m.StartHandler(sessions, "/admin/login"))
+	start := func(host string, cookie *http.Cookie, csrf string) *httptest.ResponseRecorder {
+		form := url.Values{"csrf": {csrf}}
+		r := httptest.NewRequest(http.MethodPost, "/admin/hosts/"+host+"/connect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	cookie, csrf := login(t, sessions)
+	if w := start("dave", nil, csrf); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/login" {
+		t.Errorf("Expected a start without a session to be sent to the login, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := start("dave", cookie, "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a start without the CSRF token to be refused, got %d", w.Code)
+	}
+	if len(m.states) != 0 {
+		t.Errorf("Expected no state to be issued, got %d", len(m.states))
+	}
+
+	if w := start("bob", cookie, csrf); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown host to be refused, got %d", w.Code)
+	}
+
+	w := start("dave", cookie, csrf)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect to Google, got %d: %s", w.Code, w.Body)
+	}
+	consent, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || consent.Host != "accounts.example.com" {
+		t.Fatalf("Unexpected consent URL %q", w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != consent.Query().Get("state") || !cookies[0].Secure {
+		t.Errorf("Expected a secure cookie holding the state, got %v", cookies)
+	}
+}
+
+func TestOAuthCallbackState(t *testing.T) {
+	m := newTestManager(t, "")
+	callback := func(state string, cookie *http.Cookie) int {
+		r := httptest.NewRequest(http.MethodGet, "/auth/google/callback?code=c1&state="+state, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		m.CallbackHandler().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	state, err := m.newState("dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := callback(state, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected a callback without the state cookie to fail, got %d", code)
+	}
+	if code := callback(state, &http.Cookie{Name: stateCookie, Value: "other"}); code != http.StatusBadRequest {
+		t.Errorf("Expected a callback with another state cookie to fail, got %d", code)
+	}
+	forged := "forged"
+	if code := callback(forged, &http.Cookie{Name: stateCookie, Value: forged}); code != http.StatusBadRequest {
+		t.Errorf("Expected a state the server did not issue to fail, got %d", code)
+	}
+	if _, err := m.store.Load("dave"); err == nil {
+		t.Fatal("Expected no token to be stored")
+	}
+
+	state, err = m.newState("dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := callback(state, &http.Cookie{Name: stateCookie, Value: state}); code != http.StatusFound {
+		t.Fatalf("Expected the callback to succeed, got %d", code)
+	}
+	if tok, err := m.store.Load("dave"); err != nil || tok.AccessToken != "a1" {
+		t.Errorf("Expected the token to be stored, got %v, %v", tok, err)
+	}
+	if code := callback(state, &http.Cookie{Name: stateCookie, Value: state}); code != http.StatusBadRequest {
+		t.Errorf("Expected a state to be usable only once, got %d", code)
+	}
+}
+
+func TestOAuthFlowWithBasePath(t *testing.T) {
+	const basePath = "/caldave"
+	m := newTestManager(t, basePath)
+	sessions := NewSessions(time.Hour, basePath+"/admin")
+	mux := http.NewServeMux()
+	mux.Handle("POST "+basePath+"/admin/hosts/{host}/connect", m.StartHandler(sessions, basePath+"/admin/login"))
+	mux.Handle("GET "+basePath+"/auth/google/callback", m.CallbackHandler())
+	session, csrf := login(t, sessions)
+
+	form := url.Values{"csrf": {csrf}}
+	r := httptest.NewRequest(http.MethodPost, "https://cal.example.com"+basePath+"/admin/hosts/dave/connect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect to Google, got %d: %s", w.Code, w.Body)
+	}
+	consent, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || consent.Host != "accounts.example.com" {
+		t.Fatalf("Unexpected consent URL %q", w.Header().Get("Location"))
+	}
+
+	// The browser must send the state cookie back to the callback.
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(r.URL, w.Result().Cookies())
+	callback, _ := url.Parse("https://cal.example.com" + basePath + "/auth/google/callback?code=c1&state=" + consent.Query().Get("state"))
+	r = httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	for _, c := range jar.Cookies(callback) {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != basePath+"/book/dave" {
+		t.Fatalf("Expected a redirect to the booking page, got %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	if _, err := m.store.Load("dave"); err != nil {
+		t.Errorf("Expected the token to be stored, got %v", err)
+	}
+	if cleared := w.Result().Cookies(); len(cleared) != 1 || cleared[0].Path != basePath+"/auth/google" {
+		t.Errorf("Expected the state cookie to be cleared under the base path, got %v", cleared)
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "caldave_admin"
+
+// Sessions keeps the logged in admin sessions in memory, so everyone is
+// logged out when the server restarts.
+type Sessions struct {
+	Secure bool // Only send the cookie over HTTPS, also when TLS ends at a proxy
+
+	ttl      time.Duration
+	path     string // Of the cookie
+	mutex    sync.Mutex
+	sessions map[string]session
+}
+
+type session struct {
+	csrf    string // Must be sent back with every form
+	flash   Flash
+	expires time.Time
+}
+
+// Flash is a message for the next page the session loads, such as the
+// outcome of a form it posted.
+type Flash struct {
+	Notice string
+	Error  string
+}
+
+type csrfKey struct{}
+
+// NewSessions keeps sessions for ttl, with a cookie sent only to paths under
+// path.
+func NewSessions(ttl time.Duration, path string) *Sessions {
+	return &Sessions{
+		ttl:      ttl,
+		path:     path,
+		sessions: make(map[string]session),
+	}
+}
+
+// Start logs the visitor in by setting a new session cookie.
+func (s *Sessions) Start(w http.ResponseWriter, r *http.Request) error {
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[id] = session{csrf: csrf, expires: now.Add(s.ttl)}
+	s.mutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     s.path,
+		MaxAge:   int(s.ttl / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || s.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// End logs the visitor out.
+func (s *Sessions) End(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		s.mutex.Lock()
+		delete(s.sessions, cookie.Value)
+		s.mutex.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: s.path, MaxAge: -1})
+}
+
+func (s *Sessions) lookup(r *http.Request) (session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sess, ok := s.sessions[cookie.Value]
+	if !ok || time.Now().After(sess.expires) {
+		delete(s.sessions, cookie.Value)
+		return session{}, false
+	}
+	return sess, true
+}
+
+// SetFlash stores f for the next TakeFlash of the request's session.
+func (s *Sessions) SetFlash(r *http.Request, f Flash) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sess, ok := s.sessions[cookie.Value]; ok {
+		sess.flash = f
+		s.sessions[cookie.Value] = sess
+	}
+}
+
+// TakeFlash returns and clears the flash of the request's session.
+func (s *Sessions) TakeFlash(r *http.Request) Flash {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return Flash{}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sess, ok := s.sessions[cookie.Value]
+	if !ok {
+		return Flash{}
+	}
+	f := sess.flash
+	sess.flash = Flash{}
+	s.sessions[cookie.Value] = sess
+	return f
+}
+
+// Require sends visitors without a session to loginPath. Requests other than
+// GET must carry the session's CSRF token in the "csrf" form field.
+func (s *Sessions) Require(loginPath string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := s.lookup(r)
+		if !ok {
+			http.Redirect(w, r, loginPath, http.StatusSeeOther)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead &&
+			subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(sess.csrf)) != 1 {
+			http.Error(w, "Invalid form, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, sess.csrf)))
+	})
+}
+
+// CSRFToken returns the token forms on pages behind Require must include.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
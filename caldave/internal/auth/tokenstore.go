@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNoToken is returned when no token has been stored yet.
+var ErrNoToken = errors.New("auth: no token stored")
+
+// TokenStore keeps one OAuth token per host on disk, encrypted with AES-GCM.
+type TokenStore struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// NewTokenStore returns a store writing into dir. The encryption key is
+// derived from secret, which must not be empty.
+func NewTokenStore(dir, secret string) (*TokenStore, error) {
+	if secret == "" {
+		return nil, errors.New("auth: TOKEN_SECRET must be set to store tokens")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenStore{dir: dir, aead: aead}, nil
+}
+
+func (s *TokenStore) path(host string) string {
+	return filepath.Join(s.dir, host+".enc")
+}
+
+// Load reads and decrypts the token stored for host.
+func (s *TokenStore) Load(host string) (*oauth2.Token, error) {
+	path := s.path(host)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("auth: token file %s is corrupt", path)
+	}
+	plain, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("auth: unable to decrypt token file %s: %w", path, err)
+	}
+
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(plain, tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// Save encrypts the token and replaces the one stored for host.
+func (s *TokenStore) Save(host string, tok *oauth2.Token) error {
+	plain, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := s.aead.Seal(nonce, nonce, plain, nil)
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	path := s.path(host)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
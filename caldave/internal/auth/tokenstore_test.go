@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTokenStorePerHost(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewTokenStore(dir, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("dave", &oauth2.Token{AccessToken: "dave"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("ada"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Expected no token for another host, got %v", err)
+	}
+	if tok, err := store.Load("dave"); err != nil || tok.AccessToken != "dave" {
+		t.Errorf("Expected the host's token, got %v, %v", tok, err)
+	}
+
+	other, err := NewTokenStore(dir, "other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Load("dave"); err == nil {
+		t.Error("Expected a token encrypted with another secret not to load")
+	}
+}
@@ -0,0 +1,237 @@
+// Package availability works out free time as sets of time intervals.
+// Opening hours, busy events and bookings are all intervals of absolute
+// time, so scheduling rules compose as set operations: free time is the
+// opening hours minus the busy time, a collective team is free when all of
+// its members are, and bookable slots are free time split into pieces.
+package availability
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Interval is the half-open span of time [Start, End).
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Empty reports whether the interval covers no time.
+func (i Interval) Empty() bool {
+	return !i.Start.Before(i.End)
+}
+
+// Duration returns how long the interval is, zero if it is empty.
+func (i Interval) Duration() time.Duration {
+	if i.Empty() {
+		return 0
+	}
+	return i.End.Sub(i.Start)
+}
+
+// Contains reports whether t falls inside the interval.
+func (i Interval) Contains(t time.Time) bool {
+	return !t.Before(i.Start) && t.Before(i.End)
+}
+
+// Covers reports whether all of j falls inside the interval. Empty intervals
+// are covered by everything.
+func (i Interval) Covers(j Interval) bool {
+	return j.Empty() || (!j.Start.Before(i.Start) && !j.End.After(i.End))
+}
+
+// Overlaps reports whether the intervals share any time. Intervals that only
+// touch do not overlap.
+func (i Interval) Overlaps(j Interval) bool {
+	return i.Start.Before(j.End) && j.Start.Before(i.End)
+}
+
+// Grow widens the interval by before at the start and after at the end.
+// Negative durations shrink it.
+func (i Interval) Grow(before, after time.Duration) Interval {
+	return Interval{Start: i.Start.Add(-before), End: i.End.Add(after)}
+}
+
+// In returns the interval with both ends in loc.
+func (i Interval) In(loc *time.Location) Interval {
+	return Interval{Start: i.Start.In(loc), End: i.End.In(loc)}
+}
+
+func (i Interval) String() string {
+	return i.Start.Format(time.RFC3339) + "/" + i.End.Format(time.RFC3339)
+}
+
+// Set is a union of intervals, kept sorted with no empty, overlapping or
+// touching intervals. The zero value is the empty set. Build sets with
+// NewSet or the set operations rather than by hand.
+type Set []Interval
+
+// NewSet returns the union of the intervals, which may be in any order and
+// overlap.
+func NewSet(intervals ...Interval) Set {
+	sorted := make([]Interval, 0, len(intervals))
+	for _, i := range intervals {
+		if !i.Empty() {
+			sorted = append(sorted, i)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Start.Before(sorted[b].Start)
+	})
+
+	var s Set
+	for _, i := range sorted {
+		if n := len(s); n > 0 && !i.Start.After(s[n-1].End) {
+			if i.End.After(s[n-1].End) {
+				s[n-1].End = i.End
+			}
+			continue
+		}
+		s = append(s, i)
+	}
+	return s
+}
+
+// Union returns the time covered by either set.
+func (s Set) Union(t Set) Set {
+	return NewSet(append(append([]Interval{}, s...), t...)...)
+}
+
+// Intersect returns the time covered by both sets.
+func (s Set) Intersect(t Set) Set {
+	var out Set
+	i, j := 0, 0
+	for i < len(s) && j < len(t) {
+		start := latest(s[i].Start, t[j].Start)
+		end := earliest(s[i].End, t[j].End)
+		if start.Before(end) {
+			out = append(out, Interval{Start: start, End: end})
+		}
+		if s[i].End.Before(t[j].End) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// Subtract returns the time covered by s but not by t.
+func (s Set) Subtract(t Set) Set {
+	var out Set
+	j := 0
+	for _, i := range s {
+		// Intervals of t that end before i starts cannot affect the rest of
+		// s either.
+		for j < len(t) && !t[j].End.After(i.Start) {
+			j++
+		}
+		start := i.Start
+		for k := j; k < len(t) && t[k].Start.Before(i.End); k++ {
+			if t[k].Start.After(start) {
+				out = append(out, Interval{Start: start, End: t[k].Start})
+			}
+			start = latest(start, t[k].End)
+		}
+		if start.Before(i.End) {
+			out = append(out, Interval{Start: start, End: i.End})
+		}
+	}
+	return out
+}
+
+// Clamp returns the part of s inside i.
+func (s Set) Clamp(i Interval) Set {
+	return s.Intersect(NewSet(i))
+}
+
+// Covers reports whether all of i falls inside one interval of s.
+func (s Set) Covers(i Interval) bool {
+	if i.Empty() {
+		return true
+	}
+	n := sort.Search(len(s), func(k int) bool { return s[k].End.After(i.Start) })
+	return n < len(s) && s[n].Covers(i)
+}
+
+// Duration returns the total time covered by s.
+func (s Set) Duration() time.Duration {
+	var d time.Duration
+	for _, i := range s {
+		d += i.Duration()
+	}
+	return d
+}
+
+// Split cuts every interval of s into back to back slots of the given
+// length, starting at the interval's start. Leftover time shorter than a
+// slot is dropped.
+func (s Set) Split(length time.Duration) []Interval {
+	if length <= 0 {
+		return nil
+	}
+	var slots []Interval
+	for _, i := range s {
+		for t := i.Start; !t.Add(length).After(i.End); t = t.Add(length) {
+			slots = append(slots, Interval{Start: t, End: t.Add(length)})
+		}
+	}
+	return slots
+}
+
+// In returns s with every interval in loc.
+func (s Set) In(loc *time.Location) Set {
+	out := make(Set, len(s))
+	for k, i := range s {
+		out[k] = i.In(loc)
+	}
+	return out
+}
+
+// Window places a "15:04" to "15:04" opening window on the day of date, in
+// date's location. Wall clock times that do not exist because of a
+// daylight saving change are moved the way time.Date moves them.
+func Window(date time.Time, start, end string) (Interval, error) {
+	s, err := clockTime(date, start)
+	if err != nil {
+		return Interval{}, err
+	}
+	e, err := clockTime(date, end)
+	if err != nil {
+		return Interval{}, err
+	}
+	if e.Before(s) {
+		return Interval{}, fmt.Errorf("window ends at %s before it starts at %s", end, start)
+	}
+	return Interval{Start: s, End: e}, nil
+}
+
+// Day returns the interval from midnight on date to midnight the next day,
+// in date's location.
+func Day(date time.Time) Interval {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return Interval{Start: start, End: start.AddDate(0, 0, 1)}
+}
+
+func clockTime(date time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, date.Location()), nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
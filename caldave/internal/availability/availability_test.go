@@ -0,0 +1,187 @@
+package availability
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 10, 11, 0, 0, 0, 0, time.UTC)
+
+// iv builds an interval from hours after midnight on base.
+func iv(start, end float64) Interval {
+	return Interval{
+		Start: base.Add(time.Duration(start * float64(time.Hour))),
+		End:   base.Add(time.Duration(end * float64(time.Hour))),
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Set
+		want Set
+	}{
+		{"new set drops empty intervals", NewSet(iv(9, 9), iv(10, 9)), nil},
+		{"new set sorts and merges", NewSet(iv(13, 14), iv(9, 11), iv(10, 12)), Set{iv(9, 12), iv(13, 14)}},
+		{"new set merges touching intervals", NewSet(iv(9, 10), iv(10, 11)), Set{iv(9, 11)}},
+		{"union", NewSet(iv(9, 10)).Union(NewSet(iv(9.5, 11), iv(12, 13))), Set{iv(9, 11), iv(12, 13)}},
+		{"intersect", NewSet(iv(9, 12), iv(13, 17)).Intersect(NewSet(iv(11, 14), iv(16, 18))), Set{iv(11, 12), iv(13, 14), iv(16, 17)}},
+		{"intersect touching", NewSet(iv(9, 10)).Intersect(NewSet(iv(10, 11))), nil},
+		{"subtract from the middle", NewSet(iv(9, 17)).Subtract(NewSet(iv(12, 13))), Set{iv(9, 12), iv(13, 17)}},
+		{"subtract over the ends", NewSet(iv(9, 17)).Subtract(NewSet(iv(8, 10), iv(16, 18))), Set{iv(10, 16)}},
+		{"subtract spanning several", NewSet(iv(9, 10), iv(11, 12), iv(13, 14)).Subtract(NewSet(iv(9.5, 13.5))), Set{iv(9, 9.5), iv(13.5, 14)}},
+		{"subtract everything", NewSet(iv(9, 17)).Subtract(NewSet(iv(0, 24))), nil},
+		{"subtract nothing", NewSet(iv(9, 17)).Subtract(nil), Set{iv(9, 17)}},
+		{"clamp", NewSet(iv(6, 10), iv(22, 26)).Clamp(iv(0, 24)), Set{iv(6, 10), iv(22, 24)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	free := NewSet(iv(9, 10.75), iv(13, 13.25))
+	want := []Interval{iv(9, 9.5), iv(9.5, 10), iv(10, 10.5)}
+	if got := free.Split(30 * time.Minute); !reflect.DeepEqual(got, want) {
+		t.Errorf("Split() = %v, want %v", got, want)
+	}
+	if got := free.Split(0); got != nil {
+		t.Errorf("Split(0) = %v, want nothing", got)
+	}
+}
+
+func TestCovers(t *testing.T) {
+	free := NewSet(iv(9, 12), iv(13, 17))
+	tests := []struct {
+		i    Interval
+		want bool
+	}{
+		{iv(9, 12), true},
+		{iv(10, 11), true},
+		{iv(11, 14), false},
+		{iv(12, 13), false},
+		{iv(16, 18), false},
+		{iv(20, 20), true},
+	}
+	for _, tt := range tests {
+		if got := free.Covers(tt.i); got != tt.want {
+			t.Errorf("Covers(%v) = %v, want %v", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+
+	// The clocks go back an hour on 27 October 2024, so the day is 25 hours
+	// long and a 00:00 to 03:00 window lasts four.
+	date := time.Date(2024, 10, 27, 0, 0, 0, 0, london)
+	w, err := Window(date, "00:00", "03:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Duration() != 4*time.Hour {
+		t.Errorf("Expected the window to last 4h, got %v", w.Duration())
+	}
+	if d := Day(date).Duration(); d != 25*time.Hour {
+		t.Errorf("Expected the day to last 25h, got %v", d)
+	}
+
+	for _, hours := range [][2]string{{"9:00am", "17:00"}, {"09:00", ""}, {"17:00", "09:00"}} {
+		if _, err := Window(date, hours[0], hours[1]); err == nil {
+			t.Errorf("Expected an error for %s to %s", hours[0], hours[1])
+		}
+	}
+}
+
+// randomSet returns a set built from a few random intervals on a quarter
+// hour grid, so that touching and equal ends come up often.
+func randomSet(r *rand.Rand) Set {
+	intervals := make([]Interval, r.Intn(5))
+	for k := range intervals {
+		start := r.Intn(96)
+		intervals[k] = iv(float64(start)/4, float64(start+r.Intn(24))/4)
+	}
+	return NewSet(intervals...)
+}
+
+// contains reports whether t falls inside s by checking every interval, as
+// the reference the set operations are compared with.
+func contains(s Set, t time.Time) bool {
+	for _, i := range s {
+		if i.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalized reports whether s is sorted with no empty, overlapping or
+// touching intervals.
+func normalized(s Set) bool {
+	for k, i := range s {
+		if i.Empty() || (k > 0 && !s[k-1].End.Before(i.Start)) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	day := iv(6, 18)
+
+	for n := 0; n < 1000; n++ {
+		a, b := randomSet(r), randomSet(r)
+		union, intersection, difference, clamped := a.Union(b), a.Intersect(b), a.Subtract(b), a.Clamp(day)
+
+		for _, s := range []Set{union, intersection, difference, clamped} {
+			if !normalized(s) {
+				t.Fatalf("Expected a normalized set, got %v from %v and %v", s, a, b)
+			}
+		}
+
+		// Every operation agrees with its definition at every point of the
+		// grid, including the ends of the intervals.
+		for q := 0; q < 30*4; q++ {
+			p := base.Add(time.Duration(q) * 15 * time.Minute)
+			inA, inB := contains(a, p), contains(b, p)
+			if contains(union, p) != (inA || inB) {
+				t.Fatalf("Union of %v and %v is wrong at %v: %v", a, b, p, union)
+			}
+			if contains(intersection, p) != (inA && inB) {
+				t.Fatalf("Intersection of %v and %v is wrong at %v: %v", a, b, p, intersection)
+			}
+			if contains(difference, p) != (inA && !inB) {
+				t.Fatalf("Difference of %v and %v is wrong at %v: %v", a, b, p, difference)
+			}
+			if contains(clamped, p) != (inA && day.Contains(p)) {
+				t.Fatalf("%v clamped to %v is wrong at %v: %v", a, day, p, clamped)
+			}
+		}
+
+		// Removing b and adding back what was shared gives back a.
+		if got := difference.Union(intersection); !reflect.DeepEqual(got, a) {
+			t.Fatalf("Expected %v, got %v", a, got)
+		}
+		if a.Duration()+b.Duration() != union.Duration()+intersection.Duration() {
+			t.Fatalf("Durations of %v and %v do not add up", a, b)
+		}
+
+		// Slots are back to back, inside the set and as long as asked for.
+		for _, slot := range a.Split(time.Hour) {
+			if slot.Duration() != time.Hour || !a.Covers(slot) {
+				t.Fatalf("Bad slot %v from %v", slot, a)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package certs serves the server's TLS certificate and reloads it when its
+// files change on disk, so renewed certificates are picked up without a
+// restart.
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader hands out the certificate in a pair of PEM files, reading them
+// again once they change.
+type Reloader struct {
+	CheckEvery time.Duration // How often handshakes look at the files, 0 for every handshake
+
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	loaded  [2]fileVersion // Of the certificate and key files behind cert
+	checked time.Time
+}
+
+// fileVersion tells whether a file has changed since it was read.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// New loads the certificate and key, failing if they cannot be used.
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{CheckEvery: 10 * time.Second, certFile: certFile, keyFile: keyFile}
+	versions, err := r.versions()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(versions); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig serves the certificate. HTTP/2 is offered on top of it by
+// http.Server.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate, first reloading it if
+// its files have changed. A pair that fails to load, such as while only one
+// of them has been replaced, is logged and the previous one kept until the
+// next check.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) < r.CheckEvery {
+		return r.cert, nil
+	}
+	r.checked = now
+
+	versions, err := r.versions()
+	if err != nil {
+		slog.Error("Error checking TLS certificate", "error", err)
+		return r.cert, nil
+	}
+	if versions == r.loaded {
+		return r.cert, nil
+	}
+	if err := r.load(versions); err != nil {
+		slog.Error("Error reloading TLS certificate, keeping the previous one", "error", err)
+		return r.cert, nil
+	}
+	slog.Info("Reloaded TLS certificate", "cert_file", r.certFile, "expires", r.cert.Leaf.NotAfter)
+	return r.cert, nil
+}
+
+func (r *Reloader) versions() ([2]fileVersion, error) {
+	var versions [2]fileVersion
+	for i, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return versions, err
+		}
+		versions[i] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+	}
+	return versions, nil
+}
+
+// load reads the pair as it was at versions. Callers must hold the mutex,
+// apart from New.
+func (r *Reloader) load(versions [2]fileVersion) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.loaded = versions
+	return nil
+}
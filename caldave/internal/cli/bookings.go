@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/handlers"
+	"caldave/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+func runBookingsList(_ context.Context, e *env, args []string) error {
+	fs := e.flags("bookings list")
+	host := fs.String("host", "", "only bookings a host attends or made on a team's page")
+	status := fs.String("status", "", "only bookings with this status, such as pending")
+	all := fs.Bool("all", false, "include bookings that have ended")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	hostsFile, err := config.LoadHosts(e.cfg.HostsFile)
+	if err != nil {
+		return err
+	}
+	// Reading is safe while the server runs, as it replaces the file whole.
+	st, err := store.Open(e.cfg.StoreFile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	bookings := st.Bookings(func(b store.Booking) bool {
+		return (*all || b.End.After(now)) &&
+			(*status == "" || b.Status == *status) &&
+			(*host == "" || b.Team == *host || b.Involves(*host))
+	})
+	slices.SortFunc(bookings, func(a, b store.Booking) int { return a.Start.Compare(b.Start) })
+
+	locations := ownerLocations(hostsFile)
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART\tEND\tHOST\tEVENT\tNAME\tEMAIL\tSTATUS")
+	for _, b := range bookings {
+		owner := b.Host
+		if b.Team != "" {
+			owner = b.Team
+		}
+		loc := locations[owner]
+		if loc == nil {
+			loc = time.Local
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", b.ID,
+			b.Start.In(loc).Format("2006-01-02 15:04 MST"), b.End.In(loc).Format("15:04"),
+			owner, b.EventType, b.Name, b.Email, b.Status)
+	}
+	return w.Flush()
+}
+
+// ownerLocations maps each host and team to the time zone of its page.
+func ownerLocations(hostsFile *config.HostsFile) map[string]*time.Location {
+	locations := make(map[string]*time.Location)
+	add := func(id, name string) {
+		if loc, err := time.LoadLocation(name); err == nil {
+			locations[id] = loc
+		}
+	}
+	for _, h := range hostsFile.Hosts {
+		add(h.ID, h.TimeZone)
+	}
+	for _, t := range hostsFile.Teams {
+		add(t.ID, t.TimeZone)
+	}
+	return locations
+}
+
+// runBookingsCancel cancels a confirmed booking through the admin API, so
+// the running server emails the booker, updates the booking pages and
+// offers the seat to the waitlist, and its copy of the store stays current.
+func runBookingsCancel(ctx context.Context, e *env, args []string) error {
+	fs := e.flags("bookings cancel")
+	serverURL := fs.String("server", e.cfg.BaseURL, "URL of the running server")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(e.stderr, "Usage: caldave bookings cancel [-server url] id")
+		return ErrUsage
+	}
+	if e.cfg.AdminToken == "" {
+		return errors.New("ADMIN_TOKEN must be set to cancel bookings through the admin API")
+	}
+
+	endpoint, err := url.JoinPath(*serverURL, "admin/api/bookings", fs.Arg(0), "cancel")
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+e.cfg.AdminToken)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure handlers.BookingErrorData
+		if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("server answered %s", resp.Status)
+		}
+		return errors.New(failure.Error)
+	}
+	var b store.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return fmt.Errorf("reading the cancelled booking: %w", err)
+	}
+	fmt.Fprintf(e.stdout, "Cancelled %s, %s for %s on %s\n", b.ID, b.EventType, b.Name, b.Start.Local().Format("2006-01-02 15:04 MST"))
+	return nil
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bufio"
+	"caldave/internal/auth"
+	"caldave/internal/config"
+	"caldave/internal/handlers"
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// loadAuth reads the hosts file and sets up the OAuth tokens the server
+// uses. host defaults to the first host in the file.
+func (e *env) loadAuth(host string) (*config.HostsFile, *auth.Manager, string, error) {
+	hostsFile, err := config.LoadHosts(e.cfg.HostsFile)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	manager, err := auth.NewManager(e.cfg, hostsFile.Hosts)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if host == "" {
+		host = hostsFile.Hosts[0].ID
+	}
+	return hostsFile, manager, host, nil
+}
+
+func runAuth(ctx context.Context, e *env, args []string) error {
+	fs := e.flags("auth")
+	host := fs.String("host", "", "host whose calendar to connect, the first in the hosts file by default")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	_, manager, id, err := e.loadAuth(*host)
+	if err != nil {
+		return err
+	}
+
+	consentURL, state, err := manager.ConsentURL(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Open this page and allow caldave to read the calendars of %s:\n\n  %s\n\n", id, consentURL)
+	fmt.Fprint(e.stdout, "Google then sends your browser to the callback URL. Paste that address here: ")
+
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	if strings.TrimSpace(line) == "" {
+		if err == nil {
+			err = errors.New("nothing entered")
+		}
+		return err
+	}
+	gotState, code, err := parseRedirect(strings.TrimSpace(line), state)
+	if err != nil {
+		return err
+	}
+	if gotState != state {
+		return errors.New("that address is from another authorization, run caldave auth again")
+	}
+	if _, err := manager.Connect(ctx, state, code); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Connected the calendar of %s. A running server picks it up once restarted.\n", id)
+	return nil
+}
+
+// parseRedirect reads the state and code from the address Google redirected
+// to. A bare code is taken to belong to state.
+func parseRedirect(input, state string) (string, string, error) {
+	u, err := url.Parse(input)
+	if err != nil || !u.Query().Has("code") && !u.Query().Has("error") {
+		return state, input, nil
+	}
+	q := u.Query()
+	if reason := q.Get("error"); reason != "" {
+		return "", "", fmt.Errorf("authorization denied: %s", reason)
+	}
+	return q.Get("state"), q.Get("code"), nil
+}
+
+func runCalendars(ctx context.Context, e *env, args []string) error {
+	fs := e.flags("calendars")
+	host := fs.String("host", "", "host whose calendars to list, the first in the hosts file by default")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	_, manager, id, err := e.loadAuth(*host)
+	if err != nil {
+		return err
+	}
+
+	client, err := manager.Client(ctx, id)
+	if errors.Is(err, auth.ErrNoToken) {
+		return fmt.Errorf("%s has not connected a calendar, run caldave auth -host %s", id, id)
+	}
+	if err != nil {
+		return err
+	}
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return err
+	}
+	calendars, err := utils.GetCalendars(ctx, srv)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME")
+	for _, c := range calendars {
+		fmt.Fprintf(w, "%s\t%s\n", c.CalendarID, c.CalendarName)
+	}
+	return w.Flush()
+}
+
+func runAvailability(ctx context.Context, e *env, args []string) error {
+	fs := e.flags("availability")
+	host := fs.String("host", "", "host or team, the first host in the hosts file by default")
+	eventType := fs.String("event-type", "", "event type slug, the first one by default")
+	date := fs.String("date", time.Now().Format("2006-01-02"), "day to look at")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	hostsFile, manager, _, err := e.loadAuth("")
+	if err != nil {
+		return err
+	}
+	st, err := store.Open(e.cfg.StoreFile)
+	if err != nil {
+		return err
+	}
+	calendars, err := handlers.NewCalendars(manager, hostsFile, st)
+	if err != nil {
+		return err
+	}
+
+	day, loc, err := calendars.Availability(ctx, *host, *eventType, *date)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "%s on %s, times in %s\n", day.EventType, day.Date, loc)
+	if len(day.Slots) == 0 && len(day.Waitlist) == 0 {
+		fmt.Fprintln(e.stdout, "No free slots")
+		return nil
+	}
+	for _, slot := range day.Slots {
+		if slot.Seats > 0 {
+			fmt.Fprintf(e.stdout, "%s-%s  %d seats left\n", slot.Start, slot.End, slot.Seats)
+		} else {
+			fmt.Fprintf(e.stdout, "%s-%s\n", slot.Start, slot.End)
+		}
+	}
+	for _, slot := range day.Waitlist {
+		fmt.Fprintf(e.stdout, "%s-%s  full, waitlist open\n", slot.Start, slot.End)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Package cli runs caldave's subcommands for looking after a deployment
+// from the command line. They read the same configuration as the server and
+// share its code, so what they report is what the server would do.
+package cli
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/server"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUsage is returned when the command line could not be understood. The
+// usage has already been printed.
+var ErrUsage = errors.New("invalid usage")
+
+// env is what a command runs with.
+type env struct {
+	cfg    *config.Config
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	name    string // One or two words, such as "bookings list"
+	args    string
+	summary string
+	run     func(ctx context.Context, e *env, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "serve", summary: "Run the server (the default)"},
+		{name: "auth", args: "[-host id]", summary: "Connect a host's Google Calendar", run: runAuth},
+		{name: "calendars", args: "[-host id]", summary: "List the calendars a host has connected", run: runCalendars},
+		{name: "availability", args: "[-host id] [-event-type slug] [-date YYYY-MM-DD]", summary: "Print the free slots of a host or team", run: runAvailability},
+		{name: "bookings list", args: "[-host id] [-status status] [-all]", summary: "List upcoming bookings", run: runBookingsList},
+		{name: "bookings cancel", args: "[-server url] id", summary: "Cancel a booking through the running server", run: runBookingsCancel},
+		{name: "config validate", summary: "Check the configuration and hosts file", run: runConfigValidate},
+		{name: "help", summary: "Show this help", run: func(_ context.Context, e *env, _ []string) error {
+			printUsage(e.stdout)
+			return nil
+		}},
+	}
+}
+
+// Run runs the command named at the start of args, such as "bookings list
+// -host dave". The server is run by main itself.
+func Run(ctx context.Context, cfg *config.Config, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	e := &env{cfg: cfg, stdin: stdin, stdout: stdout, stderr: stderr}
+	cmd, rest, ok := lookup(args)
+	if !ok || cmd.run == nil {
+		if len(args) > 0 {
+			fmt.Fprintf(stderr, "Unknown command %q\n\n", strings.Join(args[:min(2, len(args))], " "))
+		}
+		printUsage(stderr)
+		return ErrUsage
+	}
+	err := cmd.run(ctx, e, rest)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// lookup finds the command args start with, preferring two word commands.
+func lookup(args []string) (command, []string, bool) {
+	for _, n := range []int{2, 1} {
+		if len(args) < n {
+			continue
+		}
+		name := strings.Join(args[:n], " ")
+		for _, cmd := range commands {
+			if cmd.name == name {
+				return cmd, args[n:], true
+			}
+		}
+	}
+	return command{}, nil, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: caldave [command]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n", strings.TrimSpace(cmd.name+" "+cmd.args))
+		fmt.Fprintf(w, "        %s\n", cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command reads the same environment variables as the server.")
+}
+
+// flags returns the flag set of the named command, writing errors and
+// usage to stderr.
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("caldave "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+// parse parses args into fs. Mistakes come back as ErrUsage, as the flag
+// package has already explained them.
+func parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return ErrUsage
+	}
+	return err
+}
+
+func runConfigValidate(_ context.Context, e *env, args []string) error {
+	if err := parse(e.flags("config validate"), args); err != nil {
+		return err
+	}
+	hostsFile, err := server.Validate(e.cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Configuration is valid: %d hosts, %d teams, %d webhooks\n",
+		len(hostsFile.Hosts), len(hostsFile.Teams), len(hostsFile.Webhooks))
+	if e.cfg.AdminToken == "" {
+		fmt.Fprintln(e.stdout, "ADMIN_TOKEN is not set, the admin API and dashboard are disabled")
+	}
+	return nil
+}
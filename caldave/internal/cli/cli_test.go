@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{args: []string{"bookings", "list", "-all"}, name: "bookings list", rest: []string{"-all"}},
+		{args: []string{"availability", "-date", "2024-10-11"}, name: "availability", rest: []string{"-date", "2024-10-11"}},
+		{args: []string{"bookings"}},
+		{args: []string{"nope"}},
+	}
+	for _, tt := range tests {
+		cmd, rest, ok := lookup(tt.args)
+		if ok != (tt.name != "") || cmd.name != tt.name || strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+			t.Errorf("lookup(%q) = %q, %q, %v", tt.args, cmd.name, rest, ok)
+		}
+	}
+
+	var stderr bytes.Buffer
+	if err := Run(context.Background(), &config.Config{}, []string{"bookings"}, nil, &stderr, &stderr); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "bookings cancel") {
+		t.Errorf("Expected the usage to be printed, got %q", stderr.String())
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		input string
+		state string
+		code  string
+		err   bool
+	}{
+		{input: "http://localhost:8080/auth/google/callback?state=s1&code=c1&scope=x", state: "s1", code: "c1"},
+		{input: "4/0Abc-def", state: "issued", code: "4/0Abc-def"},
+		{input: "http://localhost:8080/auth/google/callback?state=s1&error=access_denied", err: true},
+	}
+	for _, tt := range tests {
+		state, code, err := parseRedirect(tt.input, "issued")
+		if (err != nil) != tt.err || state != tt.state || code != tt.code {
+			t.Errorf("parseRedirect(%q) = %q, %q, %v", tt.input, state, code, err)
+		}
+	}
+}
+
+func TestBookingsList(t *testing.T) {
+	dir := t.TempDir()
+	hosts := `{"hosts": [{"id": "dave", "name": "Dave", "timeZone": "Europe/London"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "hosts.json"), []byte(hosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{HostsFile: filepath.Join(dir, "hosts.json"), StoreFile: filepath.Join(dir, "store.json")}
+	st, err := store.Open(cfg.StoreFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	for _, b := range []store.Booking{
+		{Host: "dave", EventType: "intro", Name: "Ada", Start: start, End: start.Add(time.Hour), Status: store.StatusConfirmed},
+		{Host: "dave", EventType: "intro", Name: "Bob", Start: start.Add(time.Hour), End: start.Add(2 * time.Hour), Status: store.StatusPending},
+		{Host: "dave", EventType: "intro", Name: "Cy", Start: start.Add(-72 * time.Hour), End: start.Add(-71 * time.Hour), Status: store.StatusConfirmed},
+	} {
+		if _, err := st.AddBooking(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(args ...string) string {
+		var out bytes.Buffer
+		if err := Run(context.Background(), cfg, append([]string{"bookings", "list"}, args...), nil, &out, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if out := list(); !strings.Contains(out, "Ada") || !strings.Contains(out, "Bob") || strings.Contains(out, "Cy") {
+		t.Errorf("Expected the upcoming bookings, got:\n%s", out)
+	}
+	if out := list("-status", "pending"); strings.Contains(out, "Ada") || !strings.Contains(out, "Bob") {
+		t.Errorf("Expected only the pending booking, got:\n%s", out)
+	}
+	if out := list("-all", "-host", "dave"); !strings.Contains(out, "Cy") {
+		t.Errorf("Expected past bookings with -all, got:\n%s", out)
+	}
+	if out := list("-host", "someone"); strings.Count(out, "\n") != 1 {
+		t.Errorf("Expected only the header for another host, got:\n%s", out)
+	}
+}
+
+func TestBookingsCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/caldave/admin/api/bookings/b1/cancel" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "booking not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(store.Booking{ID: "b1", Name: "Ada", EventType: "intro", Status: store.StatusCancelled})
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{BaseURL: srv.URL + "/caldave", AdminToken: "s3cret"}
+	var out bytes.Buffer
+	if err := Run(context.Background(), cfg, []string{"bookings", "cancel", "b1"}, nil, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Cancelled b1") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+
+	err := Run(context.Background(), cfg, []string{"bookings", "cancel", "b2"}, nil, &out, &out)
+	if err == nil || err.Error() != "booking not found" {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+	cfg.AdminToken = ""
+	if err := Run(context.Background(), cfg, []string{"bookings", "cancel", "b1"}, nil, &out, &out); err == nil {
+		t.Error("Expected cancelling without ADMIN_TOKEN to fail")
+	}
+}
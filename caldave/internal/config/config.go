@@ -1,17 +1,86 @@
 package config
 
 import (
+	"net/url"
 	"os"
+	"strings"
 )
 
 type Config struct {
-	Port string
+	Port            string
+	TLSCertFile     string // PEM certificate chain; with TLSKeyFile the server speaks HTTPS and HTTP/2 itself
+	TLSKeyFile      string
+	RedirectPort    string // Port answering plain HTTP with a redirect to HTTPS, none when empty
+	BaseURL         string // Public URL of the server, used to build the OAuth redirect URL
+	BaseURLSet      bool   // Whether BASE_URL was given, rather than defaulting to localhost
+	BasePath        string // Prefix the server is mounted under behind a proxy, the path of BaseURL unless set
+	StaticDir       string // Serves the static files from this directory instead of the embedded ones, for development
+	CredentialsFile string // Google OAuth client secret file
+	HostsFile       string // JSON file listing the hosts and their schedules
+	StoreFile       string // JSON file bookings are persisted to
+	TokenDir        string // Where the encrypted OAuth tokens are stored, one file per host
+	TokenSecret     string // Secret used to encrypt the OAuth token at rest
+	AdminToken      string // Bearer token for the admin API and password for the dashboard, both disabled when empty
+	SMTPAddr        string // host:port of the mail relay for booker notifications, logged instead when empty
+	SMTPFrom        string
+	SMTPUsername    string
+	SMTPPassword    string
+	MetricsAddr     string   // Address of a separate listener for /metrics, such as "127.0.0.1:9090"; otherwise it needs ADMIN_TOKEN
+	LogLevel        string   // "debug", "info", "warn" or "error"
+	MaxSyncAge      string   // How stale a connected calendar may get before /readyz fails, e.g. "45m"
+	AllowedOrigins  []string // Other sites that may call the API and open WebSockets, "*" for any
+	RateLimit       string   // Requests and WebSocket messages per minute per client IP, "0" for no limit
+	TrustedProxies  []string // Addresses or networks of reverse proxies whose X-Forwarded-For is believed
+	PowDifficulty   string   // Leading zero bits of proof of work per booking, "0" for none
 }
 
 func NewConfig() *Config {
+	port := getEnv("PORT", "8080")
+	certFile := getEnv("TLS_CERT_FILE", "")
+	scheme := "http"
+	if certFile != "" {
+		scheme = "https"
+	}
+	baseURL, baseURLSet := os.LookupEnv("BASE_URL")
+	if !baseURLSet {
+		baseURL = scheme + "://localhost:" + port
+	}
 	return &Config{
-		Port: getEnv("PORT", "8080"),
+		Port:            port,
+		TLSCertFile:     certFile,
+		TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+		RedirectPort:    getEnv("HTTP_REDIRECT_PORT", ""),
+		BaseURL:         baseURL,
+		BaseURLSet:      baseURLSet,
+		BasePath:        getEnv("BASE_PATH", urlPath(baseURL)),
+		StaticDir:       getEnv("STATIC_DIR", ""),
+		CredentialsFile: getEnv("GOOGLE_CREDENTIALS_FILE", "credentials.json"),
+		HostsFile:       getEnv("HOSTS_FILE", "hosts.json"),
+		StoreFile:       getEnv("STORE_FILE", "data/caldave.json"),
+		TokenDir:        getEnv("TOKEN_DIR", "data/tokens"),
+		TokenSecret:     getEnv("TOKEN_SECRET", ""),
+		AdminToken:      getEnv("ADMIN_TOKEN", ""),
+		SMTPAddr:        getEnv("SMTP_ADDR", ""),
+		SMTPFrom:        getEnv("SMTP_FROM", "caldave@localhost"),
+		SMTPUsername:    getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:    getEnv("SMTP_PASSWORD", ""),
+		MetricsAddr:     getEnv("METRICS_ADDR", ""),
+		LogLevel:        getEnv("LOG_LEVEL", "info"),
+		MaxSyncAge:      getEnv("MAX_SYNC_AGE", "45m"),
+		AllowedOrigins:  strings.Split(getEnv("ALLOWED_ORIGINS", ""), ","),
+		RateLimit:       getEnv("RATE_LIMIT", "120"),
+		TrustedProxies:  strings.Split(getEnv("TRUSTED_PROXIES", ""), ","),
+		PowDifficulty:   getEnv("POW_DIFFICULTY", "0"),
+	}
+}
+
+// urlPath returns the path of rawURL, or nothing if it cannot be parsed.
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
 	}
+	return u.Path
 }
 
 func getEnv(key, fallback string) string {
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultHostID is used when no hosts file exists.
+const DefaultHostID = "default"
+
+var hostIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+const (
+	TeamRoundRobin = "round-robin" // Any free member can take the booking
+	TeamCollective = "collective"  // Every member has to attend
+)
+
+// HostsFile is the layout of the hosts file.
+type HostsFile struct {
+	Hosts    []HostConfig    `json:"hosts"`
+	Teams    []TeamConfig    `json:"teams"`
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+// HostConfig describes one calendar owner as written in the hosts file.
+type HostConfig struct {
+	ID         string            `json:"id"` // Used in the public booking URL, /book/{id}
+	Name       string            `json:"name"`
+	TimeZone   string            `json:"timeZone"` // IANA name, e.g. "Europe/London"
+	Schedule   Schedule          `json:"schedule"`
+	EventTypes []EventTypeConfig `json:"eventTypes"`
+}
+
+// TeamConfig is a group of hosts booked through one page, /book/{id}.
+type TeamConfig struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Mode       string            `json:"mode"`    // TeamRoundRobin or TeamCollective
+	Members    []string          `json:"members"` // Host ids
+	TimeZone   string            `json:"timeZone"`
+	EventTypes []EventTypeConfig `json:"eventTypes"`
+}
+
+// EventTypeConfig is a kind of meeting a host offers, e.g. a 15 minute intro
+// call or a 60 minute consultation.
+type EventTypeConfig struct {
+	Slug                 string     `json:"slug"`
+	Name                 string     `json:"name"`
+	DurationMinutes      int        `json:"durationMinutes"`
+	BufferBeforeMinutes  int        `json:"bufferBeforeMinutes"`
+	BufferAfterMinutes   int        `json:"bufferAfterMinutes"`
+	MinimumNoticeMinutes int        `json:"minimumNoticeMinutes"`
+	MaxBookingsPerDay    int        `json:"maxBookingsPerDay"` // 0 means unlimited
+	Capacity             int        `json:"capacity"`          // How many people can book the same slot, 0 for one
+	Questions            []Question `json:"questions"`
+
+	RequiresConfirmation bool `json:"requiresConfirmation"` // Bookings stay pending until the host approves them
+	PendingHoldMinutes   int  `json:"pendingHoldMinutes"`   // How long a pending booking holds its slot, 0 for a day
+}
+
+// Question is an extra field the booker fills in on the booking form.
+type Question struct {
+	ID       string   `json:"id"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"` // "text", "textarea", "email", "select" or "checkbox"
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"` // Choices for "select"
+}
+
+var questionTypes = map[string]bool{
+	"text":     true,
+	"textarea": true,
+	"email":    true,
+	"select":   true,
+	"checkbox": true,
+}
+
+// Hours is a "15:04" formatted opening window.
+type Hours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type Schedule struct {
+	Weekdays             map[string]Hours `json:"weekdays"` // Keyed by weekday name, e.g. "monday"
+	Default              Hours            `json:"default"`
+	BufferMinutes        int              `json:"bufferMinutes"`
+	MinimumNoticeMinutes int              `json:"minimumNoticeMinutes"`
+	MaxDaysInFuture      int              `json:"maxDaysInFuture"`    // 0 means no limit
+	MaxBookingsPerDay    int              `json:"maxBookingsPerDay"`  // Across all event types, 0 means no limit
+	MaxBookingsPerWeek   int              `json:"maxBookingsPerWeek"` // Monday to Sunday, 0 means no limit
+	Overrides            []Override       `json:"overrides"`          // Dated closures and custom hours, ahead of the weekday hours
+}
+
+// DefaultEventType is offered by hosts that do not define any event types.
+func DefaultEventType(schedule Schedule) EventTypeConfig {
+	return EventTypeConfig{
+		Slug:                "meeting",
+		Name:                "Meeting",
+		DurationMinutes:     30,
+		BufferBeforeMinutes: schedule.BufferMinutes,
+		BufferAfterMinutes:  schedule.BufferMinutes,
+	}
+}
+
+// DefaultSchedule is the schedule used for hosts that do not define one.
+func DefaultSchedule() Schedule {
+	return Schedule{
+		Weekdays: map[string]Hours{
+			"monday":    {Start: "08:00", End: "17:00"},
+			"tuesday":   {Start: "08:30", End: "17:00"},
+			"wednesday": {Start: "09:00", End: "18:00"},
+			"thursday":  {Start: "08:00", End: "17:00"},
+			"friday":    {Start: "08:00", End: "17:30"},
+			"saturday":  {Start: "10:00", End: "14:00"}, // Optional business hours on weekends
+			"sunday":    {Start: "00:00", End: "00:00"}, // Closed on Sundays
+		},
+		Default:       Hours{Start: "08:00", End: "17:30"},
+		BufferMinutes: 10,
+	}
+}
+
+// LoadHosts reads the hosts file at path. If the file does not exist a
+// single default host is returned so a fresh checkout still works.
+func LoadHosts(path string) (*HostsFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		schedule := DefaultSchedule()
+		return &HostsFile{Hosts: []HostConfig{{
+			ID:         DefaultHostID,
+			Name:       "CalDave",
+			Schedule:   schedule,
+			EventTypes: []EventTypeConfig{DefaultEventType(schedule)},
+		}}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file HostsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.Hosts) == 0 {
+		return nil, fmt.Errorf("%s: no hosts defined", path)
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Hosts {
+		host := &file.Hosts[i]
+		if !hostIDPattern.MatchString(host.ID) {
+			return nil, fmt.Errorf("%s: invalid host id %q", path, host.ID)
+		}
+		if seen[host.ID] {
+			return nil, fmt.Errorf("%s: duplicate host id %q", path, host.ID)
+		}
+		seen[host.ID] = true
+
+		if host.TimeZone != "" {
+			if _, err := time.LoadLocation(host.TimeZone); err != nil {
+				return nil, fmt.Errorf("%s: host %q: %w", path, host.ID, err)
+			}
+		}
+		if host.Schedule.Weekdays == nil && host.Schedule.Default == (Hours{}) {
+			host.Schedule = DefaultSchedule()
+		}
+		if _, err := host.Schedule.WeekdayHours(); err != nil {
+			return nil, fmt.Errorf("%s: host %q: %w", path, host.ID, err)
+		}
+		if host.Schedule.Default == (Hours{}) {
+			host.Schedule.Default = Hours{Start: "00:00", End: "00:00"} // Closed unless listed in weekdays
+		}
+		if err := host.Schedule.Default.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: host %q: default hours: %w", path, host.ID, err)
+		}
+		if err := host.Schedule.validateLimits(); err != nil {
+			return nil, fmt.Errorf("%s: host %q: %w", path, host.ID, err)
+		}
+		if err := ValidateOverrides(host.Schedule.Overrides); err != nil {
+			return nil, fmt.Errorf("%s: host %q: %w", path, host.ID, err)
+		}
+
+		if len(host.EventTypes) == 0 {
+			host.EventTypes = []EventTypeConfig{DefaultEventType(host.Schedule)}
+		}
+		if err := validateEventTypes(host.EventTypes); err != nil {
+			return nil, fmt.Errorf("%s: host %q: %w", path, host.ID, err)
+		}
+	}
+
+	for _, team := range file.Teams {
+		if !hostIDPattern.MatchString(team.ID) {
+			return nil, fmt.Errorf("%s: invalid team id %q", path, team.ID)
+		}
+		if seen[team.ID] {
+			return nil, fmt.Errorf("%s: team id %q is already used", path, team.ID)
+		}
+		seen[team.ID] = true
+
+		if team.Mode != TeamRoundRobin && team.Mode != TeamCollective {
+			return nil, fmt.Errorf("%s: team %q: mode must be %q or %q", path, team.ID, TeamRoundRobin, TeamCollective)
+		}
+		if len(team.Members) == 0 {
+			return nil, fmt.Errorf("%s: team %q has no members", path, team.ID)
+		}
+		for i, member := range team.Members {
+			if slices.Contains(team.Members[:i], member) {
+				return nil, fmt.Errorf("%s: team %q: member %q is listed twice", path, team.ID, member)
+			}
+			if !slices.ContainsFunc(file.Hosts, func(h HostConfig) bool { return h.ID == member }) {
+				return nil, fmt.Errorf("%s: team %q: unknown member %q", path, team.ID, member)
+			}
+		}
+		if team.TimeZone != "" {
+			if _, err := time.LoadLocation(team.TimeZone); err != nil {
+				return nil, fmt.Errorf("%s: team %q: %w", path, team.ID, err)
+			}
+		}
+		if len(team.EventTypes) == 0 {
+			return nil, fmt.Errorf("%s: team %q has no event types", path, team.ID)
+		}
+		if err := validateEventTypes(team.EventTypes); err != nil {
+			return nil, fmt.Errorf("%s: team %q: %w", path, team.ID, err)
+		}
+		for _, et := range team.EventTypes {
+			if et.Capacity > 1 {
+				return nil, fmt.Errorf("%s: team %q: event type %q: only hosts can offer group event types", path, team.ID, et.Slug)
+			}
+		}
+	}
+
+	webhooks := make(map[string]bool)
+	for _, wh := range file.Webhooks {
+		if err := wh.Validate(file.Hosts); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if webhooks[wh.ID] {
+			return nil, fmt.Errorf("%s: duplicate webhook id %q", path, wh.ID)
+		}
+		webhooks[wh.ID] = true
+	}
+	return &file, nil
+}
+
+func validateEventTypes(eventTypes []EventTypeConfig) error {
+	slugs := make(map[string]bool)
+	for _, et := range eventTypes {
+		if err := et.Validate(); err != nil {
+			return err
+		}
+		if slugs[et.Slug] {
+			return fmt.Errorf("duplicate event type %q", et.Slug)
+		}
+		slugs[et.Slug] = true
+	}
+	return nil
+}
+
+// Validate checks the event type's slug, durations and questions.
+func (et EventTypeConfig) Validate() error {
+	if !hostIDPattern.MatchString(et.Slug) {
+		return fmt.Errorf("invalid event type slug %q", et.Slug)
+	}
+	if et.DurationMinutes <= 0 {
+		return fmt.Errorf("event type %q: durationMinutes must be positive", et.Slug)
+	}
+	if et.BufferBeforeMinutes < 0 || et.BufferAfterMinutes < 0 || et.MinimumNoticeMinutes < 0 || et.MaxBookingsPerDay < 0 || et.PendingHoldMinutes < 0 || et.Capacity < 0 {
+		return fmt.Errorf("event type %q: buffers, notice, limits, holds and capacity cannot be negative", et.Slug)
+	}
+
+	ids := make(map[string]bool)
+	for _, q := range et.Questions {
+		if q.ID == "" || ids[q.ID] {
+			return fmt.Errorf("event type %q: question ids must be unique and not empty", et.Slug)
+		}
+		ids[q.ID] = true
+		if q.ID == "name" || q.ID == "email" {
+			return fmt.Errorf("event type %q: question id %q is reserved", et.Slug, q.ID)
+		}
+		if !questionTypes[q.Type] {
+			return fmt.Errorf("event type %q: question %q has unknown type %q", et.Slug, q.ID, q.Type)
+		}
+		if q.Type == "select" && len(q.Options) == 0 {
+			return fmt.Errorf("event type %q: question %q needs options", et.Slug, q.ID)
+		}
+	}
+	return nil
+}
+
+// WeekdayHours returns the weekday hours keyed by time.Weekday.
+func (s Schedule) WeekdayHours() (map[time.Weekday]Hours, error) {
+	hours := make(map[time.Weekday]Hours, len(s.Weekdays))
+	for name, h := range s.Weekdays {
+		day, ok := ParseWeekday(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", name)
+		}
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		hours[day] = h
+	}
+	return hours, nil
+}
+
+func (s Schedule) validateLimits() error {
+	if s.BufferMinutes < 0 || s.MinimumNoticeMinutes < 0 || s.MaxDaysInFuture < 0 ||
+		s.MaxBookingsPerDay < 0 || s.MaxBookingsPerWeek < 0 {
+		return errors.New("schedule buffers, notice and limits cannot be negative")
+	}
+	return nil
+}
+
+// Validate checks that both ends are "15:04" formatted.
+func (h Hours) Validate() error {
+	for _, t := range []string{h.Start, h.End} {
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("invalid time %q", t)
+		}
+	}
+	return nil
+}
+
+// ParseWeekday parses a weekday name such as "monday", ignoring case.
+func ParseWeekday(name string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), name) {
+			return d, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEventTypeValidate(t *testing.T) {
+	valid := func() EventTypeConfig {
+		return EventTypeConfig{
+			Slug:                "intro",
+			DurationMinutes:     30,
+			BufferBeforeMinutes: 5,
+			BufferAfterMinutes:  10,
+			Questions: []Question{
+				{ID: "company", Label: "Company", Type: "text"},
+				{ID: "topic", Label: "Topic", Type: "select", Options: []string{"Sales", "Support"}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(et *EventTypeConfig)
+		err    string
+	}{
+		{name: "Valid", change: func(et *EventTypeConfig) {}},
+		{name: "Invalid slug", change: func(et *EventTypeConfig) { et.Slug = "Intro Call" }, err: "invalid event type slug"},
+		{name: "Zero duration", change: func(et *EventTypeConfig) { et.DurationMinutes = 0 }, err: "durationMinutes must be positive"},
+		{name: "Negative buffer", change: func(et *EventTypeConfig) { et.BufferAfterMinutes = -5 }, err: "cannot be negative"},
+		{name: "Negative daily limit", change: func(et *EventTypeConfig) { et.MaxBookingsPerDay = -1 }, err: "cannot be negative"},
+		{name: "Duplicate question", change: func(et *EventTypeConfig) { et.Questions[1].ID = "company" }, err: "must be unique"},
+		{name: "Reserved question id", change: func(et *EventTypeConfig) { et.Questions[0].ID = "email" }, err: "is reserved"},
+		{name: "Unknown question type", change: func(et *EventTypeConfig) { et.Questions[0].Type = "date" }, err: "unknown type"},
+		{name: "Select without options", change: func(et *EventTypeConfig) { et.Questions[1].Options = nil }, err: "needs options"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			et := valid()
+			tt.change(&et)
+			err := et.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadHostsTeamMembers(t *testing.T) {
+	tests := []struct {
+		name    string
+		members string
+		err     string
+	}{
+		{name: "Valid", members: `["alice", "bob"]`},
+		{name: "Unknown member", members: `["alice", "carol"]`, err: `unknown member "carol"`},
+		{name: "Duplicate member", members: `["alice", "bob", "alice"]`, err: `member "alice" is listed twice`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hosts.json")
+			hosts := `{
+				"hosts": [{"id": "alice", "name": "Alice"}, {"id": "bob", "name": "Bob"}],
+				"teams": [{
+					"id": "sales", "name": "Sales", "mode": "collective", "members": ` + tt.members + `,
+					"eventTypes": [{"slug": "intro", "name": "Intro", "durationMinutes": 30}]
+				}]
+			}`
+			if err := os.WriteFile(path, []byte(hosts), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadHosts(path)
+			if tt.err == "" && err != nil {
+				t.Fatalf("Expected the hosts file to load, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxOverrideDays limits how many days a single override can cover.
+const maxOverrideDays = 366
+
+// Override replaces a host's weekday hours on a date or a run of dates. An
+// override without hours closes the host on those days.
+type Override struct {
+	Date  string  `json:"date"`            // "2006-01-02"
+	Until string  `json:"until,omitempty"` // Last day of a range, inclusive
+	Hours []Hours `json:"hours"`           // Opening windows, e.g. either side of a lunch break
+	Note  string  `json:"note,omitempty"`  // Shown to admins only, e.g. "Christmas"
+}
+
+// Closed reports whether the override closes the host for the whole day.
+func (o Override) Closed() bool {
+	return len(o.Hours) == 0
+}
+
+// Days returns every date the override covers, formatted as "2006-01-02".
+func (o Override) Days() ([]string, error) {
+	first, err := time.Parse("2006-01-02", o.Date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", o.Date)
+	}
+	last := first
+	if o.Until != "" {
+		if last, err = time.Parse("2006-01-02", o.Until); err != nil {
+			return nil, fmt.Errorf("invalid date %q", o.Until)
+		}
+	}
+	if last.Before(first) {
+		return nil, fmt.Errorf("%s: until is before the date", o.Date)
+	}
+	if last.Sub(first) >= maxOverrideDays*24*time.Hour {
+		return nil, fmt.Errorf("%s: an override can cover at most %d days", o.Date, maxOverrideDays)
+	}
+
+	var days []string
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format("2006-01-02"))
+	}
+	return days, nil
+}
+
+// Validate checks the dates and that the windows are well formed and do not
+// overlap.
+func (o Override) Validate() error {
+	if _, err := o.Days(); err != nil {
+		return err
+	}
+
+	windows := append([]Hours{}, o.Hours...)
+	for _, h := range windows {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", o.Date, err)
+		}
+		if h.Start >= h.End {
+			return fmt.Errorf("%s: window %s-%s ends before it starts", o.Date, h.Start, h.End)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start < windows[j].Start })
+	for i := 1; i < len(windows); i++ {
+		if windows[i].Start < windows[i-1].End {
+			return fmt.Errorf("%s: windows %s-%s and %s-%s overlap", o.Date,
+				windows[i-1].Start, windows[i-1].End, windows[i].Start, windows[i].End)
+		}
+	}
+	return nil
+}
+
+// ValidateOverrides checks each override and that no two cover the same day.
+func ValidateOverrides(overrides []Override) error {
+	covered := make(map[string]string)
+	for _, o := range overrides {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+		days, _ := o.Days()
+		for _, day := range days {
+			if other, ok := covered[day]; ok {
+				return fmt.Errorf("overrides %s and %s both cover %s", other, o.Date, day)
+			}
+			covered[day] = o.Date
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// WebhookConfig is an endpoint that is sent booking events as signed JSON.
+type WebhookConfig struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"` // Signs every delivery, see the webhook package
+	Events []string `json:"events"` // Empty means every event
+	Hosts  []string `json:"hosts"`  // Only bookings these hosts attend, empty means every host
+}
+
+// Validate checks the webhook's URL, secret and host filter. hosts are the
+// known hosts. The event filter is checked by ValidateEvents.
+func (wh WebhookConfig) Validate(hosts []HostConfig) error {
+	if !hostIDPattern.MatchString(wh.ID) {
+		return fmt.Errorf("invalid webhook id %q", wh.ID)
+	}
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook %q: url must be an absolute http or https URL", wh.ID)
+	}
+	if wh.Secret == "" {
+		return fmt.Errorf("webhook %q: secret is required", wh.ID)
+	}
+	for _, host := range wh.Hosts {
+		if !slices.ContainsFunc(hosts, func(h HostConfig) bool { return h.ID == host }) {
+			return fmt.Errorf("webhook %q: unknown host %q", wh.ID, host)
+		}
+	}
+	return nil
+}
+
+// ValidateEvents checks that the webhook only subscribes to events in known,
+// which the package emitting the events defines.
+func (wh WebhookConfig) ValidateEvents(known []string) error {
+	for _, event := range wh.Events {
+		if !slices.Contains(known, event) {
+			return fmt.Errorf("webhook %q: unknown event %q", wh.ID, event)
+		}
+	}
+	return nil
+}
+
+// Subscribed reports whether the webhook wants event for a booking attended
+// by hosts.
+func (wh WebhookConfig) Subscribed(event string, hosts []string) bool {
+	if len(wh.Events) > 0 && !slices.Contains(wh.Events, event) {
+		return false
+	}
+	if len(wh.Hosts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(hosts, func(h string) bool { return slices.Contains(wh.Hosts, h) })
+}
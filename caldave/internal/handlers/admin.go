@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// overrideView is a date override as returned by the admin API.
+type overrideView struct {
+	config.Override
+	Source string `json:"source"` // "config" for the hosts file, "admin" for the admin API
+}
+
+// ListOverridesHandler serves GET /admin/api/hosts/{host}/overrides. Overrides
+// from the admin API take precedence over the hosts file on the days they
+// cover.
+func ListOverridesHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := wsh.hosts[r.PathValue("host")]
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown host")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, host.overrideViews())
+	})
+}
+
+// overrideViews lists the host's configured and saved overrides by date.
+func (h *hostCalendar) overrideViews() []overrideView {
+	views := []overrideView{}
+	for _, o := range h.configOverrides {
+		views = append(views, overrideView{Override: o, Source: "config"})
+	}
+	for _, o := range h.store.Overrides(h.id) {
+		views = append(views, overrideView{Override: o, Source: "admin"})
+	}
+	slices.SortStableFunc(views, func(a, b overrideView) int { return strings.Compare(a.Date, b.Date) })
+	return views
+}
+
+// SaveOverrideHandler serves PUT /admin/api/hosts/{host}/overrides/{date},
+// creating or replacing the override starting on date. The body is an
+// override without its date; empty hours close the host.
+func SaveOverrideHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := wsh.hosts[r.PathValue("host")]
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown host")
+			return
+		}
+
+		var o config.Override
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&o); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		o.Date = r.PathValue("date")
+		if err := o.Validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := host.store.SetOverride(host.id, o)
+		if errors.Is(err, store.ErrConflict) {
+			writeJSONError(w, http.StatusConflict, "the override covers days of another override")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving override", "host", host.id, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "unable to save override")
+			return
+		}
+		wsh.scheduleChanged(host.id)
+		writeJSON(w, http.StatusOK, overrideView{Override: o, Source: "admin"})
+	})
+}
+
+// DeleteOverrideHandler serves DELETE /admin/api/hosts/{host}/overrides/{date}.
+// Only overrides made through the admin API can be deleted.
+func DeleteOverrideHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := wsh.hosts[r.PathValue("host")]
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown host")
+			return
+		}
+
+		err := host.store.DeleteOverride(host.id, r.PathValue("date"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "no override starts on that date")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting override", "host", host.id, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "unable to delete override")
+			return
+		}
+		wsh.scheduleChanged(host.id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// SyncHostHandler serves POST /admin/api/hosts/{host}/sync, fetching the
+// host's calendar events now rather than at the next scheduled sync.
+func SyncHostHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := wsh.hosts[r.PathValue("host")]
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown host")
+			return
+		}
+		wsh.syncHost(r.Context(), host)
+		writeJSON(w, http.StatusOK, calendarCheck(host, time.Now(), time.Minute))
+	})
+}
+
+// syncHost fetches the host's events and refreshes the booking pages that
+// show them.
+func (wsh *WebSocketHandler) syncHost(ctx context.Context, host *hostCalendar) {
+	host.updateEvents(ctx, time.Now().AddDate(0, 0, -30), time.Now().AddDate(0, 0, 60))
+	wsh.scheduleChanged(host.id)
+}
+
+// ApproveBookingHandler serves POST /admin/api/bookings/{id}/approve.
+func ApproveBookingHandler(wsh *WebSocketHandler) http.Handler {
+	return bookingActionHandler(wsh.approveBooking)
+}
+
+// DeclineBookingHandler serves POST /admin/api/bookings/{id}/decline.
+func DeclineBookingHandler(wsh *WebSocketHandler) http.Handler {
+	return bookingActionHandler(wsh.declineBooking)
+}
+
+// CancelBookingHandler serves POST /admin/api/bookings/{id}/cancel.
+func CancelBookingHandler(wsh *WebSocketHandler) http.Handler {
+	return bookingActionHandler(wsh.cancelBooking)
+}
+
+// RescheduleBookingHandler serves POST /admin/api/bookings/{id}/reschedule.
+// The body is a RescheduleRequest.
+func RescheduleBookingHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RescheduleRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		bookingActionHandler(func(ctx context.Context, id string) (store.Booking, error) {
+			return wsh.rescheduleBooking(ctx, id, req, time.Now())
+		}).ServeHTTP(w, r)
+	})
+}
+
+// AttendeesHandler serves GET /admin/api/bookings/{id}/attendees, listing
+// the active bookings in the same slot as the booking. For event types with
+// a capacity of one that is at most the booking itself.
+func AttendeesHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := wsh.store.Booking(r.PathValue("id"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, errBookingNotFound.Error())
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading booking", "booking", r.PathValue("id"), "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "unable to read booking")
+			return
+		}
+
+		attendees := wsh.sessionAttendees(b)
+		if attendees == nil {
+			attendees = []store.Booking{}
+		}
+		writeJSON(w, http.StatusOK, attendees)
+	})
+}
+
+// bookingActionHandler applies action to the booking in the {id} path value
+// and returns the updated booking.
+func bookingActionHandler(action func(ctx context.Context, id string) (store.Booking, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		booking, err := action(r.Context(), r.PathValue("id"))
+		switch {
+		case errors.Is(err, errBookingNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, errBookingStatus), errors.Is(err, errSlotUnavailable):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, errInvalidBooking):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Error updating booking", "booking", r.PathValue("id"), "error", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		default:
+			writeJSON(w, http.StatusOK, booking)
+		}
+	})
+}
+
+// scheduleChanged tells the booking pages of the host, and of every team
+// they belong to, to fetch their availability again.
+func (wsh *WebSocketHandler) scheduleChanged(hostID string) {
+	for _, owner := range wsh.owners {
+		switch o := owner.(type) {
+		case *hostCalendar:
+			if o.id != hostID {
+				continue
+			}
+		case *teamCalendar:
+			if !slices.ContainsFunc(o.members, func(m *hostCalendar) bool { return m.id == hostID }) {
+				continue
+			}
+		}
+		owner.Hub().broadcast(Message{Type: string(EventUpdated)})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, BookingErrorData{Error: message})
+}
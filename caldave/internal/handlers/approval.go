@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"caldave/internal/i18n"
+	"caldave/internal/notify"
+	"caldave/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	errBookingStatus   = errors.New("booking cannot be changed")
+	errBookingNotFound = errors.New("booking not found")
+)
+
+// approveBooking confirms a pending booking.
+func (wsh *WebSocketHandler) approveBooking(ctx context.Context, id string) (store.Booking, error) {
+	b, err := wsh.setBookingStatus(ctx, id, store.StatusConfirmed, store.StatusPending)
+	if err == nil {
+		wsh.emit(BookingConfirmedEvent, b, nil)
+		wsh.notifyBooker(ctx, b, "confirmed")
+	}
+	return b, err
+}
+
+// declineBooking turns a pending booking down and frees its slot.
+func (wsh *WebSocketHandler) declineBooking(ctx context.Context, id string) (store.Booking, error) {
+	b, err := wsh.setBookingStatus(ctx, id, store.StatusDeclined, store.StatusPending)
+	if err == nil {
+		wsh.emit(BookingDeclinedEvent, b, nil)
+		wsh.notifyBooker(ctx, b, "declined")
+	}
+	return b, err
+}
+
+// cancelBooking cancels a confirmed booking and frees its slot.
+func (wsh *WebSocketHandler) cancelBooking(ctx context.Context, id string) (store.Booking, error) {
+	b, err := wsh.setBookingStatus(ctx, id, store.StatusCancelled, store.StatusConfirmed)
+	if err == nil {
+		wsh.emit(BookingCancelledEvent, b, nil)
+		wsh.notifyBooker(ctx, b, "cancelled")
+	}
+	return b, err
+}
+
+// bookingCreated tells listeners about a new booking, and the booker that
+// their request is waiting for the host. Confirmed bookings are shown on the
+// booking page straight away.
+func (wsh *WebSocketHandler) bookingCreated(ctx context.Context, b store.Booking) {
+	owner := b.Host
+	if b.Team != "" {
+		owner = b.Team
+	}
+	bookingsCreated.Inc(owner, b.EventType, b.Status)
+	wsh.emit(BookingCreatedEvent, b, nil)
+	if b.Status == store.StatusPending {
+		wsh.notifyBooker(ctx, b, "requested", wsh.formatTime(b, b.ExpiresAt))
+	}
+}
+
+// setBookingStatus moves the booking to status if it currently has one of
+// from, and lets the booking pages of everyone involved know. Pending
+// bookings that have run out of time can no longer be changed.
+func (wsh *WebSocketHandler) setBookingStatus(ctx context.Context, id, status string, from ...string) (store.Booking, error) {
+	booking, err := wsh.store.UpdateBooking(id, func(b *store.Booking) error {
+		if b.Status == store.StatusPending && !b.Active() {
+			return fmt.Errorf("%w: it has expired", errBookingStatus)
+		}
+		for _, s := range from {
+			if b.Status == s {
+				b.Status = status
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: it is %s", errBookingStatus, b.Status)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Booking{}, errBookingNotFound
+	}
+	if err != nil && !errors.Is(err, errBookingStatus) {
+		slog.ErrorContext(ctx, "Error updating booking", "booking", id, "error", err)
+		return store.Booking{}, errors.New("unable to update booking")
+	}
+	if err != nil {
+		return store.Booking{}, err
+	}
+
+	wsh.bookingReleased(booking)
+	return booking, nil
+}
+
+// bookingReleased lets the booking pages of everyone attending b know that
+// its slot changed, and the waitlist that a seat may have come free.
+func (wsh *WebSocketHandler) bookingReleased(b store.Booking) {
+	wsh.scheduleChanged(b.Host)
+	for _, member := range b.Members {
+		if member != b.Host {
+			wsh.scheduleChanged(member)
+		}
+	}
+	wsh.wakeWaitlist()
+}
+
+// expireBooking marks a pending booking nobody approved in time as expired.
+// It stops holding its slot as soon as it expires; this only records it and
+// tells the booker. Bookings that are no longer pending are left alone.
+func (wsh *WebSocketHandler) expireBooking(ctx context.Context, id string) error {
+	expired, err := wsh.store.UpdateBooking(id, func(b *store.Booking) error {
+		if b.Status != store.StatusPending {
+			return errBookingStatus
+		}
+		b.Status = store.StatusExpired
+		return nil
+	})
+	if errors.Is(err, errBookingStatus) || errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	wsh.bookingReleased(expired)
+	wsh.emit(BookingExpiredEvent, expired, nil)
+	wsh.notifyBooker(ctx, expired, "expired")
+	return nil
+}
+
+// notifyBooker emails the booker in the background. ctx only carries the
+// request ID for logging; the email is still sent once the request is done.
+func (wsh *WebSocketHandler) notifyBooker(ctx context.Context, b store.Booking, email string, args ...any) {
+	m := wsh.bookerMessage(b, email, args...)
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := wsh.notifier.Notify(ctx, m); err != nil {
+			slog.ErrorContext(ctx, "Error notifying booker", "booking", b.ID, "error", err)
+		}
+	}()
+}
+
+// bookerMessage is an email to the booker in their language. email names
+// its subject and intro in the message catalogs, "email.<email>.subject"
+// and "email.<email>.intro", and args are formatted into the intro. It ends
+// by summing up the booking.
+func (wsh *WebSocketHandler) bookerMessage(b store.Booking, email string, args ...any) notify.Message {
+	l := i18n.Get(b.Locale)
+	ownerName, eventName := b.Host, b.EventType
+	if owner, ok := wsh.bookingOwner(b); ok {
+		ownerName = owner.Name()
+		if et, ok := owner.eventType(b.EventType); ok {
+			eventName = et.Name
+		}
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n%s\n\n", l.T("email.greeting", b.Name), l.T("email."+email+".intro", args...))
+	fmt.Fprintln(&body, l.T("event.with", eventName, ownerName))
+	fmt.Fprintln(&body, l.T("event.range", wsh.formatTime(b, b.Start), wsh.formatTime(b, b.End)))
+
+	return notify.Message{To: b.Email, Subject: l.T("email." + email + ".subject"), Body: body.String()}
+}
+
+// formatTime formats t in the time zone of the page b was booked on, the
+// way the booker's language writes dates.
+func (wsh *WebSocketHandler) formatTime(b store.Booking, t time.Time) string {
+	if owner, ok := wsh.bookingOwner(b); ok {
+		t = t.In(owner.Location())
+	}
+	return i18n.Get(b.Locale).FormatDateTime(t)
+}
+
+// bookingOwner returns the host or team whose page b was booked on.
+func (wsh *WebSocketHandler) bookingOwner(b store.Booking) (calendarOwner, bool) {
+	if b.Team != "" {
+		owner, ok := wsh.owners[b.Team]
+		return owner, ok
+	}
+	owner, ok := wsh.owners[b.Host]
+	return owner, ok
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/notify"
+	"caldave/internal/store"
+	"caldave/internal/webhook"
+	"caldave/internal/webhook/webhooktest"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// sentMessages records the messages notifyBooker sends.
+type sentMessages chan notify.Message
+
+func (s sentMessages) Notify(ctx context.Context, m notify.Message) error {
+	s <- m
+	return nil
+}
+
+func (s sentMessages) next(t *testing.T) notify.Message {
+	t.Helper()
+	select {
+	case m := <-s:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("Expected a message to the booker")
+		return notify.Message{}
+	}
+}
+
+func TestBookingApproval(t *testing.T) {
+	wsh := newTestHandler(t, config.HostConfig{
+		ID:       "dave",
+		TimeZone: "UTC",
+		Schedule: config.Schedule{Weekdays: map[string]config.Hours{}, Default: config.Hours{Start: "09:00", End: "12:00"}},
+		EventTypes: []config.EventTypeConfig{
+			{Slug: "consult", Name: "Consultation", DurationMinutes: 60, RequiresConfirmation: true, PendingHoldMinutes: 60},
+		},
+	})
+	dave, sent := wsh.hosts["dave"], wsh.notifier.(sentMessages)
+	receiver := webhooktest.NewReceiver("whsec")
+	defer receiver.Close()
+	webhooks := webhook.NewDispatcher([]config.WebhookConfig{{ID: "crm", URL: receiver.URL, Secret: "whsec"}})
+	wsh.OnBookingEvent(func(e BookingEvent) {
+		webhooks.Publish(e.Type, e.Hosts(), e)
+	})
+	ctx := context.Background()
+	date := time.Date(2030, 10, 11, 0, 0, 0, 0, time.UTC)
+	now := date.AddDate(0, 0, -1)
+	starts := func() []string {
+		et, _ := dave.eventType("consult")
+		_, slots := dave.availability(date, et, now)
+		var starts []string
+		for _, slot := range slots {
+			starts = append(starts, slot.Start)
+		}
+		return starts
+	}
+	book := func(start string) store.Booking {
+		t.Helper()
+		b, err := dave.createBooking(CreateBookingRequest{EventType: "consult", Date: "2030-10-11", Start: start, Name: "Grace", Email: "grace@example.com"}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsh.bookingCreated(ctx, b)
+		if m := sent.next(t); m.Subject != "We received your booking request" {
+			t.Errorf("Expected the request to be acknowledged, got %q", m.Subject)
+		}
+		return b
+	}
+
+	approved := book("09:00")
+	if approved.Status != store.StatusPending {
+		t.Errorf("Expected the booking to be %s, got %s", store.StatusPending, approved.Status)
+	}
+	if got, want := starts(), []string{"10:00", "11:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the pending booking to hold its slot, got %v", got)
+	}
+	approved, err := wsh.approveBooking(ctx, approved.ID)
+	if err != nil || approved.Status != store.StatusConfirmed {
+		t.Fatalf("Expected the booking to be confirmed, got %s, %v", approved.Status, err)
+	}
+	if m := sent.next(t); m.To != "grace@example.com" || m.Subject != "Your booking is confirmed" {
+		t.Errorf("Expected the booker to be told it is confirmed, got %+v", m)
+	}
+
+	declined, err := wsh.declineBooking(ctx, book("10:00").ID)
+	if err != nil || declined.Status != store.StatusDeclined {
+		t.Fatalf("Expected the booking to be declined, got %s, %v", declined.Status, err)
+	}
+	if m := sent.next(t); m.Subject != "Your booking request was declined" {
+		t.Errorf("Expected the booker to be told it was declined, got %q", m.Subject)
+	}
+	if _, err := wsh.approveBooking(ctx, declined.ID); !errors.Is(err, errBookingStatus) {
+		t.Errorf("Expected a declined booking not to be approved, got %v", err)
+	}
+	if got, want := starts(), []string{"10:00", "11:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected declining to free the slot, got %v", got)
+	}
+
+	lapsed, err := wsh.store.AddBooking(store.Booking{
+		Host: "dave", EventType: "consult", Start: date.Add(11 * time.Hour), End: date.Add(12 * time.Hour),
+		Name: "Ada", Email: "ada@example.com", Status: store.StatusPending, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := starts(), []string{"10:00", "11:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected an expired hold to release its slot, got %v", got)
+	}
+	if _, err := wsh.approveBooking(ctx, lapsed.ID); !errors.Is(err, errBookingStatus) {
+		t.Errorf("Expected an expired booking not to be approved, got %v", err)
+	}
+
+	if err := wsh.expireBooking(ctx, lapsed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := wsh.store.Booking(lapsed.ID); b.Status != store.StatusExpired {
+		t.Errorf("Expected the booking to be %s, got %s", store.StatusExpired, b.Status)
+	}
+	if m := sent.next(t); m.To != "ada@example.com" || m.Subject != "Your booking request expired" {
+		t.Errorf("Expected the booker to be told it expired, got %+v", m)
+	}
+	if b, _ := wsh.store.Booking(approved.ID); b.Status != store.StatusConfirmed {
+		t.Errorf("Expected the confirmed booking to be left alone, got %s", b.Status)
+	}
+
+	webhooks.Wait()
+	var events []string
+	for _, r := range receiver.Requests() {
+		var e BookingEvent
+		if err := json.Unmarshal(r.Payload.Data, &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, r.Event+" "+e.Booking.Name+" "+e.Booking.Status)
+	}
+	// Deliveries are concurrent, so they may arrive in any order.
+	slices.Sort(events)
+	want := []string{
+		"booking.confirmed Grace confirmed",
+		"booking.created Grace pending",
+		"booking.created Grace pending",
+		"booking.declined Grace declined",
+		"booking.expired Ada expired",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected the webhooks %v, got %v", want, events)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"caldave/internal/availability"
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	// errInvalidBooking wraps problems with what the booker submitted.
+	errInvalidBooking  = errors.New("invalid booking")
+	errSlotUnavailable = errors.New("the selected time is no longer available")
+)
+
+type CreateBookingRequest struct {
+	EventType string            `json:"eventType"`
+	Date      string            `json:"date"`  // Format: "2024-10-11"
+	Start     string            `json:"start"` // Format: "HH:MM"
+	Name      string            `json:"name"`
+	Email     string            `json:"email"`
+	Answers   map[string]string `json:"answers"`
+	Proof     string            `json:"proof,omitempty"` // Answer to the challenge, when the server sets one
+	Locale    string            `json:"-"`               // Taken from the connection's Accept-Language
+}
+
+type BookingCreatedData struct {
+	ID        string `json:"id"`
+	EventType string `json:"eventType"`
+	Date      string `json:"date"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Status    string `json:"status"` // "pending" until the host approves it, otherwise "confirmed"
+}
+
+type BookingErrorData struct {
+	Error string `json:"error"`
+}
+
+// activeBookings returns the bookings made through caldave that the host
+// attends, together with the seats held for waitlist offers.
+func (h *hostCalendar) activeBookings() []store.Booking {
+	bookings := h.store.Bookings(func(b store.Booking) bool {
+		return b.Involves(h.id) && b.Active()
+	})
+	for _, e := range h.store.Waitlist(func(e store.WaitlistEntry) bool {
+		return e.Host == h.id && e.Holding()
+	}) {
+		bookings = append(bookings, offerHold(e))
+	}
+	return bookings
+}
+
+// busyEvents returns the calendar events together with the bookings made
+// through caldave, each booking widened by its own buffers.
+func busyEvents(calendar []utils.EventData, bookings []store.Booking) []utils.EventData {
+	events := append([]utils.EventData{}, calendar...)
+	for _, b := range bookings {
+		events = append(events, utils.EventData{
+			EventName: b.EventType,
+			StartTime: b.Start.Add(-time.Duration(b.BufferBeforeMinutes) * time.Minute),
+			EndTime:   b.End.Add(time.Duration(b.BufferAfterMinutes) * time.Minute),
+		})
+	}
+	return events
+}
+
+// availability returns the free time on date and the slots of the event type
+// that can still be booked, taking the minimum notice, booking horizon and
+// daily and weekly limits into account.
+func (h *hostCalendar) availability(date time.Time, et EventType, now time.Time) (free []TimeSlot, slots []TimeSlot) {
+	bookings := h.activeBookings()
+	return h.dayAvailability(date, et, now, et.schedule(h.currentSchedule()), h.Events(), bookings)
+}
+
+// dayAvailability is availability over data already read, so that several
+// days can share one read of the events and bookings. events are the host's
+// calendar events, and schedule must already have the event type's buffers
+// applied.
+//
+// For a group event type the sessions that still have seats are offered
+// alongside new slots, with the number of seats left. Joining a session does
+// not give the host another meeting, so sessions stay open once the daily
+// and weekly limits are reached.
+func (h *hostCalendar) dayAvailability(date time.Time, et EventType, now time.Time, schedule ScheduleConfig, events []utils.EventData, bookings []store.Booking) (free []TimeSlot, slots []TimeSlot) {
+	if horizon := h.schedule.horizon(now, h.location); !horizon.IsZero() && !date.Before(horizon) {
+		return nil, nil
+	}
+	full := h.checkCapsIn(bookings, date, &et) != nil
+	if full && !et.group() {
+		return nil, nil
+	}
+
+	sessions, others := h.groupSessions(bookings, et)
+	available := freeTime(date, busyEvents(events, others), schedule)
+	candidates := available.Split(et.Duration)
+	if et.group() {
+		candidates = groupSlots(available, sessions, et)
+		available = available.Subtract(sessionTimes(sessions))
+	}
+
+	var bookable []availability.Interval
+	var seats []int
+	for _, slot := range candidates {
+		if h.schedule.checkBookingWindow(slot.Start, et, now) != nil {
+			continue
+		}
+		if et.group() {
+			left := seatsLeft(sessions, et, slot.Start)
+			if full && left == et.Capacity {
+				continue // Would start a new session
+			}
+			seats = append(seats, left)
+		}
+		bookable = append(bookable, slot)
+	}
+	if full {
+		available = nil
+	}
+
+	slots = timeSlots(bookable, h.location)
+	for i := range seats {
+		slots[i].Seats = seats[i]
+	}
+	return timeSlots(available, h.location), slots
+}
+
+// bookingDetails is a CreateBookingRequest after validation.
+type bookingDetails struct {
+	eventType EventType
+	date      time.Time
+	start     time.Time
+	name      string
+	email     string
+}
+
+// validateBookingRequest checks everything about req that does not depend
+// on availability.
+func validateBookingRequest(req CreateBookingRequest, eventTypes func(string) (EventType, bool), loc *time.Location) (bookingDetails, error) {
+	et, ok := eventTypes(req.EventType)
+	if !ok {
+		return bookingDetails{}, fmt.Errorf("%w: unknown event type %q", errInvalidBooking, req.EventType)
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return bookingDetails{}, fmt.Errorf("%w: name is required", errInvalidBooking)
+	}
+	email, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		return bookingDetails{}, fmt.Errorf("%w: a valid email address is required", errInvalidBooking)
+	}
+	if err := et.validateAnswers(req.Answers); err != nil {
+		return bookingDetails{}, err
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", req.Date, loc)
+	if err != nil {
+		return bookingDetails{}, fmt.Errorf("%w: invalid date %q", errInvalidBooking, req.Date)
+	}
+	start, err := time.ParseInLocation("2006-01-02 15:04", req.Date+" "+req.Start, loc)
+	if err != nil {
+		return bookingDetails{}, fmt.Errorf("%w: invalid start time %q", errInvalidBooking, req.Start)
+	}
+
+	return bookingDetails{eventType: et, date: date, start: start, name: name, email: email.Address}, nil
+}
+
+// newBooking fills in the parts of a booking common to hosts and teams.
+// Event types that require confirmation start out pending.
+func (d bookingDetails) newBooking(req CreateBookingRequest, now time.Time) store.Booking {
+	b := store.Booking{
+		EventType:           d.eventType.Slug,
+		Start:               d.start,
+		End:                 d.start.Add(d.eventType.Duration),
+		BufferBeforeMinutes: int(d.eventType.BufferBefore / time.Minute),
+		BufferAfterMinutes:  int(d.eventType.BufferAfter / time.Minute),
+		Name:                d.name,
+		Email:               d.email,
+		Answers:             req.Answers,
+		Status:              store.StatusConfirmed,
+		Locale:              req.Locale,
+	}
+	if d.eventType.RequiresConfirmation {
+		b.Status = store.StatusPending
+		b.ExpiresAt = now.Add(d.eventType.PendingHold)
+	}
+	return b
+}
+
+// createBooking reserves the requested slot if it is still available.
+func (h *hostCalendar) createBooking(req CreateBookingRequest, now time.Time) (store.Booking, error) {
+	details, err := validateBookingRequest(req, h.eventType, h.location)
+	if err != nil {
+		return store.Booking{}, err
+	}
+
+	if err := h.schedule.checkBookingWindow(details.start, details.eventType, now); err != nil {
+		return store.Booking{}, err
+	}
+
+	// Hold the lock from the availability check until the booking is saved
+	// so two visitors cannot take the same slot.
+	h.bookingMutex.Lock()
+	defer h.bookingMutex.Unlock()
+
+	_, slots := h.availability(details.date, details.eventType, now)
+	if !containsSlot(slots, req.Start) {
+		if err := h.checkBookingCaps(details.date, &details.eventType); err != nil {
+			return store.Booking{}, err
+		}
+		return store.Booking{}, errSlotUnavailable
+	}
+
+	booking := details.newBooking(req, now)
+	booking.Host = h.id
+	return h.store.AddBooking(booking)
+}
+
+func containsSlot(slots []TimeSlot, start string) bool {
+	for _, slot := range slots {
+		if slot.Start == start {
+			return true
+		}
+	}
+	return false
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.Month() == b.Month() && a.Day() == b.Day()
+}
@@ -1,12 +1,40 @@
 package handlers
 
 import (
+	"caldave/internal/i18n"
+	"html/template"
 	"net/http"
 )
 
-func BookingHandler() http.Handler {
+// bookingPage is the data rendered into booking.html.
+type bookingPage struct {
+	HostID       string
+	HostName     string
+	TimeZone     string // Where the times on the page are, as slots are given in the owner's time zone
+	EventTypes   []eventTypeView
+	WebSocketURL template.URL // Built from the base URL; ws schemes would otherwise be filtered out
+	Locale       *i18n.Locale
+}
+
+// BookingHandler renders the booking calendar for the host or team in the
+// {host} path value, or for the default host when there is none, in the
+// language the browser asks for.
+func BookingHandler(wsh *WebSocketHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := tpl.ExecuteTemplate(w, "booking.html", "Hello World")
+		owner, ok := wsh.lookupOwner(r.PathValue("host"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		err := wsh.render(w, "booking.html", bookingPage{
+			HostID:       owner.ID(),
+			HostName:     owner.Name(),
+			TimeZone:     owner.Location().String(),
+			EventTypes:   owner.eventTypeViews(),
+			WebSocketURL: template.URL(wsh.webSocketURL(owner.ID(), r.TLS != nil)),
+			Locale:       i18n.Match(r.Header.Get("Accept-Language")),
+		})
 		if err != nil {
 			http.Error(w, "Error rendering page", http.StatusInternalServerError)
 		}
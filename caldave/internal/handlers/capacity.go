@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"caldave/internal/availability"
+	"caldave/internal/store"
+	"slices"
+	"time"
+)
+
+// session is one slot of a group event type together with the bookings of
+// the people attending it. Every attendee has a booking of their own, so
+// they can be confirmed, declined and cancelled one at a time.
+type session struct {
+	availability.Interval
+	attendees []store.Booking
+}
+
+// seats returns how many more people can join the session.
+func (s session) seats(et EventType) int {
+	return max(et.Capacity-len(s.attendees), 0)
+}
+
+// groupSessions picks the host's own bookings of the group event type et out
+// of bookings and gathers them into sessions by start, soonest first. The
+// rest of the bookings are returned as they are. Bookings of other event
+// types, and everything when et is not a group event type, are left alone.
+func (h *hostCalendar) groupSessions(bookings []store.Booking, et EventType) (sessions []session, rest []store.Booking) {
+	if !et.group() {
+		return nil, bookings
+	}
+	return h.sessions(bookings, et)
+}
+
+// sessions is groupSessions for any event type. Each slot of an event type
+// with a capacity of one is a session with a single attendee.
+func (h *hostCalendar) sessions(bookings []store.Booking, et EventType) (sessions []session, rest []store.Booking) {
+	for _, b := range bookings {
+		if b.Host != h.id || b.Team != "" || b.EventType != et.Slug {
+			rest = append(rest, b)
+			continue
+		}
+		i := slices.IndexFunc(sessions, func(s session) bool { return s.Start.Equal(b.Start) })
+		if i < 0 {
+			sessions = append(sessions, session{Interval: availability.Interval{Start: b.Start, End: b.End}})
+			i = len(sessions) - 1
+		}
+		sessions[i].attendees = append(sessions[i].attendees, b)
+	}
+	slices.SortFunc(sessions, func(a, b session) int { return a.Start.Compare(b.Start) })
+	return sessions, rest
+}
+
+// groupSlots returns the bookable slots of a group event type: the sessions
+// that still have seats, and new slots cut from the free time that keep
+// clear of the existing sessions and their buffers. free must not already
+// count the sessions as busy.
+func groupSlots(free availability.Set, sessions []session, et EventType) []availability.Interval {
+	// Sessions are kept apart from new slots the way bookings are from
+	// each other: by the session's buffers and the new slot's.
+	gap := et.BufferBefore + et.BufferAfter
+	busy := make([]availability.Interval, 0, len(sessions))
+	for _, s := range sessions {
+		busy = append(busy, s.Grow(gap, gap))
+	}
+	slots := free.Subtract(availability.NewSet(busy...)).Split(et.Duration)
+	for _, s := range sessions {
+		if s.seats(et) > 0 && free.Covers(s.Interval) {
+			slots = append(slots, s.Interval)
+		}
+	}
+	slices.SortFunc(slots, func(a, b availability.Interval) int { return a.Start.Compare(b.Start) })
+	return slots
+}
+
+// sessionTimes returns the time taken up by the sessions.
+func sessionTimes(sessions []session) availability.Set {
+	times := make([]availability.Interval, 0, len(sessions))
+	for _, s := range sessions {
+		times = append(times, s.Interval)
+	}
+	return availability.NewSet(times...)
+}
+
+// seatsLeft returns how many people can still book the slot starting at
+// start.
+func seatsLeft(sessions []session, et EventType, start time.Time) int {
+	for _, s := range sessions {
+		if s.Start.Equal(start) {
+			return s.seats(et)
+		}
+	}
+	return et.Capacity
+}
+
+// sessionAttendees returns the active bookings in the same group session as
+// b, b included, soonest booked first.
+func (wsh *WebSocketHandler) sessionAttendees(b store.Booking) []store.Booking {
+	attendees := wsh.store.Bookings(func(other store.Booking) bool {
+		return other.Active() && sameSession(b, other)
+	})
+	slices.SortFunc(attendees, func(a, b store.Booking) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return attendees
+}
+
+// sameSession reports whether a and b were booked into the same slot of the
+// same event type.
+func sameSession(a, b store.Booking) bool {
+	return a.Host == b.Host && a.Team == b.Team && a.EventType == b.EventType && a.Start.Equal(b.Start)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupSessions(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	date := at(london, "00:00")
+	now := time.Date(2024, 11, 1, 0, 0, 0, 0, london)
+
+	for _, tt := range []struct {
+		name     string
+		limit    int      // Daily limit of the workshop
+		expected []string // Slots after two people join the 10:00 session
+	}{
+		{name: "Other slots stay open", expected: []string{"09:00 3", "10:00 1", "11:00 3"}},
+		{name: "Daily limit only keeps the session open", limit: 1, expected: []string{"10:00 1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			host, err := newHostCalendar(config.HostConfig{
+				ID:       "dave",
+				TimeZone: "Europe/London",
+				Schedule: config.Schedule{Default: config.Hours{Start: "09:00", End: "12:00"}},
+				EventTypes: []config.EventTypeConfig{
+					{Slug: "workshop", Name: "Workshop", DurationMinutes: 60, MaxBookingsPerDay: tt.limit, Capacity: 3},
+				},
+			}, st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			et, _ := host.eventType("workshop")
+
+			slots := func() []string {
+				_, slots := host.availability(date, et, now)
+				out := []string{}
+				for _, s := range slots {
+					out = append(out, fmt.Sprintf("%s %d", s.Start, s.Seats))
+				}
+				return out
+			}
+			book := func(name string) (store.Booking, error) {
+				return host.createBooking(CreateBookingRequest{
+					EventType: "workshop",
+					Date:      "2024-11-13",
+					Start:     "10:00",
+					Name:      name,
+					Email:     name + "@example.com",
+				}, now)
+			}
+
+			if got, want := slots(), []string{"09:00 3", "10:00 3", "11:00 3"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Before booking got %v, want %v", got, want)
+			}
+			ada, err := book("ada")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := book("bob"); err != nil {
+				t.Fatal(err)
+			}
+			if got := slots(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("After two bookings got %v, want %v", got, tt.expected)
+			}
+
+			if _, err := book("cy"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := book("dee"); !errors.Is(err, errSlotUnavailable) && !errors.Is(err, errInvalidBooking) {
+				t.Errorf("Expected the full session to be refused, got %v", err)
+			}
+
+			// Cancelling one attendee frees their seat only.
+			if _, err := st.UpdateBooking(ada.ID, func(b *store.Booking) error {
+				b.Status = store.StatusCancelled
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := slots(), tt.expected; !reflect.DeepEqual(got, want) {
+				t.Errorf("After a cancellation got %v, want %v", got, want)
+			}
+		})
+	}
+}
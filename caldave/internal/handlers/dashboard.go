@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"caldave/internal/auth"
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// weekdays lists the days in the order the dashboard shows them.
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// AdminDashboard serves the /admin pages where hosts see their upcoming
+// bookings, cancel or approve them, edit their hours and date overrides and
+// check their calendar connections.
+type AdminDashboard struct {
+	wsh      *WebSocketHandler
+	sessions *auth.Sessions
+	token    string // The admin token doubles as the login password
+}
+
+func NewAdminDashboard(wsh *WebSocketHandler, sessions *auth.Sessions, token string) *AdminDashboard {
+	return &AdminDashboard{wsh: wsh, sessions: sessions, token: token}
+}
+
+type loginPage struct {
+	Error string
+}
+
+type dashboardPage struct {
+	CSRF     string
+	Notice   string
+	Error    string
+	Hosts    []dashboardHost
+	Bookings []dashboardBooking
+}
+
+type dashboardHost struct {
+	ID        string
+	Name      string
+	TimeZone  string
+	Connected bool
+	LastSync  string
+	SyncError string
+	Hours     []dashboardHours
+	Overrides []overrideView
+}
+
+type dashboardHours struct {
+	Day   string // Lower case weekday name, used in form fields
+	Start string
+	End   string
+}
+
+type dashboardBooking struct {
+	ID        string
+	Host      string
+	Team      string
+	EventType string
+	When      string
+	Name      string
+	Email     string
+	Status    string
+	Pending   bool
+	Expires   string // When a pending booking stops holding its slot
+	Seats     string // How full a group session is, e.g. "3 of 8"
+}
+
+// LoginPage serves GET /admin/login.
+func (d *AdminDashboard) LoginPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.render(w, "admin_login.html", loginPage{})
+	})
+}
+
+// Login serves POST /admin/login.
+func (d *AdminDashboard) Login() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(d.token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			d.render(w, "admin_login.html", loginPage{Error: "Wrong admin token"})
+			return
+		}
+		if err := d.sessions.Start(w, r); err != nil {
+			slog.ErrorContext(r.Context(), "Error starting admin session", "error", err)
+			http.Error(w, "Unable to log in", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, d.wsh.site.Path("/admin"), http.StatusSeeOther)
+	})
+}
+
+// Logout serves POST /admin/logout.
+func (d *AdminDashboard) Logout() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.sessions.End(w, r)
+		http.Redirect(w, r, d.wsh.site.Path("/admin/login"), http.StatusSeeOther)
+	})
+}
+
+// Dashboard serves GET /admin.
+func (d *AdminDashboard) Dashboard() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flash := d.sessions.TakeFlash(r)
+		page := dashboardPage{
+			CSRF:   auth.CSRFToken(r),
+			Notice: flash.Notice,
+			Error:  flash.Error,
+		}
+		for _, id := range d.wsh.hostIDs {
+			page.Hosts = append(page.Hosts, d.hostView(d.wsh.hosts[id]))
+		}
+		page.Bookings = d.upcomingBookings(time.Now())
+		d.render(w, "admin.html", page)
+	})
+}
+
+func (d *AdminDashboard) hostView(h *hostCalendar) dashboardHost {
+	sync := h.syncStatus()
+	view := dashboardHost{
+		ID:        h.id,
+		Name:      h.name,
+		TimeZone:  h.location.String(),
+		Connected: sync.connected,
+		Overrides: h.overrideViews(),
+	}
+	if !sync.lastSync.IsZero() {
+		view.LastSync = sync.lastSync.In(h.location).Format("Mon 2 Jan 15:04 MST")
+	}
+	if sync.err != nil {
+		view.SyncError = sync.err.Error()
+	}
+
+	schedule := h.currentSchedule()
+	for _, day := range weekdays {
+		hours, ok := schedule.WeekdayHours[day]
+		if !ok {
+			hours = schedule.DefaultHours
+		}
+		row := dashboardHours{Day: strings.ToLower(day.String())}
+		if hours.StartTime != hours.EndTime {
+			row.Start, row.End = hours.StartTime, hours.EndTime
+		}
+		view.Hours = append(view.Hours, row)
+	}
+	return view
+}
+
+// upcomingBookings lists the active bookings that have not ended yet, soonest
+// first.
+func (d *AdminDashboard) upcomingBookings(now time.Time) []dashboardBooking {
+	bookings := d.wsh.store.Bookings(func(b store.Booking) bool {
+		return b.Active() && b.End.After(now)
+	})
+	sort.Slice(bookings, func(i, j int) bool { return bookings[i].Start.Before(bookings[j].Start) })
+
+	views := make([]dashboardBooking, 0, len(bookings))
+	for _, b := range bookings {
+		loc := time.Local
+		if host, ok := d.wsh.hosts[b.Host]; ok {
+			loc = host.location
+		}
+		hosts := b.Host
+		if len(b.Members) > 0 {
+			hosts = strings.Join(b.Members, ", ")
+		}
+		views = append(views, dashboardBooking{
+			ID:        b.ID,
+			Host:      hosts,
+			Team:      b.Team,
+			EventType: b.EventType,
+			When:      b.Start.In(loc).Format("Mon 2 Jan 2006 15:04") + " - " + b.End.In(loc).Format("15:04 MST"),
+			Name:      b.Name,
+			Email:     b.Email,
+			Status:    b.Status,
+			Pending:   b.Status == store.StatusPending,
+		})
+		if b.Status == store.StatusPending {
+			views[len(views)-1].Expires = b.ExpiresAt.In(loc).Format("Mon 2 Jan 15:04")
+		}
+		if host, ok := d.wsh.hosts[b.Host]; ok && b.Team == "" {
+			if et, ok := host.eventType(b.EventType); ok && et.group() {
+				taken := 0
+				for _, other := range bookings {
+					if sameSession(b, other) {
+						taken++
+					}
+				}
+				views[len(views)-1].Seats = fmt.Sprintf("%d of %d", taken, et.Capacity)
+			}
+		}
+	}
+	return views
+}
+
+// CancelBooking serves POST /admin/bookings/{id}/cancel.
+func (d *AdminDashboard) CancelBooking() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := d.wsh.cancelBooking(r.Context(), r.PathValue("id"))
+		d.redirect(w, r, "Booking cancelled", err)
+	})
+}
+
+// ApproveBooking serves POST /admin/bookings/{id}/approve.
+func (d *AdminDashboard) ApproveBooking() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := d.wsh.approveBooking(r.Context(), r.PathValue("id"))
+		d.redirect(w, r, "Booking approved", err)
+	})
+}
+
+// DeclineBooking serves POST /admin/bookings/{id}/decline.
+func (d *AdminDashboard) DeclineBooking() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := d.wsh.declineBooking(r.Context(), r.PathValue("id"))
+		d.redirect(w, r, "Booking declined", err)
+	})
+}
+
+// SaveHours serves POST /admin/hosts/{host}/hours. Days left empty are
+// closed.
+func (d *AdminDashboard) SaveHours() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := d.wsh.hosts[r.PathValue("host")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		hours := make(map[string]config.Hours, len(weekdays))
+		for _, day := range weekdays {
+			name := strings.ToLower(day.String())
+			h := config.Hours{
+				Start: strings.TrimSpace(r.PostFormValue(name + "_start")),
+				End:   strings.TrimSpace(r.PostFormValue(name + "_end")),
+			}
+			if h.Start == "" && h.End == "" {
+				hours[name] = config.Hours{Start: "00:00", End: "00:00"}
+				continue
+			}
+			if err := h.Validate(); err != nil {
+				d.redirect(w, r, "", fmt.Errorf("%s: %w", day, err))
+				return
+			}
+			if h.Start >= h.End {
+				d.redirect(w, r, "", fmt.Errorf("%s: closing time must be after opening time", day))
+				return
+			}
+			hours[name] = h
+		}
+
+		if err := host.store.SetWeekdayHours(host.id, hours); err != nil {
+			slog.ErrorContext(r.Context(), "Error saving hours", "host", host.id, "error", err)
+			d.redirect(w, r, "", errors.New("unable to save hours"))
+			return
+		}
+		d.wsh.scheduleChanged(host.id)
+		d.redirect(w, r, "Hours saved for "+host.name, nil)
+	})
+}
+
+// AddOverride serves POST /admin/hosts/{host}/overrides. The hours field
+// holds windows such as "09:00-12:00, 13:00-17:00"; empty means closed.
+func (d *AdminDashboard) AddOverride() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := d.wsh.hosts[r.PathValue("host")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		windows, err := parseWindows(r.PostFormValue("hours"))
+		if err != nil {
+			d.redirect(w, r, "", err)
+			return
+		}
+		o := config.Override{
+			Date:  r.PostFormValue("date"),
+			Until: r.PostFormValue("until"),
+			Hours: windows,
+			Note:  strings.TrimSpace(r.PostFormValue("note")),
+		}
+		if err := o.Validate(); err != nil {
+			d.redirect(w, r, "", err)
+			return
+		}
+
+		err = host.store.SetOverride(host.id, o)
+		if errors.Is(err, store.ErrConflict) {
+			d.redirect(w, r, "", errors.New("the override covers days of another override"))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving override", "host", host.id, "error", err)
+			d.redirect(w, r, "", errors.New("unable to save override"))
+			return
+		}
+		d.wsh.scheduleChanged(host.id)
+		d.redirect(w, r, "Override saved for "+host.name, nil)
+	})
+}
+
+// DeleteOverride serves POST /admin/hosts/{host}/overrides/{date}/delete.
+func (d *AdminDashboard) DeleteOverride() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := d.wsh.hosts[r.PathValue("host")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		err := host.store.DeleteOverride(host.id, r.PathValue("date"))
+		if errors.Is(err, store.ErrNotFound) {
+			d.redirect(w, r, "", errors.New("only overrides added here can be deleted"))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting override", "host", host.id, "error", err)
+			d.redirect(w, r, "", errors.New("unable to delete override"))
+			return
+		}
+		d.wsh.scheduleChanged(host.id)
+		d.redirect(w, r, "Override deleted", nil)
+	})
+}
+
+// SyncHost serves POST /admin/hosts/{host}/sync.
+func (d *AdminDashboard) SyncHost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ok := d.wsh.hosts[r.PathValue("host")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !host.syncStatus().connected {
+			d.redirect(w, r, "", errors.New(host.name+" has not connected a calendar"))
+			return
+		}
+		d.wsh.syncHost(r.Context(), host)
+		if err := host.syncStatus().err; err != nil {
+			d.redirect(w, r, "", fmt.Errorf("syncing %s: %w", host.name, err))
+			return
+		}
+		d.redirect(w, r, "Calendar synced for "+host.name, nil)
+	})
+}
+
+// redirect sends the browser back to the dashboard, which shows notice, or
+// err if there is one, once.
+func (d *AdminDashboard) redirect(w http.ResponseWriter, r *http.Request, notice string, err error) {
+	flash := auth.Flash{Notice: notice}
+	if err != nil {
+		flash = auth.Flash{Error: err.Error()}
+	}
+	d.sessions.SetFlash(r, flash)
+	http.Redirect(w, r, d.wsh.site.Path("/admin"), http.StatusSeeOther)
+}
+
+func (d *AdminDashboard) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := d.wsh.render(w, name, data); err != nil {
+		slog.Error("Error rendering template", "template", name, "error", err)
+	}
+}
+
+// parseWindows parses opening windows written as "09:00-12:00, 13:00-17:00".
+func parseWindows(s string) ([]config.Hours, error) {
+	var windows []config.Hours
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q, use 09:00-12:00", part)
+		}
+		windows = append(windows, config.Hours{Start: strings.TrimSpace(start), End: strings.TrimSpace(end)})
+	}
+	return windows, nil
+}
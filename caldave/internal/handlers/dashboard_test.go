@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"caldave/internal/auth"
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var csrfField = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+// dashboardClient browses the admin dashboard of wsh like a browser, keeping
+// its session cookie and following redirects.
+type dashboardClient struct {
+	t      *testing.T
+	url    string
+	client *http.Client
+	csrf   string
+}
+
+func newDashboardClient(t *testing.T, wsh *WebSocketHandler) *dashboardClient {
+	t.Helper()
+	sessions := auth.NewSessions(time.Hour, "/admin")
+	dashboard := NewAdminDashboard(wsh, sessions, "s3cret")
+	loggedIn := func(h http.Handler) http.Handler {
+		return sessions.Require("/admin/login", h)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/login", dashboard.LoginPage())
+	mux.Handle("POST /admin/login", dashboard.Login())
+	mux.Handle("GET /admin", loggedIn(dashboard.Dashboard()))
+	mux.Handle("POST /admin/bookings/{id}/cancel", loggedIn(dashboard.CancelBooking()))
+	mux.Handle("POST /admin/bookings/{id}/approve", loggedIn(dashboard.ApproveBooking()))
+	mux.Handle("POST /admin/hosts/{host}/hours", loggedIn(dashboard.SaveHours()))
+	mux.Handle("POST /admin/hosts/{host}/overrides", loggedIn(dashboard.AddOverride()))
+	mux.Handle("POST /admin/hosts/{host}/overrides/{date}/delete", loggedIn(dashboard.DeleteOverride()))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dashboardClient{t: t, url: srv.URL, client: &http.Client{Jar: jar}}
+}
+
+// post submits form to path, adding the CSRF token of the last dashboard
+// page seen, and returns the final status code, path and page.
+func (c *dashboardClient) post(path string, form url.Values) (int, string, string) {
+	c.t.Helper()
+	if form == nil {
+		form = url.Values{}
+	}
+	if !form.Has("csrf") {
+		form.Set("csrf", c.csrf)
+	}
+	return c.do(c.client.PostForm(c.url+path, form))
+}
+
+func (c *dashboardClient) get(path string) (int, string, string) {
+	c.t.Helper()
+	return c.do(c.client.Get(c.url + path))
+}
+
+func (c *dashboardClient) do(resp *http.Response, err error) (int, string, string) {
+	c.t.Helper()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if m := csrfField.FindSubmatch(body); m != nil {
+		c.csrf = string(m[1])
+	}
+	return resp.StatusCode, resp.Request.URL.Path, string(body)
+}
+
+func TestDashboardLogin(t *testing.T) {
+	c := newDashboardClient(t, newTestHandler(t, config.HostConfig{ID: "dave", Name: "Dave", TimeZone: "UTC"}))
+
+	if _, path, _ := c.get("/admin"); path != "/admin/login" {
+		t.Errorf("Expected a visitor without a session to be sent to the login, got %s", path)
+	}
+	code, _, body := c.post("/admin/login", url.Values{"token": {"wrong"}})
+	if code != http.StatusUnauthorized || !strings.Contains(body, "Wrong admin token") {
+		t.Errorf("Expected a wrong token to be refused, got %d", code)
+	}
+	if _, path, _ := c.get("/admin"); path != "/admin/login" {
+		t.Errorf("Expected a wrong token not to start a session, got %s", path)
+	}
+
+	code, path, body := c.post("/admin/login", url.Values{"token": {"s3cret"}})
+	if code != http.StatusOK || path != "/admin" || !strings.Contains(body, "Dave") {
+		t.Fatalf("Expected the dashboard after logging in, got %d %s", code, path)
+	}
+	if c.csrf == "" {
+		t.Error("Expected the dashboard forms to carry a CSRF token")
+	}
+}
+
+func TestDashboardActions(t *testing.T) {
+	wsh := newTestHandler(t, config.HostConfig{ID: "dave", Name: "Dave", TimeZone: "UTC"})
+	dave := wsh.hosts["dave"]
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Hour)
+	pending, err := wsh.store.AddBooking(store.Booking{
+		Host: "dave", Start: start, End: start.Add(30 * time.Minute), Name: "Grace", Email: "grace@example.com", Status: store.StatusPending,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func() string {
+		b, err := wsh.store.Booking(pending.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Status
+	}
+
+	c := newDashboardClient(t, wsh)
+	if _, _, body := c.post("/admin/login", url.Values{"token": {"s3cret"}}); !strings.Contains(body, "Grace") {
+		t.Error("Expected the dashboard to list the upcoming booking")
+	}
+
+	if code, _, _ := c.post("/admin/bookings/"+pending.ID+"/approve", url.Values{"csrf": {"forged"}}); code != http.StatusForbidden {
+		t.Errorf("Expected a form without the CSRF token to be refused, got %d", code)
+	}
+	if got := status(); got != store.StatusPending {
+		t.Errorf("Expected the refused form to leave the booking %s, got %s", store.StatusPending, got)
+	}
+
+	_, path, body := c.post("/admin/bookings/"+pending.ID+"/approve", nil)
+	if path != "/admin" || !strings.Contains(body, "Booking approved") {
+		t.Errorf("Expected to be back on the dashboard with a notice, got %s", path)
+	}
+	if got := status(); got != store.StatusConfirmed {
+		t.Errorf("Expected the booking to be %s, got %s", store.StatusConfirmed, got)
+	}
+	if _, _, body := c.get("/admin"); strings.Contains(body, "Booking approved") {
+		t.Error("Expected the notice to be shown only once")
+	}
+	if _, _, body := c.post("/admin/bookings/"+pending.ID+"/approve", nil); !strings.Contains(body, "booking cannot be changed") {
+		t.Error("Expected a confirmed booking not to be approved again")
+	}
+
+	c.post("/admin/bookings/"+pending.ID+"/cancel", nil)
+	if got := status(); got != store.StatusCancelled {
+		t.Errorf("Expected the booking to be %s, got %s", store.StatusCancelled, got)
+	}
+
+	if _, _, body := c.post("/admin/hosts/dave/hours", url.Values{"monday_start": {"17:00"}, "monday_end": {"09:00"}}); !strings.Contains(body, "closing time must be after opening time") {
+		t.Error("Expected hours closing before they open to be refused")
+	}
+	c.post("/admin/hosts/dave/hours", url.Values{"monday_start": {"10:00"}, "monday_end": {"16:00"}})
+	schedule := dave.currentSchedule()
+	if got := schedule.WeekdayHours[time.Monday]; got != (BusinessHours{StartTime: "10:00", EndTime: "16:00"}) {
+		t.Errorf("Expected Monday to open 10:00-16:00, got %v", got)
+	}
+	if got := schedule.WeekdayHours[time.Tuesday]; got.StartTime != got.EndTime {
+		t.Errorf("Expected days left empty to be closed, got %v", got)
+	}
+
+	c.post("/admin/hosts/dave/overrides", url.Values{"date": {"2030-01-02"}, "hours": {"09:00-12:00"}, "note": {"Dentist"}})
+	if got := wsh.store.Overrides("dave"); len(got) != 1 || got[0].Note != "Dentist" {
+		t.Fatalf("Expected the override to be saved, got %v", got)
+	}
+	if _, _, body := c.post("/admin/hosts/dave/overrides", url.Values{"date": {"2030-01-01"}, "until": {"2030-01-03"}}); !strings.Contains(body, "another override") {
+		t.Error("Expected an overlapping override to be refused")
+	}
+	c.post("/admin/hosts/dave/overrides/2030-01-02/delete", nil)
+	if got := wsh.store.Overrides("dave"); len(got) != 0 {
+		t.Errorf("Expected the override to be deleted, got %v", got)
+	}
+}
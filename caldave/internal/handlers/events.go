@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"caldave/internal/store"
+)
+
+// Booking lifecycle events passed to OnBookingEvent listeners.
+const (
+	BookingCreatedEvent     = "booking.created"     // Confirmed straight away or pending approval
+	BookingConfirmedEvent   = "booking.confirmed"   // A pending booking was approved
+	BookingDeclinedEvent    = "booking.declined"    // A pending booking was turned down
+	BookingExpiredEvent     = "booking.expired"     // A pending booking was not approved in time
+	BookingRescheduledEvent = "booking.rescheduled" // Previous holds the old times
+	BookingCancelledEvent   = "booking.cancelled"
+)
+
+// BookingEvents lists every event type, for validating subscriptions.
+var BookingEvents = []string{
+	BookingCreatedEvent,
+	BookingConfirmedEvent,
+	BookingDeclinedEvent,
+	BookingExpiredEvent,
+	BookingRescheduledEvent,
+	BookingCancelledEvent,
+}
+
+// BookingEvent is something that happened to a booking.
+type BookingEvent struct {
+	Type     string         `json:"type"`
+	Booking  store.Booking  `json:"booking"`
+	Previous *store.Booking `json:"previous,omitempty"` // The booking before it was rescheduled
+}
+
+// Hosts returns every host attending the booking.
+func (e BookingEvent) Hosts() []string {
+	if len(e.Booking.Members) > 0 {
+		return e.Booking.Members
+	}
+	return []string{e.Booking.Host}
+}
+
+// OnBookingEvent registers fn to be called whenever a booking is created or
+// changes. fn is called synchronously and must not block.
+func (wsh *WebSocketHandler) OnBookingEvent(fn func(BookingEvent)) {
+	wsh.listenersMutex.Lock()
+	defer wsh.listenersMutex.Unlock()
+	wsh.listeners = append(wsh.listeners, fn)
+}
+
+func (wsh *WebSocketHandler) emit(eventType string, b store.Booking, previous *store.Booking) {
+	wsh.listenersMutex.RLock()
+	listeners := wsh.listeners
+	wsh.listenersMutex.RUnlock()
+
+	event := BookingEvent{Type: eventType, Booking: b, Previous: previous}
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
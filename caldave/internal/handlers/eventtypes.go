@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxAnswerLength    = 2000
+	defaultPendingHold = 24 * time.Hour
+)
+
+// EventType is a kind of meeting a host offers, with its own duration,
+// buffers, notice period, daily limit, capacity and intake questions.
+type EventType struct {
+	Slug              string
+	Name              string
+	Duration          time.Duration
+	BufferBefore      time.Duration
+	BufferAfter       time.Duration
+	MinimumNotice     time.Duration
+	MaxBookingsPerDay int // 0 means unlimited, counting each group session once
+	Capacity          int // How many people can book the same slot, at least 1
+	Questions         []config.Question
+
+	RequiresConfirmation bool
+	PendingHold          time.Duration // How long a pending booking holds its slot
+}
+
+// eventTypeView is how an event type is sent to the booking page.
+type eventTypeView struct {
+	Slug            string            `json:"slug"`
+	Name            string            `json:"name"`
+	DurationMinutes int               `json:"durationMinutes"`
+	Capacity        int               `json:"capacity"`
+	Questions       []config.Question `json:"questions"`
+
+	RequiresConfirmation bool `json:"requiresConfirmation"`
+}
+
+func eventTypeFromConfig(cfg config.EventTypeConfig) EventType {
+	et := EventType{
+		Slug:              cfg.Slug,
+		Name:              cfg.Name,
+		Duration:          time.Duration(cfg.DurationMinutes) * time.Minute,
+		BufferBefore:      time.Duration(cfg.BufferBeforeMinutes) * time.Minute,
+		BufferAfter:       time.Duration(cfg.BufferAfterMinutes) * time.Minute,
+		MinimumNotice:     time.Duration(cfg.MinimumNoticeMinutes) * time.Minute,
+		MaxBookingsPerDay: cfg.MaxBookingsPerDay,
+		Capacity:          max(cfg.Capacity, 1),
+		Questions:         cfg.Questions,
+
+		RequiresConfirmation: cfg.RequiresConfirmation,
+		PendingHold:          time.Duration(cfg.PendingHoldMinutes) * time.Minute,
+	}
+	if et.PendingHold == 0 {
+		et.PendingHold = defaultPendingHold
+	}
+	return et
+}
+
+func eventTypesFromConfig(cfgs []config.EventTypeConfig) []EventType {
+	eventTypes := make([]EventType, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		eventTypes = append(eventTypes, eventTypeFromConfig(cfg))
+	}
+	return eventTypes
+}
+
+// findEventType looks up an event type by slug. An empty slug selects the
+// first one.
+func findEventType(eventTypes []EventType, slug string) (EventType, bool) {
+	if len(eventTypes) == 0 {
+		return EventType{}, false
+	}
+	if slug == "" {
+		return eventTypes[0], true
+	}
+	for _, et := range eventTypes {
+		if et.Slug == slug {
+			return et, true
+		}
+	}
+	return EventType{}, false
+}
+
+func eventTypeViews(eventTypes []EventType) []eventTypeView {
+	views := make([]eventTypeView, 0, len(eventTypes))
+	for _, et := range eventTypes {
+		views = append(views, et.view())
+	}
+	return views
+}
+
+func (et EventType) view() eventTypeView {
+	return eventTypeView{
+		Slug:            et.Slug,
+		Name:            et.Name,
+		DurationMinutes: int(et.Duration / time.Minute),
+		Capacity:        et.Capacity,
+		Questions:       et.Questions,
+
+		RequiresConfirmation: et.RequiresConfirmation,
+	}
+}
+
+// group reports whether several people can book the same slot.
+func (et EventType) group() bool {
+	return et.Capacity > 1
+}
+
+// schedule returns base with the event type's buffers applied.
+func (et EventType) schedule(base ScheduleConfig) ScheduleConfig {
+	base.BufferBeforeMinutes = int(et.BufferBefore / time.Minute)
+	base.BufferAfterMinutes = int(et.BufferAfter / time.Minute)
+	return base
+}
+
+// validateAnswers checks the booker's answers against the event type's
+// questions. Answers to unknown questions are rejected.
+func (et EventType) validateAnswers(answers map[string]string) error {
+	for id := range answers {
+		if !slices.ContainsFunc(et.Questions, func(q config.Question) bool { return q.ID == id }) {
+			return fmt.Errorf("%w: unknown question %q", errInvalidBooking, id)
+		}
+	}
+
+	for _, q := range et.Questions {
+		answer := strings.TrimSpace(answers[q.ID])
+		if answer == "" {
+			if q.Required {
+				return fmt.Errorf("%w: %s is required", errInvalidBooking, q.Label)
+			}
+			continue
+		}
+		if len(answer) > maxAnswerLength {
+			return fmt.Errorf("%w: %s is too long", errInvalidBooking, q.Label)
+		}
+
+		switch q.Type {
+		case "email":
+			if _, err := mail.ParseAddress(answer); err != nil {
+				return fmt.Errorf("%w: %s must be an email address", errInvalidBooking, q.Label)
+			}
+		case "select":
+			if !slices.Contains(q.Options, answer) {
+				return fmt.Errorf("%w: %s must be one of the listed options", errInvalidBooking, q.Label)
+			}
+		case "checkbox":
+			if answer != "true" && answer != "false" {
+				return fmt.Errorf("%w: %s must be true or false", errInvalidBooking, q.Label)
+			}
+			if q.Required && answer != "true" {
+				return fmt.Errorf("%w: %s is required", errInvalidBooking, q.Label)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/utils"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventTypeAvailability(t *testing.T) {
+	consult := config.EventTypeConfig{
+		Slug:                "consult",
+		DurationMinutes:     60,
+		BufferBeforeMinutes: 15,
+		BufferAfterMinutes:  30,
+	}
+	host := func(id string) config.HostConfig {
+		return config.HostConfig{
+			ID:         id,
+			TimeZone:   "UTC",
+			Schedule:   config.Schedule{Weekdays: map[string]config.Hours{}, Default: config.Hours{Start: "09:00", End: "12:00"}},
+			EventTypes: []config.EventTypeConfig{consult},
+		}
+	}
+	wsh := newTestHandler(t, host("dave"), host("ada"))
+	dave, ada := wsh.hosts["dave"], wsh.hosts["ada"]
+	date := time.Date(2024, 10, 11, 0, 0, 0, 0, time.UTC)
+	now := date.AddDate(0, 0, -1)
+	slots := func(h *hostCalendar) []TimeSlot {
+		et, _ := h.eventType("consult")
+		_, slots := h.availability(date, et, now)
+		return slots
+	}
+
+	// The meeting and its buffers have to fit before the 11:00 event.
+	dave.events = []utils.EventData{{StartTime: date.Add(11 * time.Hour), EndTime: date.Add(11*time.Hour + 30*time.Minute)}}
+	if got, want := slots(dave), []TimeSlot{{Start: "09:00", End: "10:00"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v for Dave, got %v", want, got)
+	}
+	full := []TimeSlot{{Start: "09:00", End: "10:00"}, {Start: "10:00", End: "11:00"}, {Start: "11:00", End: "12:00"}}
+	if got := slots(ada); !reflect.DeepEqual(got, full) {
+		t.Errorf("Expected %v for Ada, got %v", full, got)
+	}
+
+	req := CreateBookingRequest{EventType: "consult", Date: "2024-10-11", Start: "09:00", Name: "Grace", Email: "grace@example.com"}
+	b, err := dave.createBooking(req, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.End.Sub(b.Start) != time.Hour || b.BufferBeforeMinutes != 15 || b.BufferAfterMinutes != 30 {
+		t.Errorf("Expected a one hour booking with its buffers, got %+v", b)
+	}
+	if _, err := dave.createBooking(req, now); !errors.Is(err, errSlotUnavailable) {
+		t.Errorf("Expected the slot to be taken, got %v", err)
+	}
+	if got := slots(dave); len(got) != 0 {
+		t.Errorf("Expected Dave to be fully booked, got %v", got)
+	}
+	if got := slots(ada); !reflect.DeepEqual(got, full) {
+		t.Errorf("Expected Dave's booking not to affect Ada, got %v", got)
+	}
+}
+
+func TestValidateAnswers(t *testing.T) {
+	et := EventType{Questions: []config.Question{
+		{ID: "company", Label: "Company", Type: "text", Required: true},
+		{ID: "invoice", Label: "Invoice email", Type: "email"},
+		{ID: "topic", Label: "Topic", Type: "select", Options: []string{"Sales", "Support"}},
+		{ID: "terms", Label: "Terms", Type: "checkbox", Required: true},
+	}}
+	valid := map[string]string{"company": "ACME", "terms": "true"}
+
+	tests := []struct {
+		name    string
+		answers map[string]string
+		ok      bool
+	}{
+		{name: "Required answers only", answers: valid, ok: true},
+		{name: "All answers", answers: map[string]string{"company": "ACME", "invoice": "ap@acme.test", "topic": "Sales", "terms": "true"}, ok: true},
+		{name: "Missing required answer", answers: map[string]string{"terms": "true"}},
+		{name: "Blank required answer", answers: map[string]string{"company": "  ", "terms": "true"}},
+		{name: "Unchecked required checkbox", answers: map[string]string{"company": "ACME", "terms": "false"}},
+		{name: "Invalid email", answers: map[string]string{"company": "ACME", "invoice": "nope", "terms": "true"}},
+		{name: "Unlisted option", answers: map[string]string{"company": "ACME", "topic": "Other", "terms": "true"}},
+		{name: "Unknown question", answers: map[string]string{"company": "ACME", "terms": "true", "extra": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := et.validateAnswers(tt.answers)
+			if tt.ok && err != nil {
+				t.Errorf("validateAnswers() = %v, want no error", err)
+			}
+			if !tt.ok && !errors.Is(err, errInvalidBooking) {
+				t.Errorf("validateAnswers() = %v, want an invalid booking", err)
+			}
+		})
+	}
+}
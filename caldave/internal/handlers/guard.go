@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"caldave/internal/pow"
+	"caldave/internal/ratelimit"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	errRateLimited  = errors.New("too many requests, please slow down")
+	errAdminOnly    = errors.New("only admins can do that")
+	errBookingProof = errors.New("could not verify the booking request, please try again")
+)
+
+// Protection configures how the WebSocket handler guards against abuse.
+// Nil fields turn the corresponding check off.
+type Protection struct {
+	AllowOrigin    func(r *http.Request) bool // Handshakes from other origins get 403
+	IsAdmin        func(r *http.Request) bool // Only admins may force a calendar sync
+	IPLimiter      *ratelimit.Limiter         // Messages by client IP, shared with HTTP
+	BookingLimiter *ratelimit.Limiter         // Booking submissions by client IP
+	Guard          BookingGuard
+}
+
+// Messages each connection may send, on top of the limit by IP.
+const (
+	connectionRate  = 2 // Per second
+	connectionBurst = 20
+)
+
+// BookingGuard vets booking requests before they are considered, for
+// example by checking a proof of work or a CAPTCHA response.
+type BookingGuard interface {
+	// Challenge returns what the booking page needs to produce a proof.
+	Challenge(ctx context.Context) (ChallengeData, error)
+	// Verify checks the proof sent with a booking request from remoteIP.
+	Verify(ctx context.Context, proof, remoteIP string) error
+}
+
+// ChallengeData tells the booking page how to prove the booking comes from
+// a person. Type is "pow" for a proof of work, or "" when no proof is needed.
+type ChallengeData struct {
+	Type       string `json:"type"`
+	Token      string `json:"token,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+}
+
+// ProofOfWorkGuard makes each booking submission solve a challenge from
+// issuer first.
+func ProofOfWorkGuard(issuer *pow.Issuer) BookingGuard {
+	return powGuard{issuer}
+}
+
+type powGuard struct {
+	issuer *pow.Issuer
+}
+
+func (g powGuard) Challenge(ctx context.Context) (ChallengeData, error) {
+	c, err := g.issuer.Challenge(time.Now())
+	if err != nil {
+		return ChallengeData{}, err
+	}
+	return ChallengeData{Type: "pow", Token: c.Token, Difficulty: c.Difficulty}, nil
+}
+
+func (g powGuard) Verify(ctx context.Context, proof, remoteIP string) error {
+	return g.issuer.Verify(proof, time.Now())
+}
+
+// allow reports whether the client may send another message.
+func (c *Client) allow() bool {
+	if !c.limiter.Allow(time.Now()) {
+		return false
+	}
+	return c.protection.IPLimiter == nil || c.protection.IPLimiter.Allow(c.ip)
+}
+
+// checkBooking runs the booking limit and guard for a booking request.
+func (c *Client) checkBooking(req CreateBookingRequest) error {
+	if l := c.protection.BookingLimiter; l != nil && !l.Allow(c.ip) {
+		return errRateLimited
+	}
+	if c.protection.Guard == nil {
+		return nil
+	}
+	if err := c.protection.Guard.Verify(c.ctx, req.Proof, c.ip); err != nil {
+		c.logger.InfoContext(c.ctx, "Booking proof refused", "error", err)
+		return errBookingProof
+	}
+	return nil
+}
+
+func (c *Client) handleChallengeRequest() {
+	challenge := ChallengeData{}
+	if c.protection.Guard != nil {
+		var err error
+		if challenge, err = c.protection.Guard.Challenge(c.ctx); err != nil {
+			c.logger.ErrorContext(c.ctx, "Error creating booking challenge", "error", err)
+			c.sendError(errors.New("unable to prepare the booking, please try again"))
+			return
+		}
+	}
+	c.send(Message{Type: string(ChallengeResponse), Payload: challenge})
+}
+
+func (c *Client) sendError(err error) {
+	c.send(Message{Type: string(ErrorMessage), Payload: BookingErrorData{Error: err.Error()}})
+}
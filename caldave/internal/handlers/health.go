@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+)
+
+// HealthCheck is one thing /readyz looked at.
+type HealthCheck struct {
+	Name      string     `json:"name"`
+	OK        bool       `json:"ok"`
+	Error     string     `json:"error,omitempty"`
+	Connected *bool      `json:"connected,omitempty"` // Calendar checks only
+	LastSync  *time.Time `json:"lastSync,omitempty"`  // Calendar checks only
+	Degraded  bool       `json:"degraded,omitempty"`  // Some calendars failed to sync and show their earlier events
+}
+
+// summary leaves out why the check failed and when the calendar synced, as
+// the public /readyz must not show host errors to anyone who asks.
+func (c HealthCheck) summary() HealthCheck {
+	return HealthCheck{Name: c.Name, OK: c.OK, Degraded: c.Degraded}
+}
+
+// HealthResponse is the body of /healthz and /readyz.
+type HealthResponse struct {
+	Status string        `json:"status"` // "ok", "ready" or "not ready"
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthHandler serves GET /healthz. It only shows the process is serving
+// requests.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+	})
+}
+
+// ReadyHandler serves GET /readyz. It is ready when the store can be written
+// and every connected calendar could be reached on the last sync and was
+// synced within maxSyncAge. Hosts that have not connected a calendar yet do
+// not count against it, since connecting needs the server to take traffic.
+// Only the names and outcomes of the checks are shown; ReadinessReportHandler
+// has the details.
+func ReadyHandler(wsh *WebSocketHandler, maxSyncAge time.Duration) http.Handler {
+	return readyHandler(wsh, maxSyncAge, HealthCheck.summary)
+}
+
+// ReadinessReportHandler serves GET /admin/api/readyz, which is /readyz with
+// the errors and last sync times of the checks.
+func ReadinessReportHandler(wsh *WebSocketHandler, maxSyncAge time.Duration) http.Handler {
+	return readyHandler(wsh, maxSyncAge, func(c HealthCheck) HealthCheck { return c })
+}
+
+func readyHandler(wsh *WebSocketHandler, maxSyncAge time.Duration, show func(HealthCheck) HealthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := wsh.readiness(time.Now(), maxSyncAge)
+		for i, check := range response.Checks {
+			response.Checks[i] = show(check)
+		}
+		status := http.StatusOK
+		if response.Status != "ready" {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, status, response)
+	})
+}
+
+func (wsh *WebSocketHandler) readiness(now time.Time, maxSyncAge time.Duration) HealthResponse {
+	response := HealthResponse{Status: "ready"}
+
+	store := HealthCheck{Name: "store", OK: true}
+	if err := wsh.store.CheckWritable(); err != nil {
+		store.OK, store.Error = false, err.Error()
+	}
+	response.Checks = append(response.Checks, store)
+
+	for _, id := range wsh.hostIDs {
+		response.Checks = append(response.Checks, calendarCheck(wsh.hosts[id], now, maxSyncAge))
+	}
+
+	for _, check := range response.Checks {
+		if !check.OK {
+			response.Status = "not ready"
+		}
+	}
+	return response
+}
+
+func calendarCheck(h *hostCalendar, now time.Time, maxSyncAge time.Duration) HealthCheck {
+	sync := h.syncStatus()
+	check := HealthCheck{Name: "calendar:" + h.id, OK: true, Connected: &sync.connected, Degraded: sync.degraded}
+	if !sync.lastSync.IsZero() {
+		check.LastSync = &sync.lastSync
+	}
+	if sync.err != nil {
+		check.Error = sync.err.Error()
+	}
+
+	switch {
+	case !sync.connected:
+	case !sync.reachable:
+		check.OK = false
+	case sync.lastSync.IsZero():
+		check.OK, check.Error = false, "calendar has not been synced yet"
+	case now.Sub(sync.lastSync) > maxSyncAge:
+		check.OK = false
+		if check.Error == "" {
+			check.Error = "last sync was " + now.Sub(sync.lastSync).Round(time.Second).String() + " ago"
+		}
+	}
+	return check
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestCalendarCheck(t *testing.T) {
+	now := time.Date(2024, 10, 11, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		connected   bool
+		lastSync    time.Time
+		syncErr     error
+		unreachable bool
+		expected    bool
+	}{
+		{name: "Not connected", expected: true},
+		{name: "Synced recently", connected: true, lastSync: now.Add(-10 * time.Minute), expected: true},
+		{name: "Some calendars failed", connected: true, lastSync: now.Add(-10 * time.Minute), syncErr: errors.New("one calendar"), expected: true},
+		{name: "Never synced", connected: true, expected: false},
+		{name: "Stale", connected: true, lastSync: now.Add(-2 * time.Hour), expected: false},
+		{name: "Unreachable", connected: true, lastSync: now.Add(-10 * time.Minute), syncErr: errors.New("timeout"), unreachable: true, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &hostCalendar{id: "dave", lastSync: tt.lastSync, syncErr: tt.syncErr, unreachable: tt.unreachable}
+			if tt.connected {
+				h.calendarService = &calendar.Service{}
+			}
+			check := calendarCheck(h, now, 45*time.Minute)
+			if check.OK != tt.expected {
+				t.Errorf("Expected ok %v, got %+v", tt.expected, check)
+			}
+		})
+	}
+}
+
+func TestReadinessStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	st, err := store.Open(filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh := &WebSocketHandler{store: st}
+	if r := wsh.readiness(time.Now(), time.Hour); r.Status != "ready" {
+		t.Errorf("Expected ready, got %+v", r)
+	}
+
+	// Put a file where the store's directory should be.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if r := wsh.readiness(time.Now(), time.Hour); r.Status != "not ready" || r.Checks[0].OK {
+		t.Errorf("Expected the store check to fail, got %+v", r)
+	}
+}
+
+func TestReadyHandlerHidesDetails(t *testing.T) {
+	wsh := newTestHandler(t, config.HostConfig{ID: "dave", Name: "Dave"})
+	dave := wsh.hosts["dave"]
+	dave.calendarService = &calendar.Service{}
+	dave.lastSync, dave.syncErr, dave.unreachable = time.Now(), errors.New("token of dave@example.com was revoked"), true
+
+	for _, tt := range []struct {
+		handler http.Handler
+		details bool
+	}{
+		{handler: ReadyHandler(wsh, time.Hour)},
+		{handler: ReadinessReportHandler(wsh, time.Hour), details: true},
+	} {
+		w := httptest.NewRecorder()
+		tt.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"name":"calendar:dave"`) {
+			t.Errorf("Expected the failing calendar check, got %d: %s", w.Code, w.Body)
+		}
+		if got := strings.Contains(w.Body.String(), "dave@example.com"); got != tt.details {
+			t.Errorf("Expected the error shown to be %v, got %s", tt.details, w.Body)
+		}
+	}
+}
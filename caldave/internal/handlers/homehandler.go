@@ -1,36 +1,72 @@
 package handlers
 
 import (
+	"caldave/internal/i18n"
 	"embed"
-	"html/template"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 )
 
 //go:embed *.html
 var templateFiles embed.FS
 
-var tpl *template.Template
-
 type Calen struct {
 	Day   time.Weekday
 	Month time.Month
 	Year  int
 }
 
-func init() {
-	var err error
-	tpl, err = template.ParseFS(templateFiles, "*.html")
-	if err != nil {
-		panic(err)
-	}
+// homePage is the data rendered into index.html.
+type homePage struct {
+	Locale *i18n.Locale
+	Pages  []pageLink
 }
 
-func HomeHandler() http.Handler {
+// pageLink points to the booking page of a host or team.
+type pageLink struct {
+	Name     string
+	Path     string
+	TimeZone string
+	Team     bool
+}
+
+// HomeHandler renders the landing page, listing every booking page in the
+// language the browser asks for.
+func HomeHandler(wsh *WebSocketHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := tpl.ExecuteTemplate(w, "index.html", "Hello World")
+		w.Header().Add("Vary", "Accept-Language")
+		err := wsh.render(w, "index.html", homePage{
+			Locale: i18n.Match(r.Header.Get("Accept-Language")),
+			Pages:  wsh.pageLinks(),
+		})
 		if err != nil {
 			http.Error(w, "Error rendering page", http.StatusInternalServerError)
 		}
 	})
 }
+
+// pageLinks lists the hosts and then the teams, each by name.
+func (wsh *WebSocketHandler) pageLinks() []pageLink {
+	links := make([]pageLink, 0, len(wsh.owners))
+	for id, owner := range wsh.owners {
+		_, team := owner.(*teamCalendar)
+		links = append(links, pageLink{
+			Name:     owner.Name(),
+			Path:     wsh.site.Path("/book/" + id),
+			TimeZone: owner.Location().String(),
+			Team:     team,
+		})
+	}
+	slices.SortFunc(links, func(a, b pageLink) int {
+		if a.Team != b.Team {
+			if a.Team {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return links
+}
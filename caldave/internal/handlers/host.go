@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// calendarOwner is anything with a public booking page: a single host or a
+// team of hosts.
+type calendarOwner interface {
+	ID() string
+	Name() string
+	Location() *time.Location
+	Hub() *Hub
+	eventType(slug string) (EventType, bool)
+	eventTypeViews() []eventTypeView
+	availability(date time.Time, et EventType, now time.Time) (free []TimeSlot, slots []TimeSlot)
+	monthAvailability(month time.Time, et EventType, now time.Time) []DayAvailability
+	createBooking(req CreateBookingRequest, now time.Time) (store.Booking, error)
+	reschedule(b store.Booking, et EventType, date, start, now time.Time) (store.Booking, error)
+	updateEvents(ctx context.Context, start, end time.Time)
+}
+
+// hostCalendar holds everything that belongs to a single host: their
+// schedule, event types, calendar connection, cached events and connected
+// clients.
+type hostCalendar struct {
+	id         string
+	name       string
+	location   *time.Location
+	schedule   ScheduleConfig
+	eventTypes []EventType
+	hub        *Hub
+	store      *store.Store
+
+	configOverrides []config.Override // From the hosts file, already in schedule
+
+	mutex           sync.RWMutex
+	calendarService *calendar.Service
+	events          []utils.EventData
+	lastSync        time.Time // When the events were last fetched
+	syncErr         error     // Why the last fetch failed, nil if it worked
+	unreachable     bool      // The last fetch could not even list the calendars
+
+	bookingMutex sync.Mutex // Serialises availability checks with booking creation
+}
+
+func newHostCalendar(cfg config.HostConfig, st *store.Store) (*hostCalendar, error) {
+	location := time.Local
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", cfg.ID, err)
+		}
+		location = loc
+	}
+
+	schedule, err := scheduleFromConfig(cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("host %s: %w", cfg.ID, err)
+	}
+
+	host := &hostCalendar{
+		id:       cfg.ID,
+		name:     cfg.Name,
+		location: location,
+		schedule: schedule,
+		store:    st,
+
+		configOverrides: cfg.Schedule.Overrides,
+	}
+	host.eventTypes = eventTypesFromConfig(cfg.EventTypes)
+	host.hub = NewHub(host)
+	return host, nil
+}
+
+func (h *hostCalendar) ID() string               { return h.id }
+func (h *hostCalendar) Name() string             { return h.name }
+func (h *hostCalendar) Location() *time.Location { return h.location }
+func (h *hostCalendar) Hub() *Hub                { return h.hub }
+
+// eventType looks up an event type by slug. An empty slug selects the first
+// one.
+func (h *hostCalendar) eventType(slug string) (EventType, bool) {
+	return findEventType(h.eventTypes, slug)
+}
+
+func (h *hostCalendar) eventTypeViews() []eventTypeView {
+	return eventTypeViews(h.eventTypes)
+}
+
+func scheduleFromConfig(cfg config.Schedule) (ScheduleConfig, error) {
+	weekdays, err := cfg.WeekdayHours()
+	if err != nil {
+		return ScheduleConfig{}, err
+	}
+
+	schedule := ScheduleConfig{
+		WeekdayHours:        make(map[time.Weekday]BusinessHours, len(weekdays)),
+		DefaultHours:        BusinessHours{StartTime: cfg.Default.Start, EndTime: cfg.Default.End},
+		BufferBeforeMinutes: cfg.BufferMinutes,
+		BufferAfterMinutes:  cfg.BufferMinutes,
+
+		MinimumNoticeMinutes: cfg.MinimumNoticeMinutes,
+		MaxDaysInFuture:      cfg.MaxDaysInFuture,
+		MaxBookingsPerDay:    cfg.MaxBookingsPerDay,
+		MaxBookingsPerWeek:   cfg.MaxBookingsPerWeek,
+	}
+	for day, hours := range weekdays {
+		schedule.WeekdayHours[day] = BusinessHours{StartTime: hours.Start, EndTime: hours.End}
+	}
+	schedule.DateOverrides = overrideHours(cfg.Overrides)
+	return schedule, nil
+}
+
+// overrideHours expands date overrides into opening windows per day.
+func overrideHours(overrides []config.Override) map[string][]BusinessHours {
+	hours := make(map[string][]BusinessHours)
+	for _, o := range overrides {
+		windows := make([]BusinessHours, 0, len(o.Hours))
+		for _, h := range o.Hours {
+			windows = append(windows, BusinessHours{StartTime: h.Start, EndTime: h.End})
+		}
+		sort.Slice(windows, func(i, j int) bool { return windows[i].StartTime < windows[j].StartTime })
+
+		days, err := o.Days()
+		if err != nil {
+			continue // Rejected when the override was loaded or saved
+		}
+		for _, day := range days {
+			hours[day] = windows
+		}
+	}
+	return hours
+}
+
+// currentSchedule returns the host's schedule with the changes saved through
+// the admin API and dashboard applied: weekday hours replace those from the
+// hosts file, and saved overrides take precedence over configured ones.
+func (h *hostCalendar) currentSchedule() ScheduleConfig {
+	schedule := h.schedule
+
+	if hours := h.store.WeekdayHours(h.id); hours != nil {
+		schedule.WeekdayHours = make(map[time.Weekday]BusinessHours, len(hours))
+		for name, hours := range hours {
+			if day, ok := config.ParseWeekday(name); ok {
+				schedule.WeekdayHours[day] = BusinessHours{StartTime: hours.Start, EndTime: hours.End}
+			}
+		}
+	}
+
+	if saved := h.store.Overrides(h.id); len(saved) > 0 {
+		schedule.DateOverrides = maps.Clone(h.schedule.DateOverrides)
+		if schedule.DateOverrides == nil {
+			schedule.DateOverrides = make(map[string][]BusinessHours)
+		}
+		maps.Copy(schedule.DateOverrides, overrideHours(saved))
+	}
+	return schedule
+}
+
+func (h *hostCalendar) setService(srv *calendar.Service) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.calendarService = srv
+}
+
+// Events returns the cached calendar events.
+func (h *hostCalendar) Events() []utils.EventData {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.events
+}
+
+// updateEvents refetches the events between start and end from all of the
+// host's calendars. It does nothing until the calendar is connected.
+func (h *hostCalendar) updateEvents(ctx context.Context, start, end time.Time) {
+	h.mutex.RLock()
+	srv := h.calendarService
+	h.mutex.RUnlock()
+	if srv == nil {
+		return
+	}
+
+	started := time.Now()
+	defer func() { calendarSyncDuration.Observe(time.Since(started).Seconds(), h.id) }()
+
+	calendars, err := utils.GetCalendars(ctx, srv)
+	if err != nil {
+		// Keep the events we have rather than showing the host as free.
+		slog.ErrorContext(ctx, "Error syncing calendars", "host", h.id, "error", err)
+		calendarSyncFailures.Inc(h.id)
+		h.mutex.Lock()
+		h.syncErr = err
+		h.unreachable = true
+		h.mutex.Unlock()
+		return
+	}
+	events, err := utils.GetEvents(ctx, start.Format(time.RFC3339), end.Format(time.RFC3339), srv, calendars)
+	if err != nil {
+		slog.WarnContext(ctx, "Error syncing events", "host", h.id, "error", err)
+		calendarSyncFailures.Inc(h.id)
+	}
+
+	h.mutex.Lock()
+	if err != nil {
+		// Keep the last events of the calendars that failed, as above.
+		failed := utils.FailedCalendars(err)
+		for _, e := range h.events {
+			if failed[e.Calendar.CalendarID] {
+				events = append(events, e)
+			}
+		}
+	}
+	h.events = events
+	h.lastSync = time.Now()
+	h.syncErr = err
+	h.unreachable = false
+	h.mutex.Unlock()
+}
+
+// syncState describes how the host's calendar sync is doing.
+type syncState struct {
+	connected bool      // The host has authorized access to their calendar
+	reachable bool      // The last fetch could list the calendars
+	degraded  bool      // Some calendars could not be fetched, their events are from earlier
+	lastSync  time.Time // When the events were last fetched, zero if never
+	err       error     // Why the last fetch failed, nil if it worked
+}
+
+func (h *hostCalendar) syncStatus() syncState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return syncState{
+		connected: h.calendarService != nil,
+		reachable: !h.unreachable,
+		degraded:  !h.unreachable && h.syncErr != nil,
+		lastSync:  h.lastSync,
+		err:       h.syncErr,
+	}
+}
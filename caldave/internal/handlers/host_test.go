@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// newTestHandler serves the given hosts from an empty store without
+// connecting their calendars.
+func newTestHandler(t *testing.T, hosts ...config.HostConfig) *WebSocketHandler {
+	t.Helper()
+	st, err := store.Open(filepath.Join(t.TempDir(), "caldave.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh := &WebSocketHandler{
+		hosts:       make(map[string]*hostCalendar, len(hosts)),
+		owners:      make(map[string]calendarOwner, len(hosts)),
+		defaultHost: hosts[0].ID,
+		store:       st,
+		notifier:    make(sentMessages, 100),
+	}
+	for _, cfg := range hosts {
+		if cfg.Schedule.Weekdays == nil {
+			cfg.Schedule = config.DefaultSchedule()
+		}
+		if len(cfg.EventTypes) == 0 {
+			cfg.EventTypes = []config.EventTypeConfig{config.DefaultEventType(cfg.Schedule)}
+		}
+		host, err := newHostCalendar(cfg, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsh.hosts[cfg.ID] = host
+		wsh.hostIDs = append(wsh.hostIDs, cfg.ID)
+		wsh.owners[cfg.ID] = host
+	}
+	return wsh
+}
+
+// stubCalendar serves a "work" and a "home" calendar with one event each.
+// The home calendar fails while homeFails is set.
+func stubCalendar(t *testing.T, start time.Time, homeFails *atomic.Bool) *calendar.Service {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/me/calendarList", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(calendar.CalendarList{Items: []*calendar.CalendarListEntry{
+			{Id: "work", Summary: "Work"}, {Id: "home", Summary: "Home"},
+		}})
+	})
+	event := func(summary string, at time.Time) *calendar.Event {
+		return &calendar.Event{
+			Summary: summary,
+			Start:   &calendar.EventDateTime{DateTime: at.Format(time.RFC3339)},
+			End:     &calendar.EventDateTime{DateTime: at.Add(time.Hour).Format(time.RFC3339)},
+		}
+	}
+	mux.HandleFunc("GET /calendars/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.PathValue("id") == "work":
+			json.NewEncoder(w).Encode(calendar.Events{Items: []*calendar.Event{event("Standup", start)}})
+		case homeFails.Load():
+			http.Error(w, `{"error": {"code": 404, "message": "gone"}}`, http.StatusNotFound)
+		default:
+			json.NewEncoder(w).Encode(calendar.Events{Items: []*calendar.Event{event("Dentist", start.Add(2*time.Hour))}})
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func eventNames(h *hostCalendar) map[string]bool {
+	names := make(map[string]bool)
+	for _, e := range h.Events() {
+		names[e.EventName] = true
+	}
+	return names
+}
+
+func TestHostRouting(t *testing.T) {
+	wsh := newTestHandler(t, config.HostConfig{ID: "dave", Name: "Dave"}, config.HostConfig{ID: "ada", Name: "Ada"})
+	mux := http.NewServeMux()
+	mux.Handle("GET /booking", BookingHandler(wsh))
+	mux.Handle("GET /book/{host}", BookingHandler(wsh))
+
+	tests := []struct {
+		path string
+		code int
+		name string
+	}{
+		{path: "/book/ada", code: http.StatusOK, name: "Ada"},
+		{path: "/book/dave", code: http.StatusOK, name: "Dave"},
+		{path: "/booking", code: http.StatusOK, name: "Dave"},
+		{path: "/book/bob", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.code, w.Code)
+			continue
+		}
+		if tt.name != "" && !strings.Contains(w.Body.String(), "<title>"+tt.name+" | CalDave</title>") {
+			t.Errorf("%s: expected the booking page of %s", tt.path, tt.name)
+		}
+	}
+
+	dave, ada := wsh.hosts["dave"], wsh.hosts["ada"]
+	if dave.hub == ada.hub {
+		t.Error("Expected each host to have its own hub")
+	}
+	start := time.Date(2024, 10, 11, 10, 0, 0, 0, time.UTC)
+	dave.setService(stubCalendar(t, start, &atomic.Bool{}))
+	dave.updateEvents(context.Background(), start.AddDate(0, 0, -1), start.AddDate(0, 0, 1))
+	if len(dave.Events()) != 2 || len(ada.Events()) != 0 {
+		t.Errorf("Expected only Dave's events to be loaded, got %d for Dave and %d for Ada", len(dave.Events()), len(ada.Events()))
+	}
+}
+
+func TestUpdateEventsKeepsFailedCalendars(t *testing.T) {
+	start := time.Date(2024, 10, 11, 10, 0, 0, 0, time.UTC)
+	var homeFails atomic.Bool
+	h := &hostCalendar{id: "dave"}
+	h.setService(stubCalendar(t, start, &homeFails))
+
+	h.updateEvents(context.Background(), start.AddDate(0, 0, -1), start.AddDate(0, 0, 1))
+	if got := eventNames(h); len(got) != 2 || h.syncStatus().degraded {
+		t.Fatalf("Expected the events of both calendars, got %v", got)
+	}
+
+	homeFails.Store(true)
+	h.updateEvents(context.Background(), start.AddDate(0, 0, -1), start.AddDate(0, 0, 1))
+	if got := eventNames(h); len(got) != 2 || !got["Dentist"] {
+		t.Errorf("Expected the failed calendar's events to be kept, got %v", got)
+	}
+	if sync := h.syncStatus(); !sync.degraded || !sync.reachable || sync.err == nil {
+		t.Errorf("Expected the sync to be reported as degraded, got %+v", sync)
+	}
+	if check := calendarCheck(h, time.Now(), time.Hour); !check.OK || !check.Degraded {
+		t.Errorf("Expected a degraded but ready check, got %+v", check)
+	}
+}
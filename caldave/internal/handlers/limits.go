@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"caldave/internal/store"
+	"fmt"
+	"time"
+)
+
+// minimumNotice returns the longer of the schedule's and the event type's
+// minimum notice.
+func (s ScheduleConfig) minimumNotice(et EventType) time.Duration {
+	notice := time.Duration(s.MinimumNoticeMinutes) * time.Minute
+	if et.MinimumNotice > notice {
+		notice = et.MinimumNotice
+	}
+	return notice
+}
+
+// horizon returns the first instant that is too far ahead to book, or the
+// zero time if the schedule has no limit. The last bookable day is
+// MaxDaysInFuture days after today in loc.
+func (s ScheduleConfig) horizon(now time.Time, loc *time.Location) time.Time {
+	if s.MaxDaysInFuture <= 0 {
+		return time.Time{}
+	}
+	today := now.In(loc)
+	return time.Date(today.Year(), today.Month(), today.Day()+s.MaxDaysInFuture+1, 0, 0, 0, 0, loc)
+}
+
+// checkBookingWindow reports why a meeting starting at start cannot be
+// booked at now because of the minimum notice or the booking horizon.
+func (s ScheduleConfig) checkBookingWindow(start time.Time, et EventType, now time.Time) error {
+	if notice := s.minimumNotice(et); start.Before(now.Add(notice)) {
+		return fmt.Errorf("%w: bookings need at least %s notice", errInvalidBooking, formatNotice(notice))
+	}
+	if horizon := s.horizon(now, start.Location()); !horizon.IsZero() && !start.Before(horizon) {
+		return fmt.Errorf("%w: bookings can be made at most %d days ahead", errInvalidBooking, s.MaxDaysInFuture)
+	}
+	return nil
+}
+
+// checkBookingCaps reports why nothing more can be booked with the host on
+// date: the event type's daily limit, or the host's daily or weekly limit
+// across all event types. Each group session counts once, however many
+// people joined it.
+func (h *hostCalendar) checkBookingCaps(date time.Time, et *EventType) error {
+	return h.checkCapsIn(h.activeBookings(), date, et)
+}
+
+// checkCapsIn is checkBookingCaps over bookings already read from the store,
+// which must be the host's active bookings.
+func (h *hostCalendar) checkCapsIn(bookings []store.Booking, date time.Time, et *EventType) error {
+	weekStart := startOfWeek(date)
+	weekEnd := weekStart.AddDate(0, 0, 7)
+	ofType, day, week := 0, 0, 0
+	sessions := make(map[string]bool)
+	for _, b := range bookings {
+		// The attendees of a group session share one meeting with the host.
+		if b.Host == h.id && b.Team == "" {
+			if et, ok := h.eventType(b.EventType); ok && et.group() {
+				key := b.EventType + " " + b.Start.Format(time.RFC3339)
+				if sessions[key] {
+					continue
+				}
+				sessions[key] = true
+			}
+		}
+
+		start := b.Start.In(h.location)
+		if sameDay(start, date) {
+			day++
+			if et != nil && b.Host == h.id && b.Team == "" && b.EventType == et.Slug {
+				ofType++
+			}
+		}
+		if !start.Before(weekStart) && start.Before(weekEnd) {
+			week++
+		}
+	}
+
+	if et != nil && et.MaxBookingsPerDay > 0 && ofType >= et.MaxBookingsPerDay {
+		return fmt.Errorf("%w: %s is fully booked on %s", errInvalidBooking, et.Name, date.Format("2006-01-02"))
+	}
+	if h.schedule.MaxBookingsPerDay > 0 && day >= h.schedule.MaxBookingsPerDay {
+		return fmt.Errorf("%w: %s is fully booked on %s", errInvalidBooking, h.name, date.Format("2006-01-02"))
+	}
+	if h.schedule.MaxBookingsPerWeek > 0 && week >= h.schedule.MaxBookingsPerWeek {
+		return fmt.Errorf("%w: %s is fully booked that week", errInvalidBooking, h.name)
+	}
+	return nil
+}
+
+// startOfWeek returns midnight on the Monday of date's week.
+func startOfWeek(date time.Time) time.Time {
+	offset := (int(date.Weekday()) + 6) % 7
+	return time.Date(date.Year(), date.Month(), date.Day()-offset, 0, 0, 0, 0, date.Location())
+}
+
+func formatNotice(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d >= time.Hour && d%time.Hour == 0:
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
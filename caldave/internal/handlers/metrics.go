@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"caldave/internal/metrics"
+)
+
+var (
+	websocketClients = metrics.Default.NewGauge("caldave_websocket_clients",
+		"Booking pages connected over WebSocket by host or team.", "owner")
+	websocketMessages = metrics.Default.NewCounter("caldave_websocket_messages_received_total",
+		"WebSocket messages received by type.", "type")
+	calendarSyncDuration = metrics.Default.NewHistogram("caldave_calendar_sync_duration_seconds",
+		"Time taken to fetch a host's calendar events.", []float64{.1, .25, .5, 1, 2.5, 5, 10, 30}, "host")
+	calendarSyncFailures = metrics.Default.NewCounter("caldave_calendar_sync_failures_total",
+		"Calendar syncs that failed in full or in part by host.", "host")
+	bookingsCreated = metrics.Default.NewCounter("caldave_bookings_created_total",
+		"Bookings made by host or team, event type and status.", "owner", "event_type", "status")
+)
+
+// messageTypes are the messages clients may send, so that other types are
+// counted together rather than as a series each.
+var messageTypes = map[string]bool{
+	string(RequestAvailability):      true,
+	string(RequestMonthAvailability): true,
+	string(UpdateAvailaibilty):       true,
+	string(CreateBooking):            true,
+	string(RequestChallenge):         true,
+	string(JoinWaitlist):             true,
+}
+
+func countMessage(messageType string) {
+	if !messageTypes[messageType] {
+		messageType = "other"
+	}
+	websocketMessages.Inc(messageType)
+}
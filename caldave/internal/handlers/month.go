@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type MonthAvailabilityRequest struct {
+	Month     string `json:"month"`     // Format: "2024-10"
+	EventType string `json:"eventType"` // Slug, the first event type if empty
+}
+
+// DayAvailability summarises one day of a month.
+type DayAvailability struct {
+	Date      string `json:"date"`      // Format: "2024-10-11"
+	Available bool   `json:"available"` // Whether any slot can still be booked
+	Slots     int    `json:"slots"`     // How many slots can still be booked
+}
+
+type MonthAvailabilityResponseData struct {
+	Month     string            `json:"month"`
+	EventType string            `json:"eventType"`
+	Days      []DayAvailability `json:"days"`
+}
+
+var errUnknownEventType = errors.New("unknown event type")
+
+// monthAvailability answers a month request for owner. The error is meant
+// for the client.
+func monthAvailability(owner calendarOwner, req MonthAvailabilityRequest, now time.Time) (MonthAvailabilityResponseData, error) {
+	month, err := time.ParseInLocation("2006-01", req.Month, owner.Location())
+	if err != nil {
+		return MonthAvailabilityResponseData{}, fmt.Errorf("invalid month %q", req.Month)
+	}
+	eventType, ok := owner.eventType(req.EventType)
+	if !ok {
+		return MonthAvailabilityResponseData{}, fmt.Errorf("%w %q", errUnknownEventType, req.EventType)
+	}
+	return MonthAvailabilityResponseData{
+		Month:     req.Month,
+		EventType: eventType.Slug,
+		Days:      owner.monthAvailability(month, eventType, now),
+	}, nil
+}
+
+// daysOf returns midnight of every day in month, which must be midnight on
+// the first.
+func daysOf(month time.Time) []time.Time {
+	var days []time.Time
+	for date := month; date.Month() == month.Month(); date = date.AddDate(0, 0, 1) {
+		days = append(days, date)
+	}
+	return days
+}
+
+// monthAvailability reads the events and bookings once, buckets them by day
+// and works out every day of the month from its own bucket.
+func (h *hostCalendar) monthAvailability(month time.Time, et EventType, now time.Time) []DayAvailability {
+	bookings := h.activeBookings()
+	schedule := et.schedule(h.currentSchedule())
+	buckets := newDayBuckets(h.Events(), bookings, h.location, schedule.bufferMargin())
+
+	var days []DayAvailability
+	for _, date := range daysOf(month) {
+		events := buckets.events(date, date.AddDate(0, 0, 1))
+		_, slots := h.dayAvailability(date, et, now, schedule, events, buckets.week(date))
+		days = append(days, DayAvailability{Date: date.Format("2006-01-02"), Available: len(slots) > 0, Slots: len(slots)})
+	}
+	return days
+}
+
+func (t *teamCalendar) monthAvailability(month time.Time, et EventType, now time.Time) []DayAvailability {
+	state := t.loadState(et, now, "")
+	members := make([]dayBuckets, 0, len(state.members))
+	for _, m := range state.members {
+		members = append(members, newDayBuckets(m.Events, state.bookings[m.ID], m.Location, m.Schedule.bufferMargin()))
+	}
+	team := newDayBuckets(nil, state.team, t.location, 0)
+
+	var days []DayAvailability
+	for _, date := range daysOf(month) {
+		day := teamState{
+			members:  make([]teamMember, 0, len(state.members)),
+			bookings: make(map[string][]store.Booking, len(state.members)),
+			team:     team.week(date),
+		}
+		for i, m := range state.members {
+			// Members in other time zones are looked at on every local day
+			// that overlaps date.
+			local := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, m.Location)
+			m.Events = members[i].events(local.AddDate(0, 0, -1), local.AddDate(0, 0, 2))
+			day.members = append(day.members, m)
+			day.bookings[m.ID] = members[i].week(local)
+		}
+		_, slots := t.slots(day, date, et)
+		days = append(days, DayAvailability{Date: date.Format("2006-01-02"), Available: len(slots) > 0, Slots: len(slots)})
+	}
+	return days
+}
+
+// dayBuckets holds events and bookings by the local days they touch, so
+// that a month can be worked out day by day without going through all of
+// them for every day.
+type dayBuckets struct {
+	loc       *time.Location
+	allEvents []utils.EventData
+	eventsBy  map[string][]int // Indexes into allEvents by day
+	bookings  map[string][]store.Booking
+}
+
+// newDayBuckets files every event under each day it overlaps, widened by
+// margin so that buffers reaching into the next day are seen, and every
+// booking under the day it starts on.
+func newDayBuckets(events []utils.EventData, bookings []store.Booking, loc *time.Location, margin time.Duration) dayBuckets {
+	b := dayBuckets{
+		loc:       loc,
+		allEvents: events,
+		eventsBy:  make(map[string][]int),
+		bookings:  make(map[string][]store.Booking),
+	}
+	for i, e := range events {
+		start := e.StartTime.Add(-margin).In(loc)
+		end := e.EndTime.Add(margin).In(loc)
+		for day := midnight(start); !day.After(end); day = day.AddDate(0, 0, 1) {
+			key := day.Format("2006-01-02")
+			b.eventsBy[key] = append(b.eventsBy[key], i)
+		}
+	}
+	for _, booking := range bookings {
+		key := booking.Start.In(loc).Format("2006-01-02")
+		b.bookings[key] = append(b.bookings[key], booking)
+	}
+	return b
+}
+
+// events returns the events touching the days from from up to but not
+// including to, each once.
+func (b dayBuckets) events(from, to time.Time) []utils.EventData {
+	var events []utils.EventData
+	seen := make(map[int]bool)
+	for day := midnight(from.In(b.loc)); day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, i := range b.eventsBy[day.Format("2006-01-02")] {
+			if !seen[i] {
+				seen[i] = true
+				events = append(events, b.allEvents[i])
+			}
+		}
+	}
+	return events
+}
+
+// week returns the bookings starting in the Monday to Sunday week of date,
+// which is all the daily and weekly limits need, and on the days either side
+// of it, whose buffers may reach into it.
+func (b dayBuckets) week(date time.Time) []store.Booking {
+	var bookings []store.Booking
+	start := startOfWeek(date.In(b.loc))
+	for day := start.AddDate(0, 0, -1); day.Before(start.AddDate(0, 0, 8)); day = day.AddDate(0, 0, 1) {
+		bookings = append(bookings, b.bookings[day.Format("2006-01-02")]...)
+	}
+	return bookings
+}
+
+// bufferMargin is how far the buffers around an event can reach.
+func (s ScheduleConfig) bufferMargin() time.Duration {
+	return time.Duration(max(s.BufferBeforeMinutes, s.BufferAfterMinutes)) * time.Minute
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func (c *Client) handleMonthAvailabilityRequest(message Message) {
+	reqBytes, _ := json.Marshal(message.Payload)
+	var request MonthAvailabilityRequest
+	if err := json.Unmarshal(reqBytes, &request); err != nil {
+		c.logger.WarnContext(c.ctx, "Error parsing month availability request", "error", err)
+		return
+	}
+
+	response, err := monthAvailability(c.Hub.owner, request, time.Now())
+	if err != nil {
+		c.logger.WarnContext(c.ctx, "Invalid month availability request", "error", err)
+		return
+	}
+	c.send(Message{Type: string(MonthAvailabilityResponse), Payload: response})
+}
+
+// MonthAvailabilityHandler serves GET /api/hosts/{host}/availability?month=2024-10&eventType=intro,
+// the REST equivalent of REQUEST_MONTH_AVAILABILITY.
+func MonthAvailabilityHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := wsh.lookupOwner(r.PathValue("host"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown host")
+			return
+		}
+
+		response, err := monthAvailability(owner, MonthAvailabilityRequest{
+			Month:     r.URL.Query().Get("month"),
+			EventType: r.URL.Query().Get("eventType"),
+		}, time.Now())
+		if errors.Is(err, errUnknownEventType) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMonthAvailabilityMatchesDays(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := newHostCalendar(config.HostConfig{
+		ID:       "dave",
+		TimeZone: "Europe/London",
+		Schedule: config.Schedule{
+			Weekdays: map[string]config.Hours{
+				"monday":    {Start: "09:00", End: "17:00"},
+				"wednesday": {Start: "09:00", End: "12:00"},
+			},
+			Default:           config.Hours{Start: "00:00", End: "00:00"},
+			MaxBookingsPerDay: 1,
+			Overrides:         []config.Override{{Date: "2024-11-18"}},
+		},
+	}, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.events = []utils.EventData{
+		{StartTime: at(london, "09:00"), EndTime: at(london, "11:00")}, // Wednesday the 13th
+	}
+	if _, err := st.AddBooking(store.Booking{
+		Host:   "dave",
+		Start:  time.Date(2024, 11, 25, 10, 0, 0, 0, london),
+		End:    time.Date(2024, 11, 25, 11, 0, 0, 0, london),
+		Status: store.StatusConfirmed,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	et := EventType{Slug: "call", Duration: time.Hour}
+	now := time.Date(2024, 11, 1, 0, 0, 0, 0, london)
+	month := time.Date(2024, 11, 1, 0, 0, 0, 0, london)
+
+	days := host.monthAvailability(month, et, now)
+	if len(days) != 30 {
+		t.Fatalf("got %d days, want 30", len(days))
+	}
+	for _, day := range days {
+		date, _ := time.ParseInLocation("2006-01-02", day.Date, london)
+		_, slots := host.availability(date, et, now)
+		if day.Slots != len(slots) || day.Available != (len(slots) > 0) {
+			t.Errorf("%s: month says %d slots, day says %d", day.Date, day.Slots, len(slots))
+		}
+	}
+
+	expected := map[string]int{
+		"2024-11-11": 8, // Monday
+		"2024-11-13": 1, // Wednesday with a meeting until 11:00
+		"2024-11-14": 0, // Closed on Thursdays
+		"2024-11-18": 0, // Closed by an override
+		"2024-11-25": 0, // Daily limit reached
+	}
+	for _, day := range days {
+		if want, ok := expected[day.Date]; ok && day.Slots != want {
+			t.Errorf("%s: got %d slots, want %d", day.Date, day.Slots, want)
+		}
+	}
+}
+
+func TestTeamMonthAvailabilityMatchesDays(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	newYork := mustLoad(t, "America/New_York")
+	st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := make(map[string]*hostCalendar)
+	for _, cfg := range []config.HostConfig{
+		{ID: "dave", TimeZone: "Europe/London", Schedule: config.Schedule{
+			Default:            config.Hours{Start: "09:00", End: "17:00"},
+			BufferMinutes:      30,
+			MaxBookingsPerWeek: 2,
+		}},
+		{ID: "ada", TimeZone: "America/New_York", Schedule: config.Schedule{
+			Default: config.Hours{Start: "06:00", End: "12:00"},
+		}},
+	} {
+		host, err := newHostCalendar(cfg, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[cfg.ID] = host
+	}
+	hosts["dave"].events = []utils.EventData{
+		{StartTime: at(london, "14:00"), EndTime: at(london, "15:00")},
+		{StartTime: time.Date(2024, 11, 20, 23, 0, 0, 0, london), EndTime: time.Date(2024, 11, 22, 9, 30, 0, 0, london)},
+	}
+	hosts["ada"].events = []utils.EventData{
+		{StartTime: time.Date(2024, 11, 6, 10, 0, 0, 0, newYork), EndTime: time.Date(2024, 11, 6, 11, 0, 0, 0, newYork)},
+	}
+	for _, day := range []int{25, 26} {
+		if _, err := st.AddBooking(store.Booking{
+			Host:   "dave",
+			Start:  time.Date(2024, 11, day, 10, 0, 0, 0, london),
+			End:    time.Date(2024, 11, day, 11, 0, 0, 0, london),
+			Status: store.StatusConfirmed,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mode := range []string{config.TeamCollective, config.TeamRoundRobin} {
+		team, err := newTeamCalendar(config.TeamConfig{
+			ID:         "panel",
+			Mode:       mode,
+			Members:    []string{"dave", "ada"},
+			TimeZone:   "Europe/London",
+			EventTypes: []config.EventTypeConfig{{Slug: "call", DurationMinutes: 60}},
+		}, hosts, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		et, _ := team.eventType("call")
+		now := time.Date(2024, 11, 1, 0, 0, 0, 0, london)
+
+		for _, day := range team.monthAvailability(time.Date(2024, 11, 1, 0, 0, 0, 0, london), et, now) {
+			date, _ := time.ParseInLocation("2006-01-02", day.Date, london)
+			_, slots := team.availability(date, et, now)
+			if day.Slots != len(slots) {
+				t.Errorf("%s %s: month says %d slots, day says %d", mode, day.Date, day.Slots, len(slots))
+			}
+		}
+	}
+}
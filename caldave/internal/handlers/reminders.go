@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"caldave/internal/store"
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Job kinds run by the scheduler. Jobs refer to their booking by ID.
+const (
+	jobHoldExpiry  = "booking.expire"       // Expires a pending booking nobody approved
+	jobReminder24h = "booking.reminder.24h" // Reminds the booker the day before
+	jobReminder1h  = "booking.reminder.1h"
+	jobFollowUp    = "booking.followup" // Thanks the booker once the booking is over
+)
+
+// followUpDelay is how long after a booking ends the follow up is sent.
+const followUpDelay = time.Hour
+
+var bookingJobKinds = []string{jobHoldExpiry, jobReminder24h, jobReminder1h, jobFollowUp}
+
+// reminders are sent this long before a confirmed booking starts, longest
+// lead first.
+var reminders = []struct {
+	kind string
+	lead time.Duration
+}{
+	{jobReminder24h, 24 * time.Hour},
+	{jobReminder1h, time.Hour},
+}
+
+// registerJobs sets up the scheduler to run booking jobs, and keeps them in
+// step with what happens to each booking.
+func (wsh *WebSocketHandler) registerJobs() {
+	wsh.scheduler.Handle(jobHoldExpiry, func(ctx context.Context, j store.Job) error {
+		return wsh.expireBooking(ctx, j.Ref)
+	})
+	for i, r := range reminders {
+		// A reminder that runs late is skipped once the next one is due.
+		skipWithin := time.Duration(0)
+		if i+1 < len(reminders) {
+			skipWithin = reminders[i+1].lead
+		}
+		wsh.scheduler.Handle(r.kind, func(ctx context.Context, j store.Job) error {
+			return wsh.remind(ctx, j.Ref, skipWithin)
+		})
+	}
+	wsh.scheduler.Handle(jobFollowUp, func(ctx context.Context, j store.Job) error {
+		return wsh.followUp(ctx, j.Ref)
+	})
+	wsh.OnBookingEvent(func(e BookingEvent) {
+		if err := wsh.scheduleBookingJobs(e.Booking); err != nil {
+			slog.Error("Error scheduling booking jobs", "booking", e.Booking.ID, "event", e.Type, "error", err)
+		}
+	})
+}
+
+// scheduleBookingJobs schedules the jobs b needs now, replacing any it had
+// before and cancelling the rest. Pending bookings only need to expire;
+// confirmed ones get their reminders and follow up.
+func (wsh *WebSocketHandler) scheduleBookingJobs(b store.Booking) error {
+	now := time.Now()
+	var keep []string
+	switch b.Status {
+	case store.StatusPending:
+		if !b.ExpiresAt.IsZero() {
+			if _, err := wsh.scheduler.Schedule(jobHoldExpiry, b.ID, b.ExpiresAt, nil); err != nil {
+				return err
+			}
+			keep = append(keep, jobHoldExpiry)
+		}
+	case store.StatusConfirmed:
+		for _, r := range reminders {
+			if at := b.Start.Add(-r.lead); at.After(now) {
+				if _, err := wsh.scheduler.Schedule(r.kind, b.ID, at, nil); err != nil {
+					return err
+				}
+				keep = append(keep, r.kind)
+			}
+		}
+		if _, err := wsh.scheduler.Schedule(jobFollowUp, b.ID, b.End.Add(followUpDelay), nil); err != nil {
+			return err
+		}
+		keep = append(keep, jobFollowUp)
+	}
+
+	var cancel []string
+	for _, kind := range bookingJobKinds {
+		if !slices.Contains(keep, kind) {
+			cancel = append(cancel, kind)
+		}
+	}
+	if len(cancel) == 0 {
+		return nil
+	}
+	return wsh.scheduler.Cancel(b.ID, cancel...)
+}
+
+// scheduleMissingJobs schedules jobs for the upcoming bookings that have
+// none, such as those made before the scheduler existed.
+func (wsh *WebSocketHandler) scheduleMissingJobs(ctx context.Context) {
+	scheduled := make(map[string]bool)
+	for _, j := range wsh.store.Jobs(nil) {
+		scheduled[j.Ref] = true
+	}
+	now := time.Now()
+	for _, b := range wsh.store.Bookings(func(b store.Booking) bool {
+		return !scheduled[b.ID] && (b.Status == store.StatusPending || b.Status == store.StatusConfirmed) && b.End.After(now)
+	}) {
+		if err := wsh.scheduleBookingJobs(b); err != nil {
+			slog.ErrorContext(ctx, "Error scheduling booking jobs", "booking", b.ID, "error", err)
+		}
+	}
+}
+
+// remind emails the booker that their booking is coming up, unless it is no
+// longer confirmed or starts within skipWithin.
+func (wsh *WebSocketHandler) remind(ctx context.Context, id string, skipWithin time.Duration) error {
+	b, ok := wsh.confirmedBooking(id)
+	if !ok || time.Until(b.Start) <= skipWithin {
+		return nil
+	}
+	return wsh.notifier.Notify(ctx, wsh.bookerMessage(b, "reminder"))
+}
+
+// followUp thanks the booker after the booking and links to the booking
+// page so they can meet again.
+func (wsh *WebSocketHandler) followUp(ctx context.Context, id string) error {
+	b, ok := wsh.confirmedBooking(id)
+	if !ok || time.Now().Before(b.End) {
+		return nil
+	}
+	page := b.Host
+	if b.Team != "" {
+		page = b.Team
+	}
+	return wsh.notifier.Notify(ctx, wsh.bookerMessage(b, "followUp", wsh.baseURL+"/book/"+page))
+}
+
+// confirmedBooking returns the booking with the given ID if it is still
+// confirmed.
+func (wsh *WebSocketHandler) confirmedBooking(id string) (store.Booking, bool) {
+	b, err := wsh.store.Booking(id)
+	if err != nil || b.Status != store.StatusConfirmed {
+		return store.Booking{}, false
+	}
+	return b, true
+}
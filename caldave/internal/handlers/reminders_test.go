@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/jobs"
+	"caldave/internal/notify"
+	"caldave/internal/store"
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBookingJobs(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh := &WebSocketHandler{store: st, notifier: notify.LogNotifier{}, scheduler: jobs.New(st)}
+	wsh.registerJobs()
+	ctx := context.Background()
+
+	pendingKinds := func(id string) []string {
+		var kinds []string
+		for _, j := range st.Jobs(func(j store.Job) bool { return j.Ref == id && j.Status == store.JobPending }) {
+			kinds = append(kinds, j.Kind)
+		}
+		slices.Sort(kinds)
+		return kinds
+	}
+
+	now := time.Now()
+	b, err := st.AddBooking(store.Booking{
+		Host:      "dave",
+		Start:     now.Add(3 * time.Hour),
+		End:       now.Add(4 * time.Hour),
+		Status:    store.StatusPending,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh.bookingCreated(ctx, b)
+	if got := pendingKinds(b.ID); !slices.Equal(got, []string{jobHoldExpiry}) {
+		t.Errorf("Expected a pending booking to only expire, got %v", got)
+	}
+
+	// Approving it swaps the expiry for the reminders that are still ahead.
+	if _, err := wsh.approveBooking(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := pendingKinds(b.ID); !slices.Equal(got, []string{jobFollowUp, jobReminder1h}) {
+		t.Errorf("Expected the 1h reminder and follow up, got %v", got)
+	}
+
+	if _, err := wsh.cancelBooking(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := pendingKinds(b.ID); len(got) != 0 {
+		t.Errorf("Expected a cancelled booking to have no jobs, got %v", got)
+	}
+
+	// Expiring a pending booking through its job releases it.
+	expiring, err := st.AddBooking(store.Booking{
+		Host:      "dave",
+		Start:     now.Add(3 * time.Hour),
+		End:       now.Add(4 * time.Hour),
+		Status:    store.StatusPending,
+		ExpiresAt: now.Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh.scheduleMissingJobs(ctx)
+	if err := wsh.expireBooking(ctx, expiring.ID); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := st.Booking(expiring.ID); b.Status != store.StatusExpired {
+		t.Errorf("Expected the booking to expire, got %s", b.Status)
+	}
+	if got := pendingKinds(expiring.ID); len(got) != 0 {
+		t.Errorf("Expected an expired booking to have no jobs, got %v", got)
+	}
+}
+
+func TestBookerMessageLocale(t *testing.T) {
+	host, err := newHostCalendar(config.HostConfig{
+		ID:         "dave",
+		Name:       "Dave",
+		TimeZone:   "Europe/Berlin",
+		EventTypes: []config.EventTypeConfig{{Slug: "call", Name: "Call", DurationMinutes: 30}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh := &WebSocketHandler{owners: map[string]calendarOwner{"dave": host}}
+	b := store.Booking{
+		Host:      "dave",
+		EventType: "call",
+		Name:      "Ada",
+		Email:     "ada@example.com",
+		Start:     time.Date(2024, time.October, 11, 12, 0, 0, 0, time.UTC),
+		End:       time.Date(2024, time.October, 11, 12, 30, 0, 0, time.UTC),
+		Locale:    "de",
+	}
+
+	m := wsh.bookerMessage(b, "confirmed")
+	if m.Subject != "Ihre Buchung ist bestätigt" {
+		t.Errorf("Unexpected subject %q", m.Subject)
+	}
+	want := "Hallo Ada,\n\nIhre Buchung wurde bestätigt.\n\nCall mit Dave\nFr, 11. Okt. 2024, 14:00 CEST bis Fr, 11. Okt. 2024, 14:30 CEST\n"
+	if m.Body != want {
+		t.Errorf("Unexpected body:\n%s", m.Body)
+	}
+
+	b.Locale = ""
+	if m := wsh.bookerMessage(b, "confirmed"); m.Subject != "Your booking is confirmed" {
+		t.Errorf("Expected bookings without a locale in English, got %q", m.Subject)
+	}
+}
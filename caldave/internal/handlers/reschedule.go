@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"caldave/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RescheduleRequest moves a booking to another time.
+type RescheduleRequest struct {
+	Date  string `json:"date"`  // Format: "2024-10-11"
+	Start string `json:"start"` // Format: "HH:MM"
+}
+
+// rescheduleBooking moves the booking to the requested time if it is free,
+// keeping its host and event type.
+func (wsh *WebSocketHandler) rescheduleBooking(ctx context.Context, id string, req RescheduleRequest, now time.Time) (store.Booking, error) {
+	b, err := wsh.store.Booking(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Booking{}, errBookingNotFound
+	}
+	if err != nil {
+		return store.Booking{}, err
+	}
+	if !b.Active() {
+		return store.Booking{}, fmt.Errorf("%w: it is %s", errBookingStatus, b.Status)
+	}
+
+	owner, ok := wsh.bookingOwner(b)
+	if !ok {
+		return store.Booking{}, fmt.Errorf("%w: %s no longer exists", errBookingStatus, b.Host)
+	}
+	et, ok := owner.eventType(b.EventType)
+	if !ok {
+		return store.Booking{}, fmt.Errorf("%w: event type %q no longer exists", errBookingStatus, b.EventType)
+	}
+	date, err := time.ParseInLocation("2006-01-02", req.Date, owner.Location())
+	if err != nil {
+		return store.Booking{}, fmt.Errorf("%w: invalid date %q", errInvalidBooking, req.Date)
+	}
+	start, err := time.ParseInLocation("2006-01-02 15:04", req.Date+" "+req.Start, owner.Location())
+	if err != nil {
+		return store.Booking{}, fmt.Errorf("%w: invalid start time %q", errInvalidBooking, req.Start)
+	}
+
+	moved, err := owner.reschedule(b, et, date, start, now)
+	if err != nil {
+		return store.Booking{}, err
+	}
+	wsh.bookingReleased(moved)
+	wsh.emit(BookingRescheduledEvent, moved, &b)
+	wsh.notifyBooker(ctx, moved, "moved")
+	return moved, nil
+}
+
+func (h *hostCalendar) reschedule(b store.Booking, et EventType, date, start, now time.Time) (store.Booking, error) {
+	if err := h.schedule.checkBookingWindow(start, et, now); err != nil {
+		return store.Booking{}, err
+	}
+
+	h.bookingMutex.Lock()
+	defer h.bookingMutex.Unlock()
+
+	bookings := withoutBooking(h.activeBookings(), b.ID)
+	_, slots := h.dayAvailability(date, et, now, et.schedule(h.currentSchedule()), h.Events(), bookings)
+	if !containsSlot(slots, start.Format("15:04")) {
+		return store.Booking{}, errSlotUnavailable
+	}
+	return moveBooking(h.store, b.ID, start, start.Add(et.Duration))
+}
+
+// reschedule moves a team booking. The time has to suit whoever the booking
+// was assigned to; it is not handed to another member.
+func (t *teamCalendar) reschedule(b store.Booking, et EventType, date, start, now time.Time) (store.Booking, error) {
+	if err := (ScheduleConfig{}).checkBookingWindow(start, et, now); err != nil {
+		return store.Booking{}, err
+	}
+
+	defer t.lock()()
+
+	_, slots := t.slots(t.loadState(et, now, b.ID), date, et)
+	i := slices.IndexFunc(slots, func(s teamSlot) bool { return s.Start.Equal(start) })
+	if i < 0 || !slices.Contains(slots[i].free, b.Host) {
+		return store.Booking{}, errSlotUnavailable
+	}
+	return moveBooking(t.store, b.ID, start, start.Add(et.Duration))
+}
+
+// moveBooking saves the new times of a booking that is still active.
+func moveBooking(st *store.Store, id string, start, end time.Time) (store.Booking, error) {
+	return st.UpdateBooking(id, func(b *store.Booking) error {
+		if !b.Active() {
+			return fmt.Errorf("%w: it is %s", errBookingStatus, b.Status)
+		}
+		b.Start, b.End = start, end
+		return nil
+	})
+}
+
+// withoutBooking returns bookings without the one with ID id.
+func withoutBooking(bookings []store.Booking, id string) []store.Booking {
+	if id == "" {
+		return bookings
+	}
+	return slices.DeleteFunc(bookings, func(b store.Booking) bool { return b.ID == id })
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"caldave/internal/ratelimit"
+	"caldave/internal/store"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionTTL     = 10 * time.Minute // How long a disconnected session can be resumed
+	maxUndelivered = 20
+)
+
+// SessionData is sent first on every connection. The client reconnects with
+// the token to resume its session.
+type SessionData struct {
+	Token   string `json:"token"`
+	Resumed bool   `json:"resumed"`
+}
+
+// clientSession is what a booking page keeps across reconnects: its rate
+// limit, and the booking results it has not received yet.
+type clientSession struct {
+	token       string
+	owner       string
+	limiter     *ratelimit.Bucket
+	undelivered []Message
+	connected   bool
+	lastSeen    time.Time
+}
+
+// clientSessions keeps the sessions of the booking pages by token.
+type clientSessions struct {
+	mutex   sync.Mutex
+	byToken map[string]*clientSession
+}
+
+// attach resumes the session with token on owner's page, if there is one
+// nobody is connected to that has not expired. Otherwise it starts a new
+// one. It returns the messages the session missed while disconnected.
+func (s *clientSessions) attach(token, owner string, now time.Time) (*clientSession, []Message, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for t, sess := range s.byToken {
+		if !sess.connected && now.Sub(sess.lastSeen) > sessionTTL {
+			delete(s.byToken, t)
+		}
+	}
+
+	if sess, ok := s.byToken[token]; ok && token != "" && sess.owner == owner && !sess.connected {
+		missed := sess.undelivered
+		sess.undelivered = nil
+		sess.connected = true
+		return sess, missed, true, nil
+	}
+
+	token, err := store.NewID()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	sess := &clientSession{
+		token:     token,
+		owner:     owner,
+		limiter:   ratelimit.NewBucket(connectionRate, connectionBurst),
+		connected: true,
+	}
+	if s.byToken == nil {
+		s.byToken = make(map[string]*clientSession)
+	}
+	s.byToken[token] = sess
+	return sess, nil, false, nil
+}
+
+// detach marks sess disconnected, so it can be resumed until it expires.
+func (s *clientSessions) detach(sess *clientSession, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sess.connected = false
+	sess.lastSeen = now
+}
+
+// keep saves m for delivery when the session is resumed, if it is the result
+// of a booking the client would otherwise never learn about.
+func (s *clientSessions) keep(sess *clientSession, m Message) {
+	switch MessageType(m.Type) {
+	case BookingCreated, BookingFailed, WaitlistJoined:
+	default:
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(sess.undelivered) < maxUndelivered {
+		sess.undelivered = append(sess.undelivered, m)
+	}
+}
+
+// keepUnsent saves the resumable messages still queued for c once it has
+// disconnected.
+func (c *Client) keepUnsent() {
+	for {
+		select {
+		case m, ok := <-c.Send:
+			if !ok {
+				return
+			}
+			c.handler.sessions.keep(c.session, m)
+		default:
+			return
+		}
+	}
+}
+
+// AdvertiseWebSocketURL has booking pages open their WebSocket at the base
+// URL rather than at the address they were loaded from. Only call it when the
+// base URL was configured, as the default is only right on localhost.
+func (wsh *WebSocketHandler) AdvertiseWebSocketURL() {
+	wsh.advertiseWS = true
+}
+
+// webSocketURL is the public address of owner's booking page WebSocket, at
+// the scheme and host of the base URL under the site's base path. It is empty
+// if that is not advertised or not absolute, leaving the page to use its own
+// address. A page served over TLS always gets wss, as browsers block plain
+// WebSockets from it.
+func (wsh *WebSocketHandler) webSocketURL(owner string, secure bool) string {
+	if !wsh.advertiseWS {
+		return ""
+	}
+	u, err := url.Parse(wsh.baseURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if secure || strings.EqualFold(u.Scheme, "https") {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = wsh.site.Path("/ws/" + owner)
+	return u.String()
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClientSessions(t *testing.T) {
+	var sessions clientSessions
+	now := time.Now()
+
+	first, missed, resumed, err := sessions.attach("", "dave", now)
+	if err != nil || resumed || len(missed) != 0 {
+		t.Fatalf("Expected a new session, got %v, %v and %v", missed, resumed, err)
+	}
+	if s, _, resumed, _ := sessions.attach(first.token, "dave", now); resumed || s == first {
+		t.Error("Expected a session in use not to be resumed by a second page")
+	}
+
+	sessions.keep(first, Message{Type: string(EventUpdated)})
+	sessions.keep(first, Message{Type: string(BookingCreated)})
+	sessions.detach(first, now)
+
+	if s, _, resumed, _ := sessions.attach(first.token, "alice", now); resumed || s == first {
+		t.Error("Expected a session not to move to another booking page")
+	}
+	s, missed, resumed, err := sessions.attach(first.token, "dave", now.Add(time.Minute))
+	if err != nil || !resumed || s != first {
+		t.Fatalf("Expected the session to be resumed, got %v and %v", resumed, err)
+	}
+	if len(missed) != 1 || missed[0].Type != string(BookingCreated) {
+		t.Errorf("Expected the booking result to be delivered, got %v", missed)
+	}
+
+	sessions.detach(first, now)
+	if _, _, resumed, _ := sessions.attach(first.token, "dave", now.Add(sessionTTL+time.Minute)); resumed {
+		t.Error("Expected an expired session to start afresh")
+	}
+}
+
+func TestWebSocketURL(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		basePath string
+		secure   bool
+		expected string
+	}{
+		{baseURL: "http://localhost:8080", expected: "ws://localhost:8080/ws/dave"},
+		{baseURL: "https://cal.example.com", expected: "wss://cal.example.com/ws/dave"},
+		{baseURL: "https://example.com/caldave", basePath: "/caldave", expected: "wss://example.com/caldave/ws/dave"},
+		{baseURL: "https://example.com/", basePath: "/cal", expected: "wss://example.com/cal/ws/dave"},
+		{baseURL: "http://localhost:8443", secure: true, expected: "wss://localhost:8443/ws/dave"},
+		{baseURL: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseURL, func(t *testing.T) {
+			wsh := &WebSocketHandler{baseURL: tt.baseURL, advertiseWS: true, site: Site{BasePath: tt.basePath}}
+			if got := wsh.webSocketURL("dave", tt.secure); got != tt.expected {
+				t.Errorf("webSocketURL() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWebSocketURLDefaultConfig(t *testing.T) {
+	t.Setenv("BASE_URL", "")
+	os.Unsetenv("BASE_URL")
+	cfg := config.NewConfig()
+	if cfg.BaseURLSet {
+		t.Fatal("Expected BASE_URL not to be set")
+	}
+
+	wsh := &WebSocketHandler{baseURL: cfg.BaseURL}
+	if cfg.BaseURLSet {
+		wsh.AdvertiseWebSocketURL()
+	}
+	if got := wsh.webSocketURL("dave", false); got != "" {
+		t.Errorf("Expected the page to use its own address by default, got %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+type ShutdownData struct {
+	ReconnectAfter int `json:"reconnectAfter"` // Seconds to wait before reconnecting
+}
+
+// shutdownMessage spreads reconnects over a few seconds, so a restart is not
+// met by every client at once.
+func shutdownMessage() Message {
+	return Message{Type: string(ServerShutdown), Payload: ShutdownData{ReconnectAfter: 2 + rand.IntN(9)}}
+}
+
+var errShuttingDown = errors.New("the server is restarting, please try again in a moment")
+
+// writes tracks the bookings being made over WebSockets, so shutdown can wait
+// for them to be saved. Once it is draining no new ones are started.
+type writes struct {
+	mutex    sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+}
+
+// begin reports whether a write may start. Every write that may must call
+// end when it is done.
+func (w *writes) begin() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.draining {
+		return false
+	}
+	w.wg.Add(1)
+	return true
+}
+
+func (w *writes) end() {
+	w.wg.Done()
+}
+
+// drain stops new writes and waits for the ones in flight.
+func (w *writes) drain() {
+	w.mutex.Lock()
+	w.draining = true
+	w.mutex.Unlock()
+	w.wg.Wait()
+}
+
+// shutdown tells every client the server is going away and disconnects them
+// once the messages already queued for them are sent. It must be called with
+// the mutex held.
+func (h *Hub) shutdown() {
+	for id, client := range h.Clients {
+		select {
+		case client.Send <- shutdownMessage():
+		default:
+		}
+		close(client.quit)
+		delete(h.Clients, id)
+	}
+	h.countClients()
+}
+
+// broadcast sends message to every client, unless the hub has stopped.
+func (h *Hub) broadcast(message Message) {
+	select {
+	case h.Broadcast <- message:
+	case <-h.done:
+	}
+}
+
+// refuse tells a client that connected while the server was shutting down
+// to come back later.
+func refuse(ws *websocket.Conn) {
+	websocket.JSON.Send(ws, shutdownMessage())
+}
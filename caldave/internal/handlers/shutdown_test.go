@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/jobs"
+	"caldave/internal/notify"
+	"caldave/internal/store"
+	"caldave/internal/webhook"
+	"caldave/internal/webhook/webhooktest"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// newShutdownHandler serves one host with nothing else running.
+func newShutdownHandler(t *testing.T) *WebSocketHandler {
+	t.Helper()
+	st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := newHostCalendar(config.HostConfig{
+		ID:         "dave",
+		TimeZone:   "Europe/London",
+		Schedule:   config.Schedule{Default: config.Hours{Start: "09:00", End: "17:00"}},
+		EventTypes: []config.EventTypeConfig{{Slug: "call", Name: "Call", DurationMinutes: 30}},
+	}, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WebSocketHandler{
+		hosts:        map[string]*hostCalendar{"dave": host},
+		owners:       map[string]calendarOwner{"dave": host},
+		store:        st,
+		notifier:     notify.LogNotifier{},
+		scheduler:    jobs.New(st),
+		waitlistWake: make(chan struct{}, 1),
+	}
+}
+
+func TestShutdownDisconnectsClients(t *testing.T) {
+	wsh := newShutdownHandler(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		wsh.Run(ctx)
+		close(stopped)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws/{host}", wsh.Handler(Protection{}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/dave"
+
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// A reply means the client has joined the hub.
+	var reply Message
+	if err := websocket.JSON.Receive(ws, &reply); err != nil || reply.Type != string(SessionStarted) {
+		t.Fatalf("Expected a session, got %+v and %v", reply, err)
+	}
+	if err := websocket.JSON.Send(ws, Message{Type: string(RequestChallenge)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := websocket.JSON.Receive(ws, &reply); err != nil || reply.Type != string(ChallengeResponse) {
+		t.Fatalf("Expected a challenge, got %+v and %v", reply, err)
+	}
+
+	cancel()
+	var bye struct {
+		Type    string       `json:"type"`
+		Payload ShutdownData `json:"payload"`
+	}
+	if err := websocket.JSON.Receive(ws, &bye); err != nil || bye.Type != string(ServerShutdown) {
+		t.Fatalf("Expected a shutdown message, got %+v and %v", bye, err)
+	}
+	if bye.Payload.ReconnectAfter <= 0 {
+		t.Errorf("Expected a reconnect hint, got %d", bye.Payload.ReconnectAfter)
+	}
+	if err := websocket.JSON.Receive(ws, &reply); err == nil {
+		t.Errorf("Expected the connection to be closed, got %+v", reply)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return")
+	}
+
+	// Clients connecting after the hub stopped are told to come back later.
+	late, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	late.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.JSON.Receive(late, &bye); err != nil || bye.Type != string(ServerShutdown) {
+		t.Errorf("Expected a late client to be turned away, got %+v and %v", bye, err)
+	}
+	if wsh.writes.begin() {
+		t.Error("Expected no bookings to start after shutdown")
+	}
+}
+
+func TestShutdownDeliversWebhooks(t *testing.T) {
+	wsh := newShutdownHandler(t)
+	receiver := webhooktest.NewReceiver("secret")
+	defer receiver.Close()
+	dispatcher := webhook.NewDispatcher([]config.WebhookConfig{{ID: "crm", URL: receiver.URL, Secret: "secret"}})
+	wsh.OnBookingEvent(func(e BookingEvent) {
+		dispatcher.Publish(e.Type, e.Hosts(), e)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		wsh.Run(ctx)
+		close(stopped)
+	}()
+
+	// A booking accepted just before shutdown is saved while Run drains.
+	if !wsh.writes.begin() {
+		t.Fatal("Expected a booking to start")
+	}
+	cancel()
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	b, err := wsh.store.AddBooking(store.Booking{Host: "dave", EventType: "call", Name: "Ada",
+		Start: start, End: start.Add(30 * time.Minute), Status: store.StatusConfirmed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh.bookingCreated(context.Background(), b)
+	wsh.writes.end()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return")
+	}
+	// As the server does once Run has returned.
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if n := dispatcher.Shutdown(shutdownCtx); n != 0 {
+		t.Errorf("Expected no deliveries to be abandoned, got %d", n)
+	}
+	if requests := receiver.Requests(); len(requests) != 1 || requests[0].Event != BookingCreatedEvent {
+		t.Errorf("Expected the booking's webhook to be delivered, got %+v", requests)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"caldave/internal/assets"
+	"html/template"
+	"io"
+)
+
+// Site is where the pages and their static files are served from.
+type Site struct {
+	BasePath string // Prefix of every path behind a proxy, such as "/caldave", empty at the root
+	Assets   *assets.Assets
+}
+
+// Path returns the public path of p, a path from the root of the site.
+func (s Site) Path(p string) string {
+	return s.BasePath + p
+}
+
+// Asset returns the public URL of a file in the static directory. It
+// changes with the file's content, so browsers can cache it until then.
+func (s Site) Asset(name string) string {
+	if s.Assets == nil {
+		return s.Path("/static/" + name)
+	}
+	return s.Path("/static/" + s.Assets.Path(name))
+}
+
+// templates parses the pages, with functions that link to pages and static
+// files wherever s is mounted.
+func (s Site) templates() (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
+		"base":  func() string { return s.BasePath },
+		"asset": s.Asset,
+	}).ParseFS(templateFiles, "*.html")
+}
+
+// render executes the page template name with data. The pages are parsed
+// for the handler's site on first use.
+func (wsh *WebSocketHandler) render(w io.Writer, name string, data any) error {
+	wsh.pagesOnce.Do(func() {
+		wsh.pages, wsh.pagesErr = wsh.site.templates()
+	})
+	if wsh.pagesErr != nil {
+		return wsh.pagesErr
+	}
+	return wsh.pages.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"caldave/internal/availability"
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// teamCalendar books a group of hosts through one page. Round robin teams
+// offer any time at least one member is free and assign the booking to one
+// of them; collective teams only offer times when every member is free.
+type teamCalendar struct {
+	id         string
+	name       string
+	mode       string
+	location   *time.Location
+	members    []*hostCalendar
+	eventTypes []EventType
+	hub        *Hub
+	store      *store.Store
+
+	bookingMutex sync.Mutex
+}
+
+func newTeamCalendar(cfg config.TeamConfig, hosts map[string]*hostCalendar, st *store.Store) (*teamCalendar, error) {
+	team := &teamCalendar{
+		id:         cfg.ID,
+		name:       cfg.Name,
+		mode:       cfg.Mode,
+		location:   time.Local,
+		eventTypes: eventTypesFromConfig(cfg.EventTypes),
+		store:      st,
+	}
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("team %s: %w", cfg.ID, err)
+		}
+		team.location = loc
+	}
+	for _, id := range cfg.Members {
+		host, ok := hosts[id]
+		if !ok {
+			return nil, fmt.Errorf("team %s: unknown member %s", cfg.ID, id)
+		}
+		team.members = append(team.members, host)
+	}
+	team.hub = NewHub(team)
+	return team, nil
+}
+
+func (t *teamCalendar) ID() string               { return t.id }
+func (t *teamCalendar) Name() string             { return t.name }
+func (t *teamCalendar) Location() *time.Location { return t.location }
+func (t *teamCalendar) Hub() *Hub                { return t.hub }
+
+func (t *teamCalendar) eventType(slug string) (EventType, bool) {
+	return findEventType(t.eventTypes, slug)
+}
+
+func (t *teamCalendar) eventTypeViews() []eventTypeView {
+	return eventTypeViews(t.eventTypes)
+}
+
+func (t *teamCalendar) updateEvents(ctx context.Context, start, end time.Time) {
+	for _, member := range t.members {
+		member.updateEvents(ctx, start, end)
+	}
+}
+
+// teamMember is one host's input to a team availability calculation.
+type teamMember struct {
+	ID       string
+	Location *time.Location
+	Events   []utils.EventData
+	Schedule ScheduleConfig
+	Earliest time.Time // First start the member accepts, from their minimum notice
+	Horizon  time.Time // First start too far ahead for the member, zero for no limit
+}
+
+// accepts reports whether a meeting starting at start respects the member's
+// notice and horizon.
+func (m teamMember) accepts(start time.Time) bool {
+	return !start.Before(m.Earliest) && (m.Horizon.IsZero() || start.Before(m.Horizon))
+}
+
+// teamSlot is a bookable slot and the members free for all of it.
+type teamSlot struct {
+	availability.Interval
+	free []string
+}
+
+// getTeamAvailableTimesForDate generalises getAvailableTimesForDate to
+// several hosts. It returns the team's free time on date, which is the union
+// of the members' free time for round robin teams and the intersection for
+// collective teams, along with each member's own free time. Members may live
+// in other time zones, so their schedules are evaluated on every local day
+// that overlaps date.
+func getTeamAvailableTimesForDate(date time.Time, members []teamMember, mode string) (availability.Set, map[string]availability.Set) {
+	day := availability.Day(date)
+
+	perMember := make(map[string]availability.Set, len(members))
+	var team availability.Set
+	for i, m := range members {
+		var free availability.Set
+		for offset := -1; offset <= 1; offset++ {
+			local := time.Date(date.Year(), date.Month(), date.Day()+offset, 0, 0, 0, 0, m.Location)
+			free = free.Union(freeTime(local, m.Events, m.Schedule))
+		}
+		free = free.Clamp(day).In(date.Location())
+		perMember[m.ID] = free
+
+		switch {
+		case i == 0:
+			team = free
+		case mode == config.TeamCollective:
+			team = team.Intersect(free)
+		default:
+			team = team.Union(free)
+		}
+	}
+	return team, perMember
+}
+
+// teamSlots cuts the team's free time into bookable slots. Round robin slots
+// come from each member's own free time so that somebody can take the whole
+// slot; collective slots come from the shared free time and must suit every
+// member's notice and horizon.
+func teamSlots(members []teamMember, mode string, team availability.Set, perMember map[string]availability.Set, length time.Duration) []teamSlot {
+	if mode == config.TeamCollective {
+		ids := make([]string, 0, len(members))
+		for _, m := range members {
+			ids = append(ids, m.ID)
+		}
+		var slots []teamSlot
+		for _, r := range team.Split(length) {
+			if slices.ContainsFunc(members, func(m teamMember) bool { return !m.accepts(r.Start) }) {
+				continue
+			}
+			slots = append(slots, teamSlot{Interval: r, free: ids})
+		}
+		return slots
+	}
+
+	byStart := make(map[time.Time]*teamSlot)
+	for _, m := range members {
+		for _, r := range perMember[m.ID].Split(length) {
+			if !m.accepts(r.Start) {
+				continue
+			}
+			slot, ok := byStart[r.Start]
+			if !ok {
+				slot = &teamSlot{Interval: r}
+				byStart[r.Start] = slot
+			}
+			slot.free = append(slot.free, m.ID)
+		}
+	}
+	slots := make([]teamSlot, 0, len(byStart))
+	for _, slot := range byStart {
+		slots = append(slots, *slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].Start.Before(slots[j].Start)
+	})
+	return slots
+}
+
+// pickRoundRobin chooses who takes a round robin booking: the free member
+// with the fewest active bookings for the team, then the one who was last
+// assigned longest ago, then the first in team order.
+func pickRoundRobin(free []string, teamBookings []store.Booking) string {
+	counts := make(map[string]int)
+	last := make(map[string]time.Time)
+	for _, b := range teamBookings {
+		counts[b.Host]++
+		if b.CreatedAt.After(last[b.Host]) {
+			last[b.Host] = b.CreatedAt
+		}
+	}
+
+	best := free[0]
+	for _, id := range free[1:] {
+		if counts[id] < counts[best] || (counts[id] == counts[best] && last[id].Before(last[best])) {
+			best = id
+		}
+	}
+	return best
+}
+
+// teamState is what the team's availability is computed from, read once so
+// that several days can be worked out without going back to the store.
+type teamState struct {
+	members  []teamMember               // In the same order as teamCalendar.members
+	bookings map[string][]store.Booking // Active bookings by member
+	team     []store.Booking            // Active bookings made through the team
+}
+
+// loadState reads the team's state, leaving out the booking with ID except
+// when one is being moved.
+func (t *teamCalendar) loadState(et EventType, now time.Time, except string) teamState {
+	state := teamState{
+		members:  make([]teamMember, 0, len(t.members)),
+		bookings: make(map[string][]store.Booking, len(t.members)),
+		team:     withoutBooking(t.teamBookings(), except),
+	}
+	for _, host := range t.members {
+		bookings := withoutBooking(host.activeBookings(), except)
+		state.bookings[host.id] = bookings
+		state.members = append(state.members, teamMember{
+			ID:       host.id,
+			Location: host.location,
+			Events:   busyEvents(host.Events(), bookings),
+			Schedule: et.schedule(host.currentSchedule()),
+			Earliest: now.Add(host.schedule.minimumNotice(et)),
+			Horizon:  host.schedule.horizon(now, host.location),
+		})
+	}
+	return state
+}
+
+// availableMembers returns the members that can still take bookings on
+// date. Members whose daily or weekly limit is reached are left out; for a
+// collective team that means nobody can be booked, so none are returned.
+func (t *teamCalendar) availableMembers(state teamState, date time.Time) []teamMember {
+	members := make([]teamMember, 0, len(t.members))
+	for i, host := range t.members {
+		local := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, host.location)
+		if host.checkCapsIn(state.bookings[host.id], local, nil) != nil {
+			if t.mode == config.TeamCollective {
+				return nil
+			}
+			continue
+		}
+		members = append(members, state.members[i])
+	}
+	return members
+}
+
+func (t *teamCalendar) teamBookings() []store.Booking {
+	return t.store.Bookings(func(b store.Booking) bool {
+		return b.Team == t.id && b.Active()
+	})
+}
+
+// slots returns the team's free time on date and the slots that can still
+// be booked, taking the event type's and members' limits into account.
+func (t *teamCalendar) slots(state teamState, date time.Time, et EventType) (availability.Set, []teamSlot) {
+	if et.MaxBookingsPerDay > 0 {
+		booked := 0
+		for _, b := range state.team {
+			if b.EventType == et.Slug && sameDay(b.Start.In(t.location), date) {
+				booked++
+			}
+		}
+		if booked >= et.MaxBookingsPerDay {
+			return nil, nil
+		}
+	}
+
+	members := t.availableMembers(state, date)
+	if len(members) == 0 {
+		return nil, nil
+	}
+	team, perMember := getTeamAvailableTimesForDate(date, members, t.mode)
+	return team, teamSlots(members, t.mode, team, perMember, et.Duration)
+}
+
+func (t *teamCalendar) availability(date time.Time, et EventType, now time.Time) ([]TimeSlot, []TimeSlot) {
+	team, slots := t.slots(t.loadState(et, now, ""), date, et)
+
+	bookable := make([]availability.Interval, 0, len(slots))
+	for _, s := range slots {
+		bookable = append(bookable, s.Interval)
+	}
+	return timeSlots(team, t.location), timeSlots(bookable, t.location)
+}
+
+// createBooking reserves the requested slot. Round robin bookings go to one
+// free member, collective bookings to all of them.
+func (t *teamCalendar) createBooking(req CreateBookingRequest, now time.Time) (store.Booking, error) {
+	details, err := validateBookingRequest(req, t.eventType, t.location)
+	if err != nil {
+		return store.Booking{}, err
+	}
+	if err := (ScheduleConfig{}).checkBookingWindow(details.start, details.eventType, now); err != nil {
+		return store.Booking{}, err
+	}
+
+	defer t.lock()()
+
+	state := t.loadState(details.eventType, now, "")
+	_, slots := t.slots(state, details.date, details.eventType)
+	var chosen *teamSlot
+	for i := range slots {
+		if slots[i].Start.Equal(details.start) {
+			chosen = &slots[i]
+			break
+		}
+	}
+	if chosen == nil {
+		return store.Booking{}, errSlotUnavailable
+	}
+
+	booking := details.newBooking(req, now)
+	booking.Team = t.id
+	if t.mode == config.TeamCollective {
+		booking.Host = chosen.free[0]
+		booking.Members = chosen.free
+	} else {
+		booking.Host = pickRoundRobin(chosen.free, state.team)
+	}
+	return t.store.AddBooking(booking)
+}
+
+// lock takes the team's booking lock and returns the function that releases
+// it. Members can also be booked directly or through other teams, so they
+// are locked too, always in the same order to avoid deadlocks.
+func (t *teamCalendar) lock() (unlock func()) {
+	t.bookingMutex.Lock()
+	locked := append([]*hostCalendar{}, t.members...)
+	sort.Slice(locked, func(i, j int) bool { return locked[i].id < locked[j].id })
+	for _, host := range locked {
+		host.bookingMutex.Lock()
+	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].bookingMutex.Unlock()
+		}
+		t.bookingMutex.Unlock()
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"caldave/internal/availability"
+	"caldave/internal/config"
+	"caldave/internal/store"
+	"caldave/internal/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func openHours(start, end string) ScheduleConfig {
+	return ScheduleConfig{DefaultHours: BusinessHours{StartTime: start, EndTime: end}}
+}
+
+func at(loc *time.Location, clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-11-13 "+clock, loc)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func formatRanges(ranges []availability.Interval, loc *time.Location) []string {
+	out := []string{}
+	for _, r := range ranges {
+		out = append(out, r.Start.In(loc).Format("15:04")+"-"+r.End.In(loc).Format("15:04"))
+	}
+	return out
+}
+
+func TestGetTeamAvailableTimesForDate(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	newYork := mustLoad(t, "America/New_York")
+	date := at(london, "00:00")
+
+	tests := []struct {
+		name     string
+		mode     string
+		members  []teamMember
+		expected []string
+	}{
+		{
+			name: "Round robin is the union of free time",
+			mode: config.TeamRoundRobin,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "12:00")},
+				{ID: "bob", Location: london, Schedule: openHours("11:00", "15:00")},
+			},
+			expected: []string{"09:00-15:00"},
+		},
+		{
+			name: "Collective is the intersection of free time",
+			mode: config.TeamCollective,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "17:00"), Events: []utils.EventData{
+					{StartTime: at(london, "10:00"), EndTime: at(london, "11:00")},
+				}},
+				{ID: "bob", Location: london, Schedule: openHours("09:00", "12:00")},
+			},
+			expected: []string{"09:00-10:00", "11:00-12:00"},
+		},
+		{
+			name: "Collective with no overlap has no free time",
+			mode: config.TeamCollective,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "12:00")},
+				{ID: "bob", Location: london, Schedule: openHours("13:00", "17:00")},
+			},
+			expected: []string{},
+		},
+		{
+			name: "Members in other time zones are converted",
+			mode: config.TeamCollective,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "17:00")},
+				{ID: "bob", Location: newYork, Schedule: openHours("09:00", "17:00")},
+			},
+			expected: []string{"14:00-17:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			team, _ := getTeamAvailableTimesForDate(date, tt.members, tt.mode)
+			got := formatRanges(team, london)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("getTeamAvailableTimesForDate() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTeamSlots(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	date := at(london, "00:00")
+
+	tests := []struct {
+		name     string
+		mode     string
+		members  []teamMember
+		length   time.Duration
+		expected map[string][]string // slot start -> free members
+	}{
+		{
+			name: "Round robin slot must fit one member",
+			mode: config.TeamRoundRobin,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "09:30")},
+				{ID: "bob", Location: london, Schedule: openHours("09:30", "10:00")},
+			},
+			length:   time.Hour,
+			expected: map[string][]string{},
+		},
+		{
+			name: "Round robin lists every free member",
+			mode: config.TeamRoundRobin,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "11:00")},
+				{ID: "bob", Location: london, Schedule: openHours("10:00", "11:00")},
+			},
+			length: time.Hour,
+			expected: map[string][]string{
+				"09:00": {"ada"},
+				"10:00": {"ada", "bob"},
+			},
+		},
+		{
+			name: "Collective slots include everyone",
+			mode: config.TeamCollective,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "11:00")},
+				{ID: "bob", Location: london, Schedule: openHours("10:00", "12:00")},
+			},
+			length: 30 * time.Minute,
+			expected: map[string][]string{
+				"10:00": {"ada", "bob"},
+				"10:30": {"ada", "bob"},
+			},
+		},
+		{
+			name: "Round robin drops members inside their notice period",
+			mode: config.TeamRoundRobin,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "11:00")},
+				{ID: "bob", Location: london, Schedule: openHours("09:00", "11:00"), Earliest: at(london, "10:00")},
+			},
+			length: time.Hour,
+			expected: map[string][]string{
+				"09:00": {"ada"},
+				"10:00": {"ada", "bob"},
+			},
+		},
+		{
+			name: "Collective slots respect every member's horizon",
+			mode: config.TeamCollective,
+			members: []teamMember{
+				{ID: "ada", Location: london, Schedule: openHours("09:00", "11:00")},
+				{ID: "bob", Location: london, Schedule: openHours("09:00", "11:00"), Horizon: at(london, "10:00")},
+			},
+			length:   time.Hour,
+			expected: map[string][]string{"09:00": {"ada", "bob"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			team, perMember := getTeamAvailableTimesForDate(date, tt.members, tt.mode)
+			got := map[string][]string{}
+			for _, slot := range teamSlots(tt.members, tt.mode, team, perMember, tt.length) {
+				got[slot.Start.In(london).Format("15:04")] = slot.free
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("teamSlots() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	monday := time.Date(2024, 11, 11, 9, 0, 0, 0, time.UTC)
+	booking := func(host string, created time.Time) store.Booking {
+		return store.Booking{Host: host, Team: "sales", CreatedAt: created}
+	}
+
+	tests := []struct {
+		name     string
+		free     []string
+		bookings []store.Booking
+		expected string
+	}{
+		{
+			name:     "No bookings picks the first member",
+			free:     []string{"ada", "bob", "cy"},
+			expected: "ada",
+		},
+		{
+			name: "Fewest bookings wins",
+			free: []string{"ada", "bob", "cy"},
+			bookings: []store.Booking{
+				booking("ada", monday),
+				booking("bob", monday),
+			},
+			expected: "cy",
+		},
+		{
+			name: "Ties go to the member assigned longest ago",
+			free: []string{"ada", "bob"},
+			bookings: []store.Booking{
+				booking("ada", monday.Add(time.Hour)),
+				booking("bob", monday),
+			},
+			expected: "bob",
+		},
+		{
+			name: "Only free members are considered",
+			free: []string{"ada", "bob"},
+			bookings: []store.Booking{
+				booking("ada", monday),
+				booking("ada", monday),
+				booking("bob", monday),
+			},
+			expected: "bob",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickRoundRobin(tt.free, tt.bookings); got != tt.expected {
+				t.Errorf("pickRoundRobin() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPickRoundRobinDistributesEvenly(t *testing.T) {
+	free := []string{"ada", "bob", "cy"}
+	var bookings []store.Booking
+	created := time.Date(2024, 11, 11, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 30; i++ {
+		host := pickRoundRobin(free, bookings)
+		bookings = append(bookings, store.Booking{Host: host, CreatedAt: created})
+		created = created.Add(time.Minute)
+	}
+
+	counts := map[string]int{}
+	for _, b := range bookings {
+		counts[b.Host]++
+	}
+	expected := map[string]int{"ada": 10, "bob": 10, "cy": 10}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("assignments = %v, want %v", counts, expected)
+	}
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"caldave/internal/availability"
+	"caldave/internal/i18n"
+	"caldave/internal/logging"
+	"caldave/internal/store"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// waitlistClaimWindow is how long a waitlist offer holds a freed seat before
+// it passes to the next person.
+const waitlistClaimWindow = 2 * time.Hour
+
+var errOfferNotFound = errors.New("waitlist offer not found")
+
+type WaitlistJoinedData struct {
+	ID        string `json:"id"`
+	EventType string `json:"eventType"`
+	Date      string `json:"date"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Position  int    `json:"position"` // 1 for the first in the queue
+}
+
+// waitlistSlots returns the start times of the event type on date that are
+// fully booked and can still be waited for.
+func (h *hostCalendar) waitlistSlots(date time.Time, et EventType, now time.Time) []TimeSlot {
+	sessions, _ := h.sessions(h.activeBookings(), et)
+	var full []availability.Interval
+	for _, s := range sessions {
+		if s.seats(et) == 0 && sameDay(s.Start.In(h.location), date) && h.schedule.checkBookingWindow(s.Start, et, now) == nil {
+			full = append(full, s.Interval)
+		}
+	}
+	return timeSlots(full, h.location)
+}
+
+// offerHold is the seat a waitlist offer holds, as a pending booking that
+// expires with the offer.
+func offerHold(e store.WaitlistEntry) store.Booking {
+	return store.Booking{
+		ID:                  "waitlist-" + e.ID,
+		Host:                e.Host,
+		EventType:           e.EventType,
+		Start:               e.Start,
+		End:                 e.End,
+		BufferBeforeMinutes: e.BufferBeforeMinutes,
+		BufferAfterMinutes:  e.BufferAfterMinutes,
+		Name:                e.Name,
+		Email:               e.Email,
+		Status:              store.StatusPending,
+		ExpiresAt:           e.OfferExpiresAt,
+		Locale:              e.Locale,
+	}
+}
+
+// joinWaitlist queues the booker for a fully booked slot. Only hosts have
+// waitlists; a team spreads its bookings over its members instead.
+func (wsh *WebSocketHandler) joinWaitlist(ctx context.Context, owner calendarOwner, req CreateBookingRequest, now time.Time) (store.WaitlistEntry, int, error) {
+	host, ok := owner.(*hostCalendar)
+	if !ok {
+		return store.WaitlistEntry{}, 0, fmt.Errorf("%w: team pages have no waitlist", errInvalidBooking)
+	}
+	details, err := validateBookingRequest(req, host.eventType, host.location)
+	if err != nil {
+		return store.WaitlistEntry{}, 0, err
+	}
+	if !containsSlot(host.waitlistSlots(details.date, details.eventType, now), req.Start) {
+		return store.WaitlistEntry{}, 0, fmt.Errorf("%w: only fully booked times have a waitlist", errInvalidBooking)
+	}
+
+	b := details.newBooking(req, now)
+	entry, err := wsh.store.AddWaitlistEntry(store.WaitlistEntry{
+		Host:                host.id,
+		EventType:           b.EventType,
+		Start:               b.Start,
+		End:                 b.End,
+		BufferBeforeMinutes: b.BufferBeforeMinutes,
+		BufferAfterMinutes:  b.BufferAfterMinutes,
+		Name:                b.Name,
+		Email:               b.Email,
+		Answers:             b.Answers,
+		Status:              store.WaitlistWaiting,
+		Locale:              b.Locale,
+	})
+	if errors.Is(err, store.ErrConflict) {
+		return store.WaitlistEntry{}, 0, fmt.Errorf("%w: you are already on the waitlist for that time", errInvalidBooking)
+	}
+	if err != nil {
+		return store.WaitlistEntry{}, 0, err
+	}
+
+	position := len(wsh.store.Waitlist(func(e store.WaitlistEntry) bool {
+		return e.Status == store.WaitlistWaiting && e.SameSlot(entry)
+	}))
+	wsh.notifyBooker(ctx, offerHold(entry), "waitlisted", position)
+	wsh.wakeWaitlist()
+	return entry, position, nil
+}
+
+// wakeWaitlist asks the waitlist worker to look for freed seats now rather
+// than at its next tick.
+func (wsh *WebSocketHandler) wakeWaitlist() {
+	select {
+	case wsh.waitlistWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWaitlist offers freed seats to the people waiting for them, once a
+// minute and whenever a booking releases its slot, until ctx is done.
+func (wsh *WebSocketHandler) runWaitlist(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wsh.waitlistWake:
+		}
+		wsh.processWaitlist(logging.WithRequestID(ctx, logging.NewRequestID()), time.Now())
+	}
+}
+
+// processWaitlist lets offers nobody claimed lapse, closes the queues of
+// slots that have started and offers every free seat to the next person in
+// its queue.
+func (wsh *WebSocketHandler) processWaitlist(ctx context.Context, now time.Time) {
+	for _, e := range wsh.store.Waitlist(func(e store.WaitlistEntry) bool {
+		return e.Status == store.WaitlistOffered && !now.Before(e.OfferExpiresAt)
+	}) {
+		lapsed, err := wsh.setWaitlistStatus(ctx, e.ID, store.WaitlistLapsed, store.WaitlistOffered)
+		if err != nil {
+			continue
+		}
+		wsh.scheduleChanged(lapsed.Host)
+		wsh.notifyBooker(ctx, offerHold(lapsed), "offerLapsed")
+	}
+
+	var slots []store.WaitlistEntry // The first waiting entry of each slot
+	for _, e := range wsh.store.Waitlist(func(e store.WaitlistEntry) bool { return e.Status == store.WaitlistWaiting }) {
+		if !now.Before(e.Start) {
+			if closed, err := wsh.setWaitlistStatus(ctx, e.ID, store.WaitlistClosed, store.WaitlistWaiting); err == nil {
+				wsh.notifyBooker(ctx, offerHold(closed), "waitlistClosed")
+			}
+			continue
+		}
+		if !slices.ContainsFunc(slots, e.SameSlot) {
+			slots = append(slots, e)
+		}
+	}
+	for _, first := range slots {
+		wsh.offerSeats(ctx, first, now)
+	}
+}
+
+// offerSeats offers every seat that is free in first's slot to the people
+// at the front of its queue.
+func (wsh *WebSocketHandler) offerSeats(ctx context.Context, first store.WaitlistEntry, now time.Time) {
+	host, ok := wsh.hosts[first.Host]
+	if !ok {
+		return
+	}
+	et, ok := host.eventType(first.EventType)
+	if !ok {
+		return
+	}
+
+	// Hold the booking lock so nobody books the seat while it is offered.
+	host.bookingMutex.Lock()
+	defer host.bookingMutex.Unlock()
+
+	start := first.Start.In(host.location)
+	date := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, host.location)
+	_, slots := host.availability(date, et, now)
+	i := slices.IndexFunc(slots, func(s TimeSlot) bool { return s.Start == start.Format("15:04") })
+	if i < 0 {
+		return
+	}
+	seats := max(slots[i].Seats, 1)
+
+	// Offers expire in time for the booking to respect the minimum notice.
+	expires := now.Add(waitlistClaimWindow)
+	if deadline := first.Start.Add(-host.schedule.minimumNotice(et)); deadline.Before(expires) {
+		expires = deadline
+	}
+
+	queue := wsh.store.Waitlist(func(e store.WaitlistEntry) bool {
+		return e.Status == store.WaitlistWaiting && e.SameSlot(first)
+	})
+	for _, e := range queue[:min(seats, len(queue))] {
+		token, err := store.NewID()
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating waitlist token", "error", err)
+			return
+		}
+		offered, err := wsh.store.UpdateWaitlistEntry(e.ID, func(e *store.WaitlistEntry) error {
+			if e.Status != store.WaitlistWaiting {
+				return errBookingStatus
+			}
+			e.Status = store.WaitlistOffered
+			e.Token = token
+			e.OfferExpiresAt = expires
+			return nil
+		})
+		if err != nil {
+			if !errors.Is(err, errBookingStatus) {
+				slog.ErrorContext(ctx, "Error offering waitlist seat", "waitlist", e.ID, "error", err)
+			}
+			continue
+		}
+		slog.InfoContext(ctx, "Waitlist seat offered", "waitlist", e.ID, "host", e.Host, "event_type", e.EventType)
+		wsh.notifyBooker(ctx, offerHold(offered), "offered",
+			wsh.formatTime(offerHold(offered), expires), fmt.Sprintf("%s/waitlist/%s?token=%s", wsh.baseURL, offered.ID, token))
+	}
+	wsh.scheduleChanged(host.id)
+}
+
+// setWaitlistStatus moves the entry to status if it currently has one of
+// from.
+func (wsh *WebSocketHandler) setWaitlistStatus(ctx context.Context, id, status string, from ...string) (store.WaitlistEntry, error) {
+	entry, err := wsh.store.UpdateWaitlistEntry(id, func(e *store.WaitlistEntry) error {
+		if !slices.Contains(from, e.Status) {
+			return fmt.Errorf("%w: it is %s", errBookingStatus, e.Status)
+		}
+		e.Status = status
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return store.WaitlistEntry{}, errBookingNotFound
+	}
+	if err != nil && !errors.Is(err, errBookingStatus) {
+		slog.ErrorContext(ctx, "Error updating waitlist entry", "waitlist", id, "error", err)
+	}
+	return entry, err
+}
+
+// offer returns the entry with an offer that token can claim.
+func (wsh *WebSocketHandler) offer(id, token string) (store.WaitlistEntry, error) {
+	e, err := wsh.store.WaitlistEntry(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.WaitlistEntry{}, errOfferNotFound
+	}
+	if err != nil {
+		return store.WaitlistEntry{}, err
+	}
+	if e.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(e.Token)) != 1 {
+		return store.WaitlistEntry{}, errOfferNotFound
+	}
+	switch {
+	case e.Status == store.WaitlistClaimed:
+		return store.WaitlistEntry{}, fmt.Errorf("%w: you have already claimed this seat", errBookingStatus)
+	case !e.Holding():
+		return store.WaitlistEntry{}, fmt.Errorf("%w: the offer has expired", errBookingStatus)
+	}
+	return e, nil
+}
+
+// claimOffer books the seat held by a waitlist offer.
+func (wsh *WebSocketHandler) claimOffer(ctx context.Context, id, token string, now time.Time) (store.Booking, error) {
+	e, err := wsh.offer(id, token)
+	if err != nil {
+		return store.Booking{}, err
+	}
+	host, ok := wsh.hosts[e.Host]
+	if !ok {
+		return store.Booking{}, fmt.Errorf("%w: %s no longer exists", errBookingStatus, e.Host)
+	}
+	et, ok := host.eventType(e.EventType)
+	if !ok {
+		return store.Booking{}, fmt.Errorf("%w: event type %q no longer exists", errBookingStatus, e.EventType)
+	}
+
+	host.bookingMutex.Lock()
+	defer host.bookingMutex.Unlock()
+
+	// The seat is only free once the offer's own hold is left out.
+	start := e.Start.In(host.location)
+	date := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, host.location)
+	bookings := withoutBooking(host.activeBookings(), offerHold(e).ID)
+	_, slots := host.dayAvailability(date, et, now, et.schedule(host.currentSchedule()), host.Events(), bookings)
+	if !containsSlot(slots, start.Format("15:04")) {
+		return store.Booking{}, errSlotUnavailable
+	}
+
+	details := bookingDetails{eventType: et, date: date, start: start, name: e.Name, email: e.Email}
+	b := details.newBooking(CreateBookingRequest{Answers: e.Answers, Locale: e.Locale}, now)
+	b.Host = host.id
+	booking, err := wsh.store.AddBooking(b)
+	if err != nil {
+		return store.Booking{}, err
+	}
+	if _, err := wsh.store.UpdateWaitlistEntry(e.ID, func(e *store.WaitlistEntry) error {
+		e.Status = store.WaitlistClaimed
+		e.BookingID = booking.ID
+		return nil
+	}); err != nil {
+		slog.ErrorContext(ctx, "Error marking waitlist offer claimed", "waitlist", e.ID, "booking", booking.ID, "error", err)
+	}
+
+	wsh.bookingCreated(ctx, booking)
+	wsh.scheduleChanged(host.id)
+	return booking, nil
+}
+
+// waitlistPage is the data rendered into waitlist.html.
+type waitlistPage struct {
+	ID      string
+	Token   string
+	Event   string
+	Host    string
+	When    string
+	Expires string
+	Claimed bool
+	Status  string // Pending or confirmed, once claimed
+	Error   string
+	Locale  *i18n.Locale // The language the booker joined in, or the browser's when the offer is unknown
+}
+
+// WaitlistOfferPage serves GET /waitlist/{id}?token=..., the claim link
+// emailed with a waitlist offer. Claiming takes a POST so that link
+// scanners cannot claim the seat by following the link.
+func WaitlistOfferPage(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		w.Header().Add("Vary", "Accept-Language")
+		page := waitlistPage{ID: r.PathValue("id"), Token: token, Locale: i18n.Match(r.Header.Get("Accept-Language"))}
+		e, err := wsh.offer(page.ID, token)
+		if err != nil {
+			page.Error = offerError(err)
+			w.WriteHeader(offerStatus(err))
+		} else {
+			page.describe(wsh, offerHold(e))
+			page.Expires = wsh.formatTime(offerHold(e), e.OfferExpiresAt)
+		}
+		wsh.renderWaitlistPage(w, page)
+	})
+}
+
+// ClaimWaitlistOffer serves POST /waitlist/{id}/claim with the token from
+// the claim link in the form.
+func ClaimWaitlistOffer(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		page := waitlistPage{ID: r.PathValue("id"), Token: r.PostFormValue("token"), Locale: i18n.Match(r.Header.Get("Accept-Language"))}
+		booking, err := wsh.claimOffer(r.Context(), page.ID, page.Token, time.Now())
+		if err != nil {
+			if offerStatus(err) == http.StatusInternalServerError {
+				slog.ErrorContext(r.Context(), "Error claiming waitlist offer", "waitlist", page.ID, "error", err)
+			}
+			page.Error = offerError(err)
+			w.WriteHeader(offerStatus(err))
+		} else {
+			page.describe(wsh, booking)
+			page.Claimed = true
+			page.Status = booking.Status
+		}
+		wsh.renderWaitlistPage(w, page)
+	})
+}
+
+func (p *waitlistPage) describe(wsh *WebSocketHandler, b store.Booking) {
+	p.Locale = i18n.Get(b.Locale)
+	p.Host, p.Event = b.Host, b.EventType
+	if owner, ok := wsh.bookingOwner(b); ok {
+		p.Host = owner.Name()
+		if et, ok := owner.eventType(b.EventType); ok {
+			p.Event = et.Name
+		}
+	}
+	p.When = p.Locale.T("event.range", wsh.formatTime(b, b.Start), wsh.formatTime(b, b.End))
+}
+
+func offerStatus(err error) int {
+	switch {
+	case errors.Is(err, errOfferNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errBookingStatus), errors.Is(err, errSlotUnavailable), errors.Is(err, errInvalidBooking):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func offerError(err error) string {
+	if offerStatus(err) == http.StatusInternalServerError {
+		return "Unable to claim the seat, please try again"
+	}
+	return err.Error()
+}
+
+func (wsh *WebSocketHandler) renderWaitlistPage(w http.ResponseWriter, page waitlistPage) {
+	if err := wsh.render(w, "waitlist.html", page); err != nil {
+		slog.Error("Error rendering waitlist page", "error", err)
+	}
+}
+
+// WaitlistHandler serves GET /admin/api/waitlist, every entry still waiting
+// for or holding a seat, in the order they joined.
+func WaitlistHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries := wsh.store.Waitlist(func(e store.WaitlistEntry) bool { return e.Queued() })
+		for i := range entries {
+			entries[i].Token = "" // Only the person on the list gets to claim
+		}
+		if entries == nil {
+			entries = []store.WaitlistEntry{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
+
+// RemoveWaitlistEntryHandler serves DELETE /admin/api/waitlist/{id}. A seat
+// held by the entry's offer goes to the next person.
+func RemoveWaitlistEntryHandler(wsh *WebSocketHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, err := wsh.setWaitlistStatus(r.Context(), r.PathValue("id"), store.WaitlistRemoved, store.WaitlistWaiting, store.WaitlistOffered)
+		switch {
+		case errors.Is(err, errBookingNotFound):
+			writeJSONError(w, http.StatusNotFound, "waitlist entry not found")
+		case errors.Is(err, errBookingStatus):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeJSONError(w, http.StatusInternalServerError, "unable to update waitlist entry")
+		default:
+			wsh.scheduleChanged(e.Host)
+			wsh.wakeWaitlist()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+func (c *Client) handleJoinWaitlistRequest(message Message) {
+	reqBytes, _ := json.Marshal(message.Payload)
+	var request CreateBookingRequest
+	if err := json.Unmarshal(reqBytes, &request); err != nil {
+		c.logger.WarnContext(c.ctx, "Error parsing join waitlist request", "error", err)
+		return
+	}
+	request.Locale = c.locale
+
+	if err := c.checkBooking(request); err != nil {
+		c.send(Message{Type: string(BookingFailed), Payload: BookingErrorData{Error: err.Error()}})
+		return
+	}
+
+	owner := c.Hub.owner
+	entry, position, err := c.handler.joinWaitlist(c.ctx, owner, request, time.Now())
+	if err != nil {
+		reason := err.Error()
+		if !errors.Is(err, errInvalidBooking) {
+			c.logger.ErrorContext(c.ctx, "Error joining waitlist", "error", err)
+			reason = "Unable to join the waitlist, please try again"
+		}
+		c.send(Message{Type: string(BookingFailed), Payload: BookingErrorData{Error: reason}})
+		return
+	}
+
+	start := entry.Start.In(owner.Location())
+	c.send(Message{
+		Type: string(WaitlistJoined),
+		Payload: WaitlistJoinedData{
+			ID:        entry.ID,
+			EventType: entry.EventType,
+			Date:      start.Format("2006-01-02"),
+			Start:     start.Format("15:04"),
+			End:       entry.End.In(owner.Location()).Format("15:04"),
+			Position:  position,
+		},
+	})
+	c.logger.InfoContext(c.ctx, "Joined waitlist", "waitlist", entry.ID, "event_type", entry.EventType)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/notify"
+	"caldave/internal/store"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitlist(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := newHostCalendar(config.HostConfig{
+		ID:         "dave",
+		TimeZone:   "Europe/London",
+		Schedule:   config.Schedule{Default: config.Hours{Start: "09:00", End: "12:00"}},
+		EventTypes: []config.EventTypeConfig{{Slug: "call", Name: "Call", DurationMinutes: 60}},
+	}, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh := &WebSocketHandler{
+		hosts:    map[string]*hostCalendar{"dave": host},
+		owners:   map[string]calendarOwner{"dave": host},
+		store:    st,
+		notifier: notify.LogNotifier{},
+	}
+	ctx := context.Background()
+
+	// Offers hold their seat until a real deadline, so work with real time.
+	now := time.Now()
+	local := now.In(host.location)
+	date := time.Date(local.Year(), local.Month(), local.Day()+3, 0, 0, 0, 0, host.location)
+	et, _ := host.eventType("call")
+	request := func(name, start string) CreateBookingRequest {
+		return CreateBookingRequest{
+			EventType: "call",
+			Date:      date.Format("2006-01-02"),
+			Start:     start,
+			Name:      name,
+			Email:     name + "@example.com",
+		}
+	}
+	offered := func(id string) store.WaitlistEntry {
+		t.Helper()
+		e, err := st.WaitlistEntry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	booked, err := host.createBooking(request("ada", "10:00"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := wsh.joinWaitlist(ctx, host, request("bob", "09:00"), now); !errors.Is(err, errInvalidBooking) {
+		t.Errorf("Expected free slots to have no waitlist, got %v", err)
+	}
+	bob, position, err := wsh.joinWaitlist(ctx, host, request("bob", "10:00"), now)
+	if err != nil || position != 1 {
+		t.Fatalf("Expected bob to be first on the waitlist, got %d and %v", position, err)
+	}
+	cy, position, err := wsh.joinWaitlist(ctx, host, request("cy", "10:00"), now)
+	if err != nil || position != 2 {
+		t.Fatalf("Expected cy to be second on the waitlist, got %d and %v", position, err)
+	}
+	if _, _, err := wsh.joinWaitlist(ctx, host, request("cy", "10:00"), now); !errors.Is(err, errInvalidBooking) {
+		t.Errorf("Expected cy not to be queued twice, got %v", err)
+	}
+
+	// Nothing is offered while the slot is taken.
+	wsh.processWaitlist(ctx, now)
+	if e := offered(bob.ID); e.Status != store.WaitlistWaiting {
+		t.Fatalf("Expected bob to be waiting, got %s", e.Status)
+	}
+
+	// A cancellation offers the seat to bob and holds it for him.
+	if _, err := wsh.cancelBooking(ctx, booked.ID); err != nil {
+		t.Fatal(err)
+	}
+	wsh.processWaitlist(ctx, now)
+	if e := offered(bob.ID); e.Status != store.WaitlistOffered || e.Token == "" {
+		t.Fatalf("Expected bob to have an offer, got %+v", e)
+	}
+	if _, slots := host.availability(date, et, now); containsSlot(slots, "10:00") {
+		t.Error("Expected the offered seat to be held")
+	}
+	if _, err := wsh.claimOffer(ctx, bob.ID, "guess", now); !errors.Is(err, errOfferNotFound) {
+		t.Errorf("Expected a wrong token to be refused, got %v", err)
+	}
+
+	// Bob lets the offer lapse, so it passes to cy, who claims it.
+	later := now.Add(waitlistClaimWindow + time.Minute)
+	wsh.processWaitlist(ctx, later)
+	if e := offered(bob.ID); e.Status != store.WaitlistLapsed {
+		t.Errorf("Expected bob's offer to lapse, got %s", e.Status)
+	}
+	claim := offered(cy.ID)
+	if claim.Status != store.WaitlistOffered {
+		t.Fatalf("Expected cy to have an offer, got %s", claim.Status)
+	}
+	booking, err := wsh.claimOffer(ctx, cy.ID, claim.Token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booking.Name != "cy" || !booking.Start.Equal(booked.Start) || booking.Status != store.StatusConfirmed {
+		t.Errorf("Unexpected booking %+v", booking)
+	}
+	if e := offered(cy.ID); e.Status != store.WaitlistClaimed || e.BookingID != booking.ID {
+		t.Errorf("Expected cy's offer to be claimed, got %+v", e)
+	}
+	if _, err := wsh.claimOffer(ctx, cy.ID, claim.Token, now); !errors.Is(err, errBookingStatus) {
+		t.Errorf("Expected a second claim to be refused, got %v", err)
+	}
+}
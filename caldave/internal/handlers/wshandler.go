@@ -1,19 +1,29 @@
 package handlers
 
 import (
+	"caldave/internal/auth"
+	"caldave/internal/availability"
+	"caldave/internal/config"
+	"caldave/internal/i18n"
+	"caldave/internal/jobs"
+	"caldave/internal/logging"
+	"caldave/internal/notify"
+	"caldave/internal/ratelimit"
+	"caldave/internal/store"
 	"caldave/internal/utils"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
 	"net/http"
-	"os"
-	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/net/websocket"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 )
@@ -25,6 +35,22 @@ const (
 	AvailabilityResponse MessageType = "AVAILABILITY_RESPONSE"
 	UpdateAvailaibilty   MessageType = "UPDATE_AVAILABILITY"
 	EventUpdated         MessageType = "EVENTS_UPDATED"
+	CreateBooking        MessageType = "CREATE_BOOKING"
+	BookingCreated       MessageType = "BOOKING_CREATED"
+	BookingFailed        MessageType = "BOOKING_ERROR"
+
+	RequestMonthAvailability  MessageType = "REQUEST_MONTH_AVAILABILITY"
+	MonthAvailabilityResponse MessageType = "MONTH_AVAILABILITY_RESPONSE"
+
+	RequestChallenge  MessageType = "REQUEST_CHALLENGE" // Sent before CREATE_BOOKING
+	ChallengeResponse MessageType = "CHALLENGE"
+	ErrorMessage      MessageType = "ERROR" // Rate limited or not allowed
+
+	JoinWaitlist   MessageType = "JOIN_WAITLIST" // A CreateBookingRequest for a fully booked slot
+	WaitlistJoined MessageType = "WAITLIST_JOINED"
+
+	ServerShutdown MessageType = "SERVER_SHUTDOWN" // Sent before disconnecting, says when to reconnect
+	SessionStarted MessageType = "SESSION"         // Sent on connecting, with the token to resume the session
 )
 
 type Message struct {
@@ -33,12 +59,14 @@ type Message struct {
 }
 
 type AvailabilityRequest struct {
-	Date string `json:"date"` // Format: "2024-10-11"
+	Date      string `json:"date"`      // Format: "2024-10-11"
+	EventType string `json:"eventType"` // Slug, the host's first event type if empty
 }
 
 type TimeSlot struct {
-	Start string `json:"start"` // Format: "HH:MM"
-	End   string `json:"end"`   // Format: "HH:MM"
+	Start string `json:"start"`           // Format: "HH:MM"
+	End   string `json:"end"`             // Format: "HH:MM"
+	Seats int    `json:"seats,omitempty"` // Seats left, only set for group event types
 }
 
 type BusinessHours struct {
@@ -47,14 +75,24 @@ type BusinessHours struct {
 }
 
 type ScheduleConfig struct {
-	WeekdayHours  map[time.Weekday]BusinessHours // Custom business hours for specific weekdays
-	DefaultHours  BusinessHours                  // Default business hours
-	BufferMinutes int                            // Buffer time around events, in minutes
+	WeekdayHours        map[time.Weekday]BusinessHours // Custom business hours for specific weekdays
+	DefaultHours        BusinessHours                  // Default business hours
+	BufferBeforeMinutes int                            // Free time needed before a meeting, in minutes
+	BufferAfterMinutes  int                            // Free time needed after a meeting, in minutes
+	DateOverrides       map[string][]BusinessHours     // Keyed by "2006-01-02", replace the weekday hours; no hours means closed
+
+	MinimumNoticeMinutes int // How long before its start a meeting can still be booked
+	MaxDaysInFuture      int // How many days ahead bookings are accepted, 0 for no limit
+	MaxBookingsPerDay    int // Across all event types, 0 for no limit
+	MaxBookingsPerWeek   int // Monday to Sunday, 0 for no limit
 }
 
 type AvailabilityResponseData struct {
 	Date           string     `json:"date"`
+	EventType      string     `json:"eventType"`
 	AvailableTimes []TimeSlot `json:"availableTimes"`
+	Slots          []TimeSlot `json:"slots"`              // Bookable start times for the event type
+	Waitlist       []TimeSlot `json:"waitlist,omitempty"` // Fully booked start times with a waitlist
 	// Meet with David 9:00 - 10:00
 	// 8:00 - 8:50
 	// 10:10 - 17:00
@@ -66,105 +104,240 @@ type UpdateEventsRequest struct {
 }
 
 type Client struct {
-	ID         string
+	ID         string // The session token
 	Connection *websocket.Conn
 	Hub        *Hub
 	Send       chan Message
+	handler    *WebSocketHandler
+	ctx        context.Context // Carries the request ID of the WebSocket handshake
+	logger     *slog.Logger
+	ip         string
+	admin      bool // Connected with the admin token
+	limiter    *ratelimit.Bucket
+	protection Protection
+	quit       chan struct{} // Closed by the hub to disconnect the client on shutdown
+	done       chan struct{} // Closed by the hub once it drops the client, Send is never closed
+	session    *clientSession
+	locale     string // Picked from the handshake's Accept-Language for the booker's emails
 }
 
+// Hub tracks the clients connected to one host's or team's booking page.
 type Hub struct {
 	Clients    map[string]*Client
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan Message
 	mutex      sync.RWMutex
-	wsHandler  *WebSocketHandler
+	owner      calendarOwner
+	done       chan struct{} // Closed once Run has returned
 }
 
 // WebSocketHandler handles WebSocket connections
 type WebSocketHandler struct {
-	hub             *Hub
-	calendarService *calendar.Service
-	events          []utils.EventData
+	auth        *auth.Manager
+	hosts       map[string]*hostCalendar
+	hostIDs     []string                 // In hosts file order
+	owners      map[string]calendarOwner // Hosts and teams by id
+	defaultHost string
+	store       *store.Store
+	notifier    notify.Notifier
+	baseURL     string // Public URL of the server, for links in emails
+	advertiseWS bool   // Whether booking pages are told to connect via baseURL
+	site        Site
+	pages       *template.Template
+	pagesErr    error
+	pagesOnce   sync.Once
+	scheduler   *jobs.Scheduler
+
+	waitlistWake chan struct{} // Wakes the waitlist worker when a seat may have come free
+	writes       writes        // Bookings being made over WebSockets
+	sessions     clientSessions
+
+	listenersMutex sync.RWMutex
+	listeners      []func(BookingEvent)
 }
 
-func NewHub(handler *WebSocketHandler) *Hub {
+func NewHub(owner calendarOwner) *Hub {
 	return &Hub{
 		Clients:    make(map[string]*Client),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan Message, 256),
-		wsHandler:  handler,
+		owner:      owner,
+		done:       make(chan struct{}),
 	}
 }
 
-func NewWebSocketHandler() *WebSocketHandler {
-	handler := &WebSocketHandler{}
-	hub := NewHub(handler)
-	handler.hub = hub
+func NewWebSocketHandler(authManager *auth.Manager, hostsFile *config.HostsFile, st *store.Store, notifier notify.Notifier, baseURL string, site Site) (*WebSocketHandler, error) {
+	handler, err := newWebSocketHandler(authManager, hostsFile, st, notifier, baseURL, site)
+	if err != nil {
+		return nil, err
+	}
 
-	go hub.Run()
+	authManager.OnConnect(handler.connect)
+	for id := range handler.hosts {
+		handler.connect(logging.WithRequestID(context.Background(), logging.NewRequestID()), id)
+	}
+	return handler, nil
+}
 
-	ctx := context.Background()
-	b, err := os.ReadFile("credentials.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+// NewCalendars loads the hosts and teams without connecting their calendars
+// or starting any background work, for the command line tools. Bookers are
+// not emailed; changes go through the running server for that.
+func NewCalendars(authManager *auth.Manager, hostsFile *config.HostsFile, st *store.Store) (*WebSocketHandler, error) {
+	return newWebSocketHandler(authManager, hostsFile, st, notify.LogNotifier{}, "", Site{})
+}
+
+func newWebSocketHandler(authManager *auth.Manager, hostsFile *config.HostsFile, st *store.Store, notifier notify.Notifier, baseURL string, site Site) (*WebSocketHandler, error) {
+	handler := &WebSocketHandler{
+		auth:        authManager,
+		hosts:       make(map[string]*hostCalendar, len(hostsFile.Hosts)),
+		owners:      make(map[string]calendarOwner),
+		defaultHost: hostsFile.Hosts[0].ID,
+		store:       st,
+		notifier:    notifier,
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		site:        site,
+		scheduler:   jobs.New(st),
+
+		waitlistWake: make(chan struct{}, 1),
 	}
 
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+	for _, cfg := range hostsFile.Hosts {
+		host, err := newHostCalendar(cfg, st)
+		if err != nil {
+			return nil, err
+		}
+		handler.hosts[cfg.ID] = host
+		handler.hostIDs = append(handler.hostIDs, cfg.ID)
+		handler.owners[cfg.ID] = host
+	}
+	for _, cfg := range hostsFile.Teams {
+		team, err := newTeamCalendar(cfg, handler.hosts, st)
+		if err != nil {
+			return nil, err
+		}
+		handler.owners[cfg.ID] = team
 	}
-	client := utils.GetClient(config)
 
-	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+	handler.registerJobs()
+
+	return handler, nil
+}
+
+// Run serves the booking pages' hubs and does the background work of
+// keeping calendars in sync, offering waitlist seats and running scheduled
+// jobs until ctx is done. It then disconnects every client and returns once
+// the bookings they were making are saved.
+func (wsh *WebSocketHandler) Run(ctx context.Context) {
+	wsh.scheduleMissingJobs(logging.WithRequestID(ctx, logging.NewRequestID()))
+
+	runs := []func(context.Context){wsh.refreshEvents, wsh.runWaitlist, wsh.scheduler.Run}
+	for _, owner := range wsh.owners {
+		runs = append(runs, owner.Hub().Run)
 	}
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
+	wg.Wait()
+	wsh.writes.drain()
+}
 
-	handler.calendarService = srv
+// connect builds the host's calendar service from its stored token and loads
+// the events. Until the host has completed the OAuth flow there are no events.
+func (wsh *WebSocketHandler) connect(ctx context.Context, hostID string) {
+	if host, ok := wsh.attachCalendar(ctx, hostID); ok {
+		host.updateEvents(ctx, time.Now().AddDate(0, 0, -30), time.Now().AddDate(0, 0, 60))
+	}
+}
 
-	handler.updateEvents()
+// attachCalendar gives the host a client for their Google Calendar, if they
+// have authorized one, without fetching any events.
+func (wsh *WebSocketHandler) attachCalendar(ctx context.Context, hostID string) (*hostCalendar, bool) {
+	host, ok := wsh.hosts[hostID]
+	if !ok {
+		return nil, false
+	}
+
+	client, err := wsh.auth.Client(ctx, hostID)
+	if errors.Is(err, auth.ErrNoToken) {
+		slog.WarnContext(ctx, "Google Calendar is not connected, connect it from the admin dashboard", "host", hostID)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to load oauth token", "host", hostID, "error", err)
+		return nil, false
+	}
 
-	go handler.refreshEvents()
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to retrieve Calendar client", "host", hostID, "error", err)
+		return nil, false
+	}
 
-	return handler
+	host.setService(srv)
+	return host, true
 }
 
-// Run starts the Hub's main loop
-func (h *Hub) Run() {
+// Run starts the Hub's main loop. Once ctx is done it tells every client the
+// server is shutting down and disconnects them.
+func (h *Hub) Run(ctx context.Context) {
+	defer close(h.done)
 	for {
 		select {
+		case <-ctx.Done():
+			h.mutex.Lock()
+			h.shutdown()
+			h.mutex.Unlock()
+			return
+
 		case client := <-h.Register:
 			h.mutex.Lock()
 			h.Clients[client.ID] = client
+			h.countClients()
 			h.mutex.Unlock()
-			log.Printf("Client %s connected", client.ID)
+			client.logger.InfoContext(client.ctx, "Client connected")
 
 		case client := <-h.Unregister:
 			h.mutex.Lock()
 			if _, ok := h.Clients[client.ID]; ok {
 				delete(h.Clients, client.ID)
-				close(client.Send)
+				close(client.done)
 			}
+			h.countClients()
 			h.mutex.Unlock()
-			log.Printf("Client %s disconnected", client.ID)
+			client.logger.InfoContext(client.ctx, "Client disconnected")
 
 		case message := <-h.Broadcast:
-			h.mutex.RLock()
+			h.mutex.Lock()
 			for _, client := range h.Clients {
 				select {
 				case client.Send <- message:
 				default:
-					close(client.Send)
+					// Too slow to keep up, its writer hangs up.
+					close(client.done)
 					delete(h.Clients, client.ID)
 				}
 			}
-			h.mutex.RUnlock()
+			h.countClients()
+			h.mutex.Unlock()
 		}
 	}
 }
 
+// countClients updates the connected clients metric. It must be called with
+// the mutex held.
+func (h *Hub) countClients() {
+	if h.owner != nil {
+		websocketClients.Set(float64(len(h.Clients)), h.owner.ID())
+	}
+}
+
 func (c *Client) WritePump() {
 	defer func() {
 		c.Connection.Close()
@@ -172,41 +345,96 @@ func (c *Client) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			if !ok {
+		case message := <-c.Send:
+			if !c.write(message) {
+				c.handler.sessions.keep(c.session, message)
 				return
 			}
 
-			err := websocket.JSON.Send(c.Connection, message)
-			if err != nil {
-				log.Printf("Error sending message to client %s: %v", c.ID, err)
-				return
+		case <-c.done:
+			c.keepUnsent()
+			return
+
+		case <-c.quit:
+			for {
+				select {
+				case message := <-c.Send:
+					if !c.write(message) {
+						c.handler.sessions.keep(c.session, message)
+						return
+					}
+				default:
+					return
+				}
 			}
 		}
 	}
 }
 
+// send queues message for the client. Once the hub has dropped the client
+// it is kept for the session to pick up if it is resumable, rather than
+// waiting for a writer that has gone.
+func (c *Client) send(message Message) {
+	select {
+	case c.Send <- message:
+	case <-c.done:
+		c.handler.sessions.keep(c.session, message)
+	}
+}
+
+// write sends message to the client and reports whether it was sent.
+func (c *Client) write(message Message) bool {
+	if err := websocket.JSON.Send(c.Connection, message); err != nil {
+		c.logger.WarnContext(c.ctx, "Error sending message", "type", message.Type, "error", err)
+		return false
+	}
+	return true
+}
+
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Hub.Unregister <- c
+		select {
+		case c.Hub.Unregister <- c:
+		case <-c.Hub.done:
+		}
 		c.Connection.Close()
+		c.keepUnsent()
 	}()
 
 	for {
 		var message Message
 		err := websocket.JSON.Receive(c.Connection, &message)
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			if err != io.EOF {
+				c.logger.WarnContext(c.ctx, "Error reading message", "error", err)
+			}
 			break
 		}
 
+		countMessage(message.Type)
+		if !c.allow() {
+			c.sendError(errRateLimited)
+			continue
+		}
 		switch message.Type {
 		case string(RequestAvailability):
 			c.handleAvailabilityRequest(message)
+		case string(RequestMonthAvailability):
+			c.handleMonthAvailabilityRequest(message)
 		case string(UpdateAvailaibilty):
+			if !c.admin {
+				c.sendError(errAdminOnly)
+				continue
+			}
 			c.handleUpdateEventsRequest(message)
+		case string(RequestChallenge):
+			c.handleChallengeRequest()
+		case string(CreateBooking):
+			c.handleWrite(message, c.handleCreateBookingRequest)
+		case string(JoinWaitlist):
+			c.handleWrite(message, c.handleJoinWaitlistRequest)
 		default:
-			c.Hub.Broadcast <- message
+			c.logger.DebugContext(c.ctx, "Ignoring unknown message", "type", message.Type)
 		}
 
 	}
@@ -217,174 +445,324 @@ func (c *Client) handleAvailabilityRequest(message Message) {
 	reqBytes, _ := json.Marshal(message.Payload)
 	var request AvailabilityRequest
 	if err := json.Unmarshal(reqBytes, &request); err != nil {
-		log.Printf("Error parsing availability request: %v", err)
+		c.logger.WarnContext(c.ctx, "Error parsing availability request", "error", err)
 		return
 	}
 
 	datePart := strings.Split(request.Date, "T")[0]
 
-	requestedDate, err := time.Parse("2006-01-02", datePart)
+	owner := c.Hub.owner
+
+	requestedDate, err := time.ParseInLocation("2006-01-02", datePart, owner.Location())
 	if err != nil {
-		log.Printf("Error parsing date: %v", err)
+		c.logger.WarnContext(c.ctx, "Error parsing date", "error", err)
 		return
 	}
 
-	handler := c.Hub.wsHandler
-
-	schedule := ScheduleConfig{
-		WeekdayHours: map[time.Weekday]BusinessHours{
-			time.Monday:    {StartTime: "08:00", EndTime: "17:00"},
-			time.Tuesday:   {StartTime: "08:30", EndTime: "17:00"},
-			time.Wednesday: {StartTime: "09:00", EndTime: "18:00"},
-			time.Thursday:  {StartTime: "08:00", EndTime: "17:00"},
-			time.Friday:    {StartTime: "08:00", EndTime: "17:30"},
-			time.Saturday:  {StartTime: "10:00", EndTime: "14:00"}, // Optional business hours on weekends
-			time.Sunday:    {StartTime: "00:00", EndTime: "00:00"}, // Closed on Sundays
-		},
-		DefaultHours: BusinessHours{
-			StartTime: "08:00",
-			EndTime:   "17:30", // Default fallback if no specific day is defined
-		},
-		BufferMinutes: 10, // 10-minute buffer before and after events
+	eventType, ok := owner.eventType(request.EventType)
+	if !ok {
+		c.logger.WarnContext(c.ctx, "Unknown event type", "event_type", request.EventType)
+		return
 	}
 
-	availableTimes := getAvailableTimesForDate(requestedDate, handler.events, schedule)
+	data := availabilityData(owner, requestedDate, eventType, time.Now())
+	data.Date = request.Date
 
 	response := Message{
-		Type: string(AvailabilityResponse),
-		Payload: AvailabilityResponseData{
-			Date:           request.Date,
-			AvailableTimes: availableTimes,
-		},
+		Type:    string(AvailabilityResponse),
+		Payload: data,
 	}
 
-	c.Send <- response
+	c.send(response)
+}
+
+// availabilityData is what the booking page is told about date: the free
+// times, the slots that can be booked and those with a waitlist.
+func availabilityData(owner calendarOwner, date time.Time, et EventType, now time.Time) AvailabilityResponseData {
+	availableTimes, slots := owner.availability(date, et, now)
+	data := AvailabilityResponseData{
+		Date:           date.Format("2006-01-02"),
+		EventType:      et.Slug,
+		AvailableTimes: availableTimes,
+		Slots:          slots,
+	}
+	if host, ok := owner.(*hostCalendar); ok {
+		data.Waitlist = host.waitlistSlots(date, et, now)
+	}
+	return data
+}
+
+// Availability works out the slots of the host or team with ownerID on
+// date ("2024-10-11") the way its booking page would, after fetching the
+// events around that day from the calendars of everyone involved. The
+// fetched events replace any cached ones, so it is meant for one-off checks
+// from the command line. It returns the time zone the slots are in.
+func (wsh *WebSocketHandler) Availability(ctx context.Context, ownerID, eventType, date string) (AvailabilityResponseData, *time.Location, error) {
+	owner, ok := wsh.lookupOwner(ownerID)
+	if !ok {
+		return AvailabilityResponseData{}, nil, fmt.Errorf("unknown host or team %q", ownerID)
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, owner.Location())
+	if err != nil {
+		return AvailabilityResponseData{}, nil, fmt.Errorf("invalid date %q", date)
+	}
+	et, ok := owner.eventType(eventType)
+	if !ok {
+		return AvailabilityResponseData{}, nil, fmt.Errorf("%w %q", errUnknownEventType, eventType)
+	}
+
+	var hosts []*hostCalendar
+	switch o := owner.(type) {
+	case *hostCalendar:
+		hosts = append(hosts, o)
+	case *teamCalendar:
+		hosts = append(hosts, o.members...)
+	}
+	for _, host := range hosts {
+		wsh.attachCalendar(ctx, host.id)
+	}
+	// A day either side covers buffers and time zones reaching past midnight.
+	owner.updateEvents(ctx, day.AddDate(0, 0, -1), day.AddDate(0, 0, 2))
+
+	return availabilityData(owner, day, et, time.Now()), owner.Location(), nil
+}
+
+// handleWrite handles a message that saves a booking, so shutdown waits for
+// it. Once the server is shutting down the client is asked to try again.
+func (c *Client) handleWrite(message Message, handle func(Message)) {
+	if !c.handler.writes.begin() {
+		c.send(Message{Type: string(BookingFailed), Payload: BookingErrorData{Error: errShuttingDown.Error()}})
+		return
+	}
+	defer c.handler.writes.end()
+	handle(message)
+}
+
+func (c *Client) handleCreateBookingRequest(message Message) {
+	reqBytes, _ := json.Marshal(message.Payload)
+	var request CreateBookingRequest
+	if err := json.Unmarshal(reqBytes, &request); err != nil {
+		c.logger.WarnContext(c.ctx, "Error parsing create booking request", "error", err)
+		return
+	}
+	request.Locale = c.locale
+
+	if err := c.checkBooking(request); err != nil {
+		c.send(Message{Type: string(BookingFailed), Payload: BookingErrorData{Error: err.Error()}})
+		return
+	}
+
+	owner := c.Hub.owner
+	booking, err := owner.createBooking(request, time.Now())
+	if err != nil {
+		reason := err.Error()
+		if !errors.Is(err, errInvalidBooking) && !errors.Is(err, errSlotUnavailable) {
+			c.logger.ErrorContext(c.ctx, "Error creating booking", "error", err)
+			reason = "Unable to create booking, please try again"
+		}
+		c.send(Message{Type: string(BookingFailed), Payload: BookingErrorData{Error: reason}})
+		return
+	}
+
+	start := booking.Start.In(owner.Location())
+	c.send(Message{
+		Type: string(BookingCreated),
+		Payload: BookingCreatedData{
+			ID:        booking.ID,
+			EventType: booking.EventType,
+			Date:      start.Format("2006-01-02"),
+			Start:     start.Format("15:04"),
+			End:       booking.End.In(owner.Location()).Format("15:04"),
+			Status:    booking.Status,
+		},
+	})
+	c.logger.InfoContext(c.ctx, "Booking created", "booking", booking.ID, "event_type", booking.EventType, "status", booking.Status)
+	c.handler.bookingCreated(c.ctx, booking)
+
+	// Let everyone looking at this calendar know the slot is gone.
+	c.Hub.broadcast(Message{Type: string(EventUpdated)})
 }
 
 func (c *Client) handleUpdateEventsRequest(message Message) {
 	reqBytes, _ := json.Marshal(message.Payload)
 	var request UpdateEventsRequest
 	if err := json.Unmarshal(reqBytes, &request); err != nil {
-		log.Printf("Error parsing update events request: %v", err)
+		c.logger.WarnContext(c.ctx, "Error parsing update events request", "error", err)
 		return
 	}
 
-	handler := c.Hub.wsHandler
+	owner := c.Hub.owner
 
-	startDate, _ := time.Parse("2006-01-02", request.StartDate)
-	endDate, _ := time.Parse("2006-01-02", request.EndDate)
+	startDate, _ := time.ParseInLocation("2006-01-02", request.StartDate, owner.Location())
+	endDate, _ := time.ParseInLocation("2006-01-02", request.EndDate, owner.Location())
 
-	calendars := utils.GetCalendars(handler.calendarService)
-	handler.events = utils.GetEvents(startDate.Format(time.RFC3339), endDate.Format(time.RFC3339), handler.calendarService, calendars)
+	owner.updateEvents(c.ctx, startDate, endDate)
 
 	response := Message{
 		Type:    string(EventUpdated),
 		Payload: nil,
 	}
 
-	c.Send <- response
+	c.send(response)
 }
 
-// Function to calculate available times
-func getAvailableTimesForDate(date time.Time, events []utils.EventData, config ScheduleConfig) []TimeSlot {
-	// Determine the business hours for the given date
-	hours, ok := config.WeekdayHours[date.Weekday()]
+// hoursFor returns the opening windows on date: the date override if there
+// is one, otherwise the weekday hours.
+func (s ScheduleConfig) hoursFor(date time.Time) []BusinessHours {
+	if hours, ok := s.DateOverrides[date.Format("2006-01-02")]; ok {
+		return hours
+	}
+	hours, ok := s.WeekdayHours[date.Weekday()]
 	if !ok {
-		hours = config.DefaultHours
+		hours = s.DefaultHours
 	}
+	return []BusinessHours{hours}
+}
 
-	// Parse business start and end times
-	businessStart, _ := time.Parse("15:04", hours.StartTime)
-	businessEnd, _ := time.Parse("15:04", hours.EndTime)
-
-	// Apply the date to the business start and end times
-	businessStart = time.Date(date.Year(), date.Month(), date.Day(), businessStart.Hour(), businessStart.Minute(), 0, 0, date.Location())
-	businessEnd = time.Date(date.Year(), date.Month(), date.Day(), businessEnd.Hour(), businessEnd.Minute(), 0, 0, date.Location())
-
-	// Filter events for the day
-	var dayEvents []utils.EventData
-	for _, event := range events {
-		if event.StartTime.Year() == date.Year() &&
-			event.StartTime.Month() == date.Month() &&
-			event.StartTime.Day() == date.Day() {
-			dayEvents = append(dayEvents, event)
+// openingHours returns the opening windows on date as a set of intervals.
+// Hours are validated when they are loaded or saved, so a window that still
+// fails to parse is logged and left closed rather than guessed at.
+func (s ScheduleConfig) openingHours(date time.Time) availability.Set {
+	var windows []availability.Interval
+	for _, hours := range s.hoursFor(date) {
+		w, err := availability.Window(date, hours.StartTime, hours.EndTime)
+		if err != nil {
+			slog.Error("Invalid opening hours", "date", date.Format("2006-01-02"), "error", err)
+			continue
 		}
+		windows = append(windows, w)
 	}
-	// fmt.Printf("%# v\n", pretty.Formatter(events))
-	// fmt.Printf("%# v\n", pretty.Formatter(dayEvents))
+	return availability.NewSet(windows...)
+}
 
-	// Sort events by start time
-	sort.Slice(dayEvents, func(i, j int) bool {
-		return dayEvents[i].StartTime.Before(dayEvents[j].StartTime)
-	})
+// freeTime returns the time on date within the opening windows that no
+// event overlaps. Events are widened by the buffers first: a meeting has to
+// end its after-buffer before an event starts, and can only start its
+// before-buffer after an event ends.
+func freeTime(date time.Time, events []utils.EventData, config ScheduleConfig) availability.Set {
+	busy := make([]availability.Interval, 0, len(events))
+	for _, event := range events {
+		busy = append(busy, availability.Interval{Start: event.StartTime, End: event.EndTime}.Grow(
+			time.Duration(config.BufferAfterMinutes)*time.Minute,
+			time.Duration(config.BufferBeforeMinutes)*time.Minute,
+		))
+	}
+	return config.openingHours(date).Subtract(availability.NewSet(busy...)).In(date.Location())
+}
 
-	// Find available slots
-	var availableSlots []TimeSlot
-	currentTime := businessStart
-
-	for _, event := range dayEvents {
-		// Apply buffer to event start and end times
-		eventStart := event.StartTime.Add(-time.Duration(config.BufferMinutes) * time.Minute)
-		eventEnd := event.EndTime.Add(time.Duration(config.BufferMinutes) * time.Minute)
-
-		// Check for available slot before the event
-		if currentTime.Before(eventStart) {
-			availableSlots = append(availableSlots, TimeSlot{
-				Start: currentTime.Format("15:04"),
-				End:   eventStart.Format("15:04"),
-			})
-		}
+// getAvailableTimesForDate returns the free time on date as "15:04" slots.
+func getAvailableTimesForDate(date time.Time, events []utils.EventData, config ScheduleConfig) []TimeSlot {
+	return timeSlots(freeTime(date, events, config), date.Location())
+}
 
-		// Move current time to event end if it's later than the current time
-		if eventEnd.After(currentTime) {
-			currentTime = eventEnd
-		}
+// timeSlots formats intervals as "15:04" slots in loc.
+func timeSlots(intervals []availability.Interval, loc *time.Location) []TimeSlot {
+	if len(intervals) == 0 {
+		return nil
 	}
-
-	// Add remaining time until business end if available
-	if currentTime.Before(businessEnd) {
-		availableSlots = append(availableSlots, TimeSlot{
-			Start: currentTime.Format("15:04"),
-			End:   businessEnd.Format("15:04"),
-		})
+	slots := make([]TimeSlot, 0, len(intervals))
+	for _, i := range intervals {
+		slots = append(slots, TimeSlot{Start: i.Start.In(loc).Format("15:04"), End: i.End.In(loc).Format("15:04")})
 	}
-
-	return availableSlots
+	return slots
 }
 
-func (wsh *WebSocketHandler) refreshEvents() {
+// refreshEvents reloads every host's calendar every 15 minutes until ctx is
+// done.
+func (wsh *WebSocketHandler) refreshEvents(ctx context.Context) {
 	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			wsh.updateEvents()
+			ctx := logging.WithRequestID(ctx, logging.NewRequestID())
+			for _, host := range wsh.hosts {
+				host.updateEvents(ctx, time.Now().AddDate(0, 0, -30), time.Now().AddDate(0, 0, 60))
+			}
 		}
 	}
 }
 
-func (wsh *WebSocketHandler) updateEvents() {
-
-	startDay := time.Now().AddDate(0, 0, -30).Format(time.RFC3339)
-	endDay := time.Now().AddDate(0, 0, 60).Format(time.RFC3339)
-
-	calendars := utils.GetCalendars(wsh.calendarService)
-	wsh.events = utils.GetEvents(startDay, endDay, wsh.calendarService, calendars)
-}
+func (wsh *WebSocketHandler) HandleWS(ws *websocket.Conn, owner calendarOwner, p Protection) {
+	r := ws.Request()
+	session, missed, resumed, err := wsh.sessions.attach(r.URL.Query().Get("session"), owner.ID(), time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting WebSocket session", "error", err)
+		return
+	}
+	defer func() { wsh.sessions.detach(session, time.Now()) }()
 
-func (wsh *WebSocketHandler) HandleWS(ws *websocket.Conn) {
 	client := &Client{
-		ID:         ws.RemoteAddr().String(),
+		ID:         session.token,
 		Connection: ws,
-		Hub:        wsh.hub,
+		Hub:        owner.Hub(),
 		Send:       make(chan Message, 256),
+		handler:    wsh,
+		ctx:        r.Context(),
+		logger:     slog.Default().With("remote", r.RemoteAddr, "owner", owner.ID()),
+		ip:         ratelimit.ClientIP(r),
+		admin:      p.IsAdmin != nil && p.IsAdmin(r),
+		limiter:    session.limiter,
+		protection: p,
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+		session:    session,
+		locale:     i18n.Match(r.Header.Get("Accept-Language")).Tag,
+	}
+	client.Send <- Message{Type: string(SessionStarted), Payload: SessionData{Token: session.token, Resumed: resumed}}
+	for _, m := range missed {
+		client.Send <- m
 	}
 
-	wsh.hub.Register <- client
+	select {
+	case owner.Hub().Register <- client:
+	case <-owner.Hub().done:
+		client.keepUnsent()
+		refuse(ws)
+		return
+	}
 
 	go client.WritePump()
 	client.ReadPump()
 }
 
-func (wsh *WebSocketHandler) Handler() http.Handler {
-	return websocket.Handler(wsh.HandleWS)
+var errOriginNotAllowed = errors.New("origin not allowed")
+
+// Handler serves /ws/{host}, where host is a host or team id. Without one in
+// the path the default host is used. Handshakes from origins p rejects get
+// 403, so other sites cannot use a visitor's browser to book.
+func (wsh *WebSocketHandler) Handler(p Protection) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := wsh.lookupOwner(r.PathValue("host"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		websocket.Server{
+			Handshake: func(config *websocket.Config, r *http.Request) error {
+				if p.AllowOrigin != nil && !p.AllowOrigin(r) {
+					slog.WarnContext(r.Context(), "WebSocket origin refused", "origin", r.Header.Get("Origin"))
+					return errOriginNotAllowed
+				}
+				var err error
+				config.Origin, err = websocket.Origin(config, r)
+				return err
+			},
+			Handler: func(ws *websocket.Conn) {
+				wsh.HandleWS(ws, owner, p)
+			},
+		}.ServeHTTP(w, r)
+	})
+}
+
+// lookupOwner finds a host or team by id, falling back to the default host
+// for an empty id.
+func (wsh *WebSocketHandler) lookupOwner(id string) (calendarOwner, bool) {
+	if id == "" {
+		id = wsh.defaultHost
+	}
+	owner, ok := wsh.owners[id]
+	return owner, ok
 }
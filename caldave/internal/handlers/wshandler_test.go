@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/utils"
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetAvailableTimesForDate(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+	date := at(london, "00:00") // A Wednesday
+	overrides := overrideHours([]config.Override{
+		{Date: "2024-11-13", Hours: []config.Hours{{Start: "13:00", End: "17:00"}, {Start: "09:00", End: "12:00"}}},
+		{Date: "2024-12-24", Until: "2025-01-02"},
+	})
+
+	tests := []struct {
+		name     string
+		date     time.Time
+		schedule ScheduleConfig
+		events   []utils.EventData
+		expected []TimeSlot
+	}{
+		{
+			name:     "Weekday hours",
+			date:     date,
+			schedule: openHours("09:00", "17:00"),
+			expected: []TimeSlot{{Start: "09:00", End: "17:00"}},
+		},
+		{
+			name:     "Events after closing do not extend the day",
+			date:     date,
+			schedule: openHours("09:00", "17:00"),
+			events: []utils.EventData{
+				{StartTime: at(london, "18:00"), EndTime: at(london, "19:00")},
+			},
+			expected: []TimeSlot{{Start: "09:00", End: "17:00"}},
+		},
+		{
+			name:     "Events from the day before still block the morning",
+			date:     date,
+			schedule: openHours("09:00", "17:00"),
+			events: []utils.EventData{
+				{StartTime: date.Add(-2 * time.Hour), EndTime: at(london, "10:00")},
+			},
+			expected: []TimeSlot{{Start: "10:00", End: "17:00"}},
+		},
+		{
+			name:     "Override with a lunch break",
+			date:     date,
+			schedule: ScheduleConfig{DefaultHours: BusinessHours{StartTime: "08:00", EndTime: "18:00"}, DateOverrides: overrides},
+			events: []utils.EventData{
+				{StartTime: at(london, "14:00"), EndTime: at(london, "15:00")},
+			},
+			expected: []TimeSlot{
+				{Start: "09:00", End: "12:00"},
+				{Start: "13:00", End: "14:00"},
+				{Start: "15:00", End: "17:00"},
+			},
+		},
+		{
+			name:     "Closure range",
+			date:     time.Date(2024, 12, 31, 0, 0, 0, 0, london),
+			schedule: ScheduleConfig{DefaultHours: BusinessHours{StartTime: "08:00", EndTime: "18:00"}, DateOverrides: overrides},
+			expected: nil,
+		},
+		{
+			name:     "Day after the closure uses weekday hours",
+			date:     time.Date(2025, 1, 3, 0, 0, 0, 0, london),
+			schedule: ScheduleConfig{DefaultHours: BusinessHours{StartTime: "08:00", EndTime: "18:00"}, DateOverrides: overrides},
+			expected: []TimeSlot{{Start: "08:00", End: "18:00"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getAvailableTimesForDate(tt.date, tt.events, tt.schedule)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("getAvailableTimesForDate() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+	wsh := &WebSocketHandler{}
+	session, _, _, err := wsh.sessions.attach("", "dave", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	c := &Client{
+		ID:      session.token,
+		Hub:     hub,
+		Send:    make(chan Message, 1),
+		handler: wsh,
+		ctx:     ctx,
+		logger:  slog.Default(),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		session: session,
+	}
+	hub.Register <- c
+	hub.Broadcast <- Message{Type: string(EventUpdated)} // Fills its buffer
+	hub.Broadcast <- Message{Type: string(EventUpdated)}
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the slow client to be dropped")
+	}
+
+	// A reply racing with the drop must not panic, and waits for a resume.
+	c.send(Message{Type: string(BookingCreated)})
+	wsh.sessions.detach(session, time.Now())
+	_, missed, resumed, err := wsh.sessions.attach(session.token, "dave", time.Now())
+	if err != nil || !resumed || len(missed) != 1 || missed[0].Type != string(BookingCreated) {
+		t.Errorf("Expected the reply to be kept for the session, got %v, %v and %v", missed, resumed, err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package i18n holds the message catalogs the pages and emails are
+// translated with, and picks one for a visitor from their Accept-Language
+// header.
+package i18n
+
+import (
+	"cmp"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//go:embed locales/*.json
+var files embed.FS
+
+// DefaultTag is the locale used when a visitor asks for none we have, and
+// for messages a catalog is missing.
+const DefaultTag = "en"
+
+// scriptPrefix marks the messages used by the booking page's script. They
+// use {name} placeholders rather than fmt verbs.
+const scriptPrefix = "js."
+
+// Locale is one message catalog along with how it writes dates.
+type Locale struct {
+	Tag            string            `json:"-"`              // Language code, such as "de"
+	Name           string            `json:"name"`           // Name of the language in itself
+	Weekdays       [7]string         `json:"weekdays"`       // Abbreviated, starting on Sunday like time.Weekday
+	Months         [12]string        `json:"months"`         // Abbreviated, starting in January
+	DateTimeLayout string            `json:"dateTimeLayout"` // time.Format layout, with {weekday} and {month} for the names above
+	Messages       map[string]string `json:"messages"`
+
+	fallback *Locale
+}
+
+var locales = map[string]*Locale{}
+
+func init() {
+	names, err := fs.Glob(files, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range names {
+		data, err := files.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		l := &Locale{Tag: strings.TrimSuffix(path.Base(name), ".json")}
+		if err := json.Unmarshal(data, l); err != nil {
+			panic(fmt.Errorf("reading %s: %w", name, err))
+		}
+		locales[l.Tag] = l
+	}
+	def, ok := locales[DefaultTag]
+	if !ok {
+		panic("i18n: no " + DefaultTag + " catalog")
+	}
+	for _, l := range locales {
+		if l != def {
+			l.fallback = def
+		}
+	}
+}
+
+// Tags lists the locales there are catalogs for.
+func Tags() []string {
+	tags := make([]string, 0, len(locales))
+	for tag := range locales {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// Get returns the locale for tag, such as one saved with a booking, or the
+// default locale if there is none.
+func Get(tag string) *Locale {
+	if l, ok := locales[base(tag)]; ok {
+		return l
+	}
+	return locales[DefaultTag]
+}
+
+// Match picks the locale the visitor prefers most out of those in an
+// Accept-Language header, such as "de-CH, de;q=0.9, en;q=0.8". Regional
+// variants get the catalog of their language.
+func Match(acceptLanguage string) *Locale {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag != "" && tag != "*" && q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	slices.SortStableFunc(choices, func(a, b choice) int { return cmp.Compare(b.q, a.q) })
+	for _, c := range choices {
+		if l, ok := locales[base(c.tag)]; ok {
+			return l
+		}
+	}
+	return locales[DefaultTag]
+}
+
+// base returns the language of a tag such as "pt-BR", in lower case.
+func base(tag string) string {
+	lang, _, _ := strings.Cut(tag, "-")
+	return strings.ToLower(strings.TrimSpace(lang))
+}
+
+// T translates the message with key, formatting args into it like
+// fmt.Sprintf. Messages missing from the catalog come from the default
+// locale, and failing that the key is returned so the gap is easy to spot.
+func (l *Locale) T(key string, args ...any) string {
+	msg, ok := l.message(key)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+func (l *Locale) message(key string) (string, bool) {
+	if msg, ok := l.Messages[key]; ok {
+		return msg, true
+	}
+	if l.fallback != nil {
+		return l.fallback.message(key)
+	}
+	return "", false
+}
+
+// FormatDateTime writes t, in its own location, the way the locale writes
+// dates and times.
+func (l *Locale) FormatDateTime(t time.Time) string {
+	return strings.NewReplacer(
+		"{weekday}", l.Weekdays[t.Weekday()],
+		"{month}", l.Months[t.Month()-1],
+	).Replace(t.Format(l.DateTimeLayout))
+}
+
+// WeekdayInitials heads the columns of a calendar, starting on Monday.
+func (l *Locale) WeekdayInitials() []string {
+	initials := make([]string, 0, 7)
+	for i := range 7 {
+		name := l.Weekdays[(i+1)%7]
+		r, _ := utf8.DecodeRuneInString(name)
+		initials = append(initials, string(r))
+	}
+	return initials
+}
+
+// Script returns the messages the booking page's script uses, with the
+// default locale filling any gaps, keyed without their "js." prefix.
+func (l *Locale) Script() map[string]string {
+	messages := make(map[string]string)
+	for loc := l; loc != nil; loc = loc.fallback {
+		for key, msg := range loc.Messages {
+			if name, ok := strings.CutPrefix(key, scriptPrefix); ok {
+				if _, seen := messages[name]; !seen {
+					messages[name] = msg
+				}
+			}
+		}
+	}
+	return messages
+}
@@ -0,0 +1,86 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		tag    string
+	}{
+		{header: "", tag: "en"},
+		{header: "de", tag: "de"},
+		{header: "de-AT", tag: "de"},
+		{header: "fr-FR, de;q=0.8, en;q=0.5", tag: "de"},
+		{header: "en;q=0.4, DE-ch;q=0.9", tag: "de"},
+		{header: "de;q=0, en", tag: "en"},
+		{header: "*", tag: "en"},
+		{header: "fr", tag: "en"},
+		{header: "de;q=oops, en", tag: "en"},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.header).Tag; got != tt.tag {
+			t.Errorf("Match(%q) = %q, expected %q", tt.header, got, tt.tag)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	de := Get("de")
+	if got := de.T("email.greeting", "Ada"); got != "Hallo Ada," {
+		t.Errorf("Unexpected greeting %q", got)
+	}
+	partial := &Locale{Tag: "xx", fallback: Get(DefaultTag)}
+	if got := partial.T("email.greeting", "Ada"); got != "Hi Ada," {
+		t.Errorf("Expected a gap to be filled from English, got %q", got)
+	}
+	if got := de.T("no.such.key"); got != "no.such.key" {
+		t.Errorf("Expected a missing key to come back as is, got %q", got)
+	}
+}
+
+func TestFormatDateTime(t *testing.T) {
+	ts := time.Date(2024, time.October, 11, 14, 30, 0, 0, time.UTC)
+	if got := Get("en").FormatDateTime(ts); got != "Fri 11 Oct 2024 14:30 UTC" {
+		t.Errorf("Unexpected English date %q", got)
+	}
+	if got := Get("de").FormatDateTime(ts); got != "Fr, 11. Okt. 2024, 14:30 UTC" {
+		t.Errorf("Unexpected German date %q", got)
+	}
+	if got := strings.Join(Get("de").WeekdayInitials(), ""); got != "MDMDFSS" {
+		t.Errorf("Unexpected weekday initials %q", got)
+	}
+}
+
+// TestCatalogs checks every catalog translates every message, with the same
+// arguments as the English one.
+func TestCatalogs(t *testing.T) {
+	en := Get(DefaultTag)
+	for _, tag := range Tags() {
+		l := Get(tag)
+		for key, msg := range en.Messages {
+			translated, ok := l.Messages[key]
+			if !ok {
+				t.Errorf("%s is missing %s", tag, key)
+				continue
+			}
+			if strings.Count(translated, "%") != strings.Count(msg, "%") {
+				t.Errorf("%s %s takes different arguments: %q", tag, key, translated)
+			}
+		}
+		for key := range l.Messages {
+			if _, ok := en.Messages[key]; !ok {
+				t.Errorf("%s has %s, which English does not", tag, key)
+			}
+		}
+		if l.DateTimeLayout == "" || l.Weekdays[6] == "" || l.Months[11] == "" {
+			t.Errorf("%s does not say how to write dates", tag)
+		}
+	}
+	if got := Get("de").Script()["fullyBooked"]; got != "Ausgebucht" {
+		t.Errorf("Unexpected script message %q", got)
+	}
+}
@@ -0,0 +1,216 @@
+// Package jobs runs background work at a set time, such as reminder emails.
+// Jobs are kept in the store, so they survive restarts, and failed jobs are
+// retried with exponential backoff.
+package jobs
+
+import (
+	"caldave/internal/logging"
+	"caldave/internal/metrics"
+	"caldave/internal/store"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+var jobsRun = metrics.Default.NewCounter("caldave_jobs_run_total",
+	"Background jobs run, by kind and result.", "kind", "result")
+
+// Handler runs a job. Returning an error retries the job later.
+type Handler func(ctx context.Context, job store.Job) error
+
+// Scheduler runs jobs of the kinds it has handlers for once they are due.
+// Set its fields and register handlers before calling Run.
+type Scheduler struct {
+	MaxAttempts int           // Including the first
+	Backoff     time.Duration // Wait before the first retry, doubled for each one after
+	MaxBackoff  time.Duration
+	Retention   time.Duration // How long finished jobs are kept
+	PollEvery   time.Duration // Longest wait between looking for due jobs
+
+	store *store.Store
+	wake  chan struct{}
+
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+}
+
+func New(st *store.Store) *Scheduler {
+	return &Scheduler{
+		MaxAttempts: 5,
+		Backoff:     time.Minute,
+		MaxBackoff:  time.Hour,
+		Retention:   7 * 24 * time.Hour,
+		PollEvery:   time.Minute,
+		store:       st,
+		wake:        make(chan struct{}, 1),
+		handlers:    make(map[string]Handler),
+	}
+}
+
+// Handle registers h to run jobs of the given kind.
+func (s *Scheduler) Handle(kind string, h Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[kind] = h
+}
+
+// Schedule runs a job of the given kind about ref at runAt, replacing any
+// pending job of the same kind for ref. payload is marshalled as the job's
+// payload; it may be nil.
+func (s *Scheduler) Schedule(kind, ref string, runAt time.Time, payload any) (store.Job, error) {
+	j := store.Job{Kind: kind, Ref: ref, RunAt: runAt}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return store.Job{}, fmt.Errorf("encoding %s job: %w", kind, err)
+		}
+		j.Payload = raw
+	}
+	j, err := s.store.ScheduleJob(j)
+	if err != nil {
+		return store.Job{}, err
+	}
+	s.wakeUp()
+	return j, nil
+}
+
+// Cancel cancels the pending jobs about ref of the given kinds, or all of
+// them if no kinds are given.
+func (s *Scheduler) Cancel(ref string, kinds ...string) error {
+	_, err := s.store.CancelJobs(ref, kinds...)
+	return err
+}
+
+// Run runs jobs as they become due until ctx is done. A job that is running
+// when ctx is done is given the cancelled ctx and, if it fails, is tried
+// again after the next start without counting the attempt.
+func (s *Scheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+		}
+		now := time.Now()
+		s.runDue(ctx, now)
+		if err := s.store.PruneJobs(now.Add(-s.Retention)); err != nil {
+			slog.ErrorContext(ctx, "Error pruning jobs", "error", err)
+		}
+		timer.Stop()
+		timer.Reset(s.untilNext(time.Now()))
+	}
+}
+
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// untilNext returns how long to wait for the next pending job, at most
+// PollEvery.
+func (s *Scheduler) untilNext(now time.Time) time.Duration {
+	wait := s.PollEvery
+	for _, j := range s.store.Jobs(func(j store.Job) bool { return j.Status == store.JobPending }) {
+		wait = min(wait, max(j.RunAt.Sub(now), 0))
+	}
+	return wait
+}
+
+// runDue runs the pending jobs due at now, earliest first, and returns how
+// many it ran.
+func (s *Scheduler) runDue(ctx context.Context, now time.Time) int {
+	due := s.store.Jobs(func(j store.Job) bool {
+		return j.Status == store.JobPending && !j.RunAt.After(now)
+	})
+	slices.SortStableFunc(due, func(a, b store.Job) int { return a.RunAt.Compare(b.RunAt) })
+	n := 0
+	for _, j := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		s.run(ctx, j)
+		n++
+	}
+	return n
+}
+
+// run runs j and records the outcome.
+func (s *Scheduler) run(ctx context.Context, j store.Job) {
+	ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	s.mutex.RLock()
+	h, ok := s.handlers[j.Kind]
+	s.mutex.RUnlock()
+
+	var err error
+	if ok {
+		err = s.call(ctx, h, j)
+	} else {
+		err = fmt.Errorf("no handler for %s jobs", j.Kind)
+	}
+	if err != nil && ctx.Err() != nil {
+		return // Shutting down, run it again next time
+	}
+
+	result := "done"
+	_, updateErr := s.store.UpdateJob(j.ID, func(job *store.Job) error {
+		if !job.RunAt.Equal(j.RunAt) || (job.Status != store.JobPending && err != nil) {
+			result = "superseded" // Rescheduled or cancelled while it ran
+			return nil
+		}
+		job.Attempts++
+		job.Error = ""
+		switch {
+		case err == nil:
+			job.Status = store.JobDone
+		case !ok || job.Attempts >= s.MaxAttempts:
+			job.Status = store.JobFailed
+			job.Error = err.Error()
+			result = "failed"
+		default:
+			job.Error = err.Error()
+			job.RunAt = time.Now().Add(s.backoff(job.Attempts))
+			result = "retry"
+		}
+		return nil
+	})
+	if updateErr != nil {
+		slog.ErrorContext(ctx, "Error saving job", "job", j.ID, "kind", j.Kind, "error", updateErr)
+	}
+	jobsRun.Inc(j.Kind, result)
+
+	switch result {
+	case "retry":
+		slog.WarnContext(ctx, "Job failed, retrying", "job", j.ID, "kind", j.Kind, "ref", j.Ref, "error", err)
+	case "failed":
+		slog.ErrorContext(ctx, "Giving up on job", "job", j.ID, "kind", j.Kind, "ref", j.Ref, "error", err)
+	}
+}
+
+// call runs h, turning a panic into an error so one bad job cannot stop the
+// others.
+func (s *Scheduler) call(ctx context.Context, h Handler, j store.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, j)
+}
+
+// backoff returns the wait before the retry following attempt.
+func (s *Scheduler) backoff(attempt int) time.Duration {
+	wait := s.Backoff
+	for i := 1; i < attempt && wait < s.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.MaxBackoff)
+}
@@ -0,0 +1,153 @@
+package jobs
+
+import (
+	"caldave/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	st, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(st)
+	s.MaxAttempts = 2
+
+	var ran []string
+	s.Handle("remind", func(ctx context.Context, j store.Job) error {
+		ran = append(ran, j.Ref)
+		return nil
+	})
+	s.Handle("flaky", func(ctx context.Context, j store.Job) error {
+		return errors.New("mail server down")
+	})
+
+	ctx := context.Background()
+	now := time.Now()
+	if _, err := s.Schedule("remind", "a", now.Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Schedule("remind", "b", now.Add(-time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Schedule("remind", "c", now.Add(-time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	flaky, err := s.Schedule("flaky", "d", now, map[string]string{"to": "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cancel("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scheduling the same kind for the same ref again moves the job.
+	moved, err := s.Schedule("remind", "a", now.Add(-time.Second), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := st.Jobs(func(j store.Job) bool { return j.Ref == "a" }); len(pending) != 1 || pending[0].ID != moved.ID {
+		t.Fatalf("Expected one job for a, got %+v", pending)
+	}
+
+	// Jobs survive a restart.
+	st, err = store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.store = st
+
+	if n := s.runDue(ctx, now); n != 3 {
+		t.Errorf("Expected 3 jobs to run, ran %d", n)
+	}
+	if len(ran) != 2 || ran[0] != "b" || ran[1] != "a" {
+		t.Errorf("Expected b and a to be reminded, got %v", ran)
+	}
+
+	job := func(id string) store.Job {
+		t.Helper()
+		jobs := st.Jobs(func(j store.Job) bool { return j.ID == id })
+		if len(jobs) != 1 {
+			t.Fatalf("Expected job %s", id)
+		}
+		return jobs[0]
+	}
+	retry := job(flaky.ID)
+	if retry.Status != store.JobPending || retry.Attempts != 1 || !retry.RunAt.After(now) || retry.Error == "" {
+		t.Fatalf("Expected the flaky job to be retried, got %+v", retry)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(retry.Payload, &payload); err != nil || payload["to"] != "ada@example.com" {
+		t.Errorf("Unexpected payload %s", retry.Payload)
+	}
+	if n := s.runDue(ctx, retry.RunAt); n != 1 {
+		t.Errorf("Expected the retry to run, ran %d", n)
+	}
+	if j := job(flaky.ID); j.Status != store.JobFailed || j.Attempts != 2 {
+		t.Errorf("Expected the flaky job to fail after 2 attempts, got %+v", j)
+	}
+	if j := job(moved.ID); j.Status != store.JobDone {
+		t.Errorf("Expected the reminder to be done, got %s", j.Status)
+	}
+
+	if err := st.PruneJobs(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if left := st.Jobs(nil); len(left) != 0 {
+		t.Errorf("Expected finished jobs to be pruned, got %+v", left)
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(st)
+	ran := make(chan string, 1)
+	s.Handle("remind", func(ctx context.Context, j store.Job) error {
+		ran <- j.Ref
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	if _, err := s.Schedule("remind", "a", time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ref := <-ran:
+		if ref != "a" {
+			t.Errorf("Expected a to run, got %s", ref)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the due job to run")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return once the context is done")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := &Scheduler{Backoff: time.Minute, MaxBackoff: 5 * time.Minute}
+	for attempt, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 4: 5 * time.Minute, 10: 5 * time.Minute} {
+		if got := s.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestRequestIDIsLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo).With("host", "dave")
+
+	logger.DebugContext(context.Background(), "hidden")
+	logger.InfoContext(WithRequestID(context.Background(), "abc123"), "booking created")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "booking created" || line["request_id"] != "abc123" || line["host"] != "dave" {
+		t.Errorf("Expected the message with its request ID and host, got %v", line)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected slog.Level
+		wantErr  bool
+	}{
+		{input: "debug", expected: slog.LevelDebug},
+		{input: "INFO", expected: slog.LevelInfo},
+		{input: " warn ", expected: slog.LevelWarn},
+		{input: "error", expected: slog.LevelError},
+		{input: "loud", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			level, err := ParseLevel(tt.input)
+			if (err != nil) != tt.wantErr || (!tt.wantErr && level != tt.expected) {
+				t.Errorf("ParseLevel(%q) = %v, %v", tt.input, level, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+// Package metrics collects counters, gauges and histograms and serves them
+// in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry caldave's own metrics are registered with.
+var Default = NewRegistry()
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Handler serves every metric in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.mutex.Lock()
+		metrics := slices.Clone(r.metrics)
+		r.mutex.Unlock()
+
+		buf := bufio.NewWriter(w)
+		for _, m := range metrics {
+			m.write(buf)
+		}
+		if err := buf.Flush(); err != nil {
+			slog.ErrorContext(req.Context(), "Error writing metrics", "error", err)
+		}
+	})
+}
+
+// family is the part common to every kind of metric: its name, help text
+// and label names, and one series per combination of label values.
+type family[S any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mutex  sync.Mutex
+	series map[string]*S
+	values map[string][]string // Label values by series key
+}
+
+func newFamily[S any](name, help, kind string, labels []string) *family[S] {
+	return &family[S]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*S),
+		values: make(map[string][]string),
+	}
+}
+
+// with returns the series for labelValues, creating it with init. It must
+// be called with the mutex held.
+func (f *family[S]) with(labelValues []string, init func() *S) *S {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = init()
+		f.series[key] = s
+		f.values[key] = slices.Clone(labelValues)
+	}
+	return s
+}
+
+// write writes the family's header, then calls fn for every series, sorted
+// by label values, with the mutex held.
+func (f *family[S]) write(w *bufio.Writer, fn func(values []string, s *S)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fn(f.values[key], f.series[key])
+	}
+}
+
+// Counter is a value that only goes up, partitioned by labels.
+type Counter struct {
+	f *family[float64]
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{f: newFamily[float64](name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the series for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series for labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.f.mutex.Lock()
+	defer c.f.mutex.Unlock()
+	*c.f.with(labelValues, newValue) += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.f.write(w, func(values []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.f.name, formatLabels(c.f.labels, values), formatFloat(*v))
+	})
+}
+
+// Gauge is a value that goes up and down, partitioned by labels.
+type Gauge struct {
+	f *family[float64]
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{f: newFamily[float64](name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// Set sets the series for labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	*g.f.with(labelValues, newValue) = v
+}
+
+// Add adds v, which may be negative, to the series for labelValues.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	*g.f.with(labelValues, newValue) += v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.f.write(w, func(values []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.f.name, formatLabels(g.f.labels, values), formatFloat(*v))
+	})
+}
+
+// Histogram counts observations into buckets, partitioned by labels.
+type Histogram struct {
+	f       *family[histogramSeries]
+	buckets []float64
+}
+
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// which must be sorted, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	h := &Histogram{f: newFamily[histogramSeries](name, help, "histogram", labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v in the series for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+	s := h.f.with(labelValues, func() *histogramSeries {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets))}
+	})
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	names := append(slices.Clone(h.f.labels), "le")
+	h.f.write(w, func(values []string, s *histogramSeries) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := formatLabels(names, append(slices.Clone(values), formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, formatLabels(names, append(slices.Clone(values), "+Inf")), s.count)
+		labels := formatLabels(h.f.labels, values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.f.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.f.name, labels, s.count)
+	})
+}
+
+func newValue() *float64 {
+	return new(float64)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
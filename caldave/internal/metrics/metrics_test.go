@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests served.", "route", "status")
+	clients := r.NewGauge("clients", "Connected clients.")
+	latency := r.NewHistogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+
+	requests.Inc("GET /book/{host}", "200")
+	requests.Add(2, "GET /book/{host}", "200")
+	requests.Inc(`say "hi"`, "404")
+	clients.Add(3)
+	clients.Add(-1)
+	latency.Observe(0.05, "GET /")
+	latency.Observe(0.1, "GET /")
+	latency.Observe(5, "GET /")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="GET /book/{host}",status="200"} 3
+requests_total{route="say \"hi\"",status="404"} 1
+# HELP clients Connected clients.
+# TYPE clients gauge
+clients 2
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="GET /",le="0.1"} 2
+latency_seconds_bucket{route="GET /",le="1"} 2
+latency_seconds_bucket{route="GET /",le="+Inf"} 3
+latency_seconds_sum{route="GET /"} 5.15
+latency_seconds_count{route="GET /"} 3
+`
+	if got := rec.Body.String(); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the Prometheus content type, got %q", ct)
+	}
+}
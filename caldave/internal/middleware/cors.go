@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginList is the set of other sites allowed to call the API from a
+// browser and to open WebSockets. Pages served by caldave itself are always
+// allowed.
+type OriginList struct {
+	any     bool
+	origins map[string]bool
+}
+
+// NewOriginList parses origins such as "https://example.com" or
+// "http://localhost:3000". "*" allows every origin.
+func NewOriginList(origins []string) (*OriginList, error) {
+	l := &OriginList{origins: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+			continue
+		case "*":
+			l.any = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return nil, fmt.Errorf("invalid origin %q, use scheme://host[:port]", origin)
+		}
+		l.origins[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+	return l, nil
+}
+
+// Allows reports whether a request from origin to host may go ahead. A
+// missing origin is not a cross-site browser request, so it is allowed.
+func (l *OriginList) Allows(origin, host string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, host) {
+		return true
+	}
+	return l.any || l.origins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// AllowsRequest is Allows for the request's Origin header and Host.
+func (l *OriginList) AllowsRequest(r *http.Request) bool {
+	return l.Allows(r.Header.Get("Origin"), r.Host)
+}
+
+// CORS adds CORS headers to responses for allowed origins and answers
+// preflight requests, turning away those from other origins. WebSocket
+// upgrades are left to the handshake, which checks the origin itself.
+func CORS(origins *OriginList, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || r.Header.Get("Upgrade") == "websocket" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := origins.AllowsRequest(r)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginListAllows(t *testing.T) {
+	list, err := NewOriginList([]string{"https://example.com", "http://localhost:3000/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		origin   string
+		expected bool
+	}{
+		{name: "No origin", origin: "", expected: true},
+		{name: "Same origin", origin: "https://caldave.example.org", expected: true},
+		{name: "Listed", origin: "https://example.com", expected: true},
+		{name: "Listed ignoring case", origin: "https://EXAMPLE.com", expected: true},
+		{name: "Listed with port", origin: "http://localhost:3000", expected: true},
+		{name: "Other scheme", origin: "http://example.com", expected: false},
+		{name: "Other port", origin: "http://localhost:3001", expected: false},
+		{name: "Subdomain", origin: "https://evil.example.com", expected: false},
+		{name: "Null", origin: "null", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := list.Allows(tt.origin, "caldave.example.org"); got != tt.expected {
+				t.Errorf("Allows(%q) = %v, expected %v", tt.origin, got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := NewOriginList([]string{"example.com"}); err == nil {
+		t.Error("Expected an origin without a scheme to be rejected")
+	}
+	if any, _ := NewOriginList([]string{"*"}); !any.Allows("https://anywhere.test", "caldave.example.org") {
+		t.Error("Expected * to allow any origin")
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	list, _ := NewOriginList([]string{"https://example.com"})
+	handler := CORS(list, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name         string
+		method       string
+		origin       string
+		expectedCode int
+		expectedACAO string
+	}{
+		{name: "Allowed preflight", method: http.MethodOptions, origin: "https://example.com", expectedCode: http.StatusNoContent, expectedACAO: "https://example.com"},
+		{name: "Refused preflight", method: http.MethodOptions, origin: "https://evil.test", expectedCode: http.StatusForbidden},
+		{name: "Allowed request", method: http.MethodGet, origin: "https://example.com", expectedCode: http.StatusTeapot, expectedACAO: "https://example.com"},
+		{name: "Other origin", method: http.MethodGet, origin: "https://evil.test", expectedCode: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://caldave.example.org/api/hosts/dave/availability", nil)
+			r.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.expectedCode || w.Header().Get("Access-Control-Allow-Origin") != tt.expectedACAO {
+				t.Errorf("Expected %d with origin %q, got %d with %q",
+					tt.expectedCode, tt.expectedACAO, w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
@@ -2,10 +2,18 @@ package middleware
 
 import (
 	"bufio"
+	"caldave/internal/logging"
+	"caldave/internal/metrics"
+	"caldave/internal/ratelimit"
+	"crypto/subtle"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -37,6 +45,26 @@ func (w *wrappedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("websocket: response does not implement http.Hijacker")
 }
 
+// requestIDPattern limits the request IDs accepted from proxies to ones that
+// are safe to log and echo back.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives every request an ID, taken from a valid X-Request-ID header
+// set by a proxy or generated, adds it to the request context for logging and
+// echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// Logging logs every request once it has been served. It must run inside
+// RequestID for the lines to carry the request ID.
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -50,35 +78,142 @@ func Logging(next http.Handler) http.Handler {
 
 		// Special handling for WebSocket connections
 		if isWebSocket {
-			log.Printf("WebSocket connection initiated from %s %s", r.Method, r.URL.Path)
+			slog.InfoContext(r.Context(), "WebSocket connection opened", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
 			next.ServeHTTP(w, r) // Use original ResponseWriter for WebSocket
-			log.Printf("WebSocket connection handled for %s %s, duration: %v", r.Method, r.URL.Path, time.Since(start))
+			slog.InfoContext(r.Context(), "WebSocket connection closed", "path", r.URL.Path, "duration", time.Since(start))
 			return
 		}
 		next.ServeHTTP(wrapped, r)
-		log.Println(wrapped.statusCode, r.Method, r.URL.Path, time.Since(start))
+		slog.InfoContext(r.Context(), "HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", wrapped.statusCode,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
 	})
 }
 
-func SetupCORS(next http.Handler) http.Handler {
+// HasBearerToken reports whether the request carries token in an
+// "Authorization: Bearer" header.
+func HasBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// RequireBearerToken rejects requests that do not carry token in an
+// "Authorization: Bearer" header.
+func RequireBearerToken(token string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		isWebSocket := r.Header.Get("Upgrade") == "websocket"
+		if !HasBearerToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="caldave"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if isWebSocket {
-			// Be more specific with WebSocket origins in production
-			origin := r.Header.Get("Origin")
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Headers", "content-type, authorization, x-requested-with")
-		} else {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+var (
+	httpRequests = metrics.Default.NewCounter("caldave_http_requests_total",
+		"HTTP requests by route and status.", "route", "status")
+	httpDuration = metrics.Default.NewHistogram("caldave_http_request_duration_seconds",
+		"Time taken to serve HTTP requests by route.", metrics.DefaultBuckets, "route")
+)
+
+// Metrics counts and times requests by the ServeMux pattern that matched
+// them, so next must be the mux. WebSocket connections are left to the hub
+// metrics since they last as long as the page is open.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "websocket" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		wrapped := &wrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(wrapped, r)
+
+		// The mux fills in the pattern; unmatched paths share one route so
+		// scanners cannot create a series per URL.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
 		}
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		httpRequests.Inc(route, strconv.Itoa(wrapped.statusCode))
+		httpDuration.Observe(time.Since(start).Seconds(), route)
+	})
+}
+
+// RateLimit answers 429 to clients that have used up their allowance in
+// limiter, keyed by client IP.
+func RateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ratelimit.ClientIP(r)
+		if !limiter.Allow(ip) {
+			retry := max(1, int(math.Ceil(limiter.Wait(ip).Seconds())))
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			slog.InfoContext(r.Context(), "Rate limited", "ip", ip, "path", r.URL.Path)
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RealIP sets the remote address of requests passed on by a trusted proxy
+// to the client's, so rate limits and logs see the client rather than the
+// proxy.
+func RealIP(proxies ratelimit.Proxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := proxies.ClientIP(r); ip != ratelimit.ClientIP(r) {
+			r = r.WithContext(r.Context())
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BasePath serves next under prefix, such as "/caldave", for running behind
+// a proxy that passes the prefix on. next sees paths without it. Requests for
+// the bare prefix are redirected to the prefix with a slash; other paths
+// outside it are not found.
+func BasePath(prefix string, next http.Handler) http.Handler {
+	stripped := http.StripPrefix(prefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+// RedirectToHTTPS sends every request to the same address over HTTPS on
+// port, for serving next to a server that terminates TLS itself.
+func RedirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Missing Host header", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if port != "443" {
+			host += ":" + port
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"caldave/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIPRateLimit(t *testing.T) {
+	proxies, err := ratelimit.ParseProxies([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := ratelimit.NewLimiter(0.001, 1)
+	handler := RealIP(proxies, RateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	get := func(remote, forwarded string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if get("127.0.0.1:5000", "198.51.100.1") != http.StatusOK || get("127.0.0.1:5001", "198.51.100.2") != http.StatusOK {
+		t.Error("Expected visitors behind the proxy to be limited separately")
+	}
+	if get("127.0.0.1:5002", "198.51.100.1") != http.StatusTooManyRequests {
+		t.Error("Expected a visitor's second request to be limited")
+	}
+	if get("203.0.113.7:5000", "198.51.100.3") != http.StatusOK || get("203.0.113.7:5001", "198.51.100.4") != http.StatusTooManyRequests {
+		t.Error("Expected a direct client to be limited by its own address whatever it forwards")
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	handler := BasePath("/caldave", next)
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected string // Path seen by next, or the redirect location
+	}{
+		{name: "Page", path: "/caldave/book/dave", status: http.StatusOK, expected: "/book/dave"},
+		{name: "Root", path: "/caldave/", status: http.StatusOK, expected: "/"},
+		{name: "Bare prefix", path: "/caldave", status: http.StatusMovedPermanently, expected: "/caldave/"},
+		{name: "Outside the prefix", path: "/book/dave", status: http.StatusNotFound},
+		{name: "Longer prefix", path: "/caldaveX/book", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			switch tt.status {
+			case http.StatusOK:
+				if w.Body.String() != tt.expected {
+					t.Errorf("Expected next to see %q, got %q", tt.expected, w.Body.String())
+				}
+			case http.StatusMovedPermanently:
+				if got := w.Header().Get("Location"); got != tt.expected {
+					t.Errorf("Expected a redirect to %q, got %q", tt.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		port     string
+		host     string
+		target   string
+		expected string
+	}{
+		{port: "443", host: "cal.example.com", target: "/book/dave?date=2024-10-11", expected: "https://cal.example.com/book/dave?date=2024-10-11"},
+		{port: "443", host: "cal.example.com:80", target: "/", expected: "https://cal.example.com/"},
+		{port: "8443", host: "localhost:8080", target: "/admin", expected: "https://localhost:8443/admin"},
+		{port: "8443", host: "[::1]:8080", target: "/", expected: "https://[::1]:8443/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+tt.target, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			RedirectToHTTPS(tt.port).ServeHTTP(w, r)
+			if w.Code != http.StatusPermanentRedirect {
+				t.Fatalf("Expected status %d, got %d", http.StatusPermanentRedirect, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tt.expected {
+				t.Errorf("Expected a redirect to %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package notify tells bookers what happened to their bookings.
+package notify
+
+import (
+	"caldave/internal/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to a booker.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to bookers.
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// New returns an SMTPNotifier when SMTP_ADDR is configured, otherwise a
+// LogNotifier.
+func New(cfg *config.Config) Notifier {
+	if cfg.SMTPAddr == "" {
+		return LogNotifier{}
+	}
+	n := &SMTPNotifier{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom}
+	if cfg.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+		n.Auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	return n
+}
+
+// LogNotifier writes messages to the log instead of sending them, for
+// development and servers without a mail relay.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, m Message) error {
+	slog.InfoContext(ctx, "Notification", "to", m.To, "subject", m.Subject, "body", m.Body)
+	return nil
+}
+
+// SMTPNotifier sends messages through a mail relay.
+type SMTPNotifier struct {
+	Addr string // host:port of the relay
+	From string
+	Auth smtp.Auth // nil for relays that do not need authentication
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, m Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, header := range []string{n.From, m.To, m.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return fmt.Errorf("notify: header contains a line break: %q", header)
+		}
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", m.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{m.To}, []byte(msg.String()))
+}
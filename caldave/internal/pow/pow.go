@@ -0,0 +1,138 @@
+// Package pow issues proof of work challenges that make submitting bookings
+// in bulk expensive. A client proves its work by finding a nonce for which
+// SHA-256(token + ":" + nonce) starts with the challenge's number of zero
+// bits.
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("invalid proof of work")
+	ErrExpired = errors.New("proof of work challenge expired")
+	ErrReused  = errors.New("proof of work already used")
+)
+
+// Challenge is sent to the client to solve.
+type Challenge struct {
+	Token      string `json:"token"`
+	Difficulty int    `json:"difficulty"` // Leading zero bits required
+}
+
+// Issuer hands out challenges signed with a key of its own, so it does not
+// need to remember them until they are solved. Each token can be used once.
+type Issuer struct {
+	key        []byte
+	difficulty int
+	ttl        time.Duration
+
+	mutex sync.Mutex
+	used  map[string]time.Time // Token to expiry
+}
+
+// NewIssuer returns an Issuer whose challenges need difficulty zero bits and
+// must be solved within ttl.
+func NewIssuer(difficulty int, ttl time.Duration) (*Issuer, error) {
+	if difficulty < 1 || difficulty > 32 {
+		return nil, errors.New("pow: difficulty must be between 1 and 32 bits")
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Issuer{key: key, difficulty: difficulty, ttl: ttl, used: make(map[string]time.Time)}, nil
+}
+
+// Challenge returns a new challenge.
+func (i *Issuer) Challenge(now time.Time) (Challenge, error) {
+	payload := make([]byte, 16) // Expiry, then random bytes
+	binary.BigEndian.PutUint64(payload, uint64(now.Add(i.ttl).Unix()))
+	if _, err := rand.Read(payload[8:]); err != nil {
+		return Challenge{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(i.sign(payload))
+	return Challenge{Token: token, Difficulty: i.difficulty}, nil
+}
+
+// Verify checks that proof, written "<token>:<nonce>", solves a challenge
+// from this Issuer that has not expired or been used before.
+func (i *Issuer) Verify(proof string, now time.Time) error {
+	token, nonce, ok := strings.Cut(proof, ":")
+	if !ok {
+		return ErrInvalid
+	}
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) != 16 {
+		return ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, i.sign(payload)) {
+		return ErrInvalid
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if now.After(expires) {
+		return ErrExpired
+	}
+	if ZeroBits(token, nonce) < i.difficulty {
+		return ErrInvalid
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for t, exp := range i.used {
+		if now.After(exp) {
+			delete(i.used, t)
+		}
+	}
+	if _, ok := i.used[token]; ok {
+		return ErrReused
+	}
+	i.used[token] = expires
+	return nil
+}
+
+func (i *Issuer) sign(payload []byte) []byte {
+	m := hmac.New(sha256.New, i.key)
+	m.Write(payload)
+	return m.Sum(nil)[:16]
+}
+
+// ZeroBits returns the number of leading zero bits of
+// SHA-256(token + ":" + nonce).
+func ZeroBits(token, nonce string) int {
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Solve finds a nonce for the challenge by brute force, as the booking page
+// does. It returns the proof to send.
+func Solve(c Challenge) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		if ZeroBits(c.Token, nonce) >= c.Difficulty {
+			return c.Token + ":" + nonce
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package pow
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	issuer, err := NewIssuer(8, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewIssuer(8, time.Minute)
+
+	c, err := issuer.Challenge(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := Solve(c)
+	foreign, _ := other.Challenge(now)
+	expired, _ := issuer.Challenge(now.Add(-2 * time.Minute))
+
+	// A nonce that does not solve the challenge.
+	wrong := c.Token + ":x"
+	for n := 0; ZeroBits(c.Token, wrong[len(c.Token)+1:]) >= 8; n++ {
+		wrong = c.Token + ":x" + string(rune('a'+n))
+	}
+
+	tests := []struct {
+		name     string
+		proof    string
+		expected error
+	}{
+		{name: "Solved", proof: proof, expected: nil},
+		{name: "Reused", proof: proof, expected: ErrReused},
+		{name: "Not solved", proof: wrong, expected: ErrInvalid},
+		{name: "Other issuer", proof: Solve(foreign), expected: ErrInvalid},
+		{name: "Expired", proof: Solve(expired), expected: ErrExpired},
+		{name: "Garbage", proof: "nonsense", expected: ErrInvalid},
+		{name: "Tampered token", proof: tamper(proof, 15), expected: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := issuer.Verify(tt.proof, now); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// tamper changes the character at i.
+func tamper(s string, i int) string {
+	c := byte('A')
+	if s[i] == c {
+		c = 'B'
+	}
+	return s[:i] + string(c) + s[i+1:]
+}
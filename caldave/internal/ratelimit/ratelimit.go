@@ -0,0 +1,196 @@
+// Package ratelimit implements token bucket rate limiting, for single
+// connections and keyed by client IP.
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket holds up to burst tokens and refills at rate tokens per second.
+// Every allowed event takes one token.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket.
+func NewBucket(rate float64, burst int) *Bucket {
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Allow takes a token if there is one.
+func (b *Bucket) Allow(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait returns how long until the next token is available.
+func (b *Bucket) Wait(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// full reports whether the bucket has refilled completely, so forgetting it
+// changes nothing.
+func (b *Bucket) full(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Limiter keeps a Bucket per key, such as a client IP.
+type Limiter struct {
+	rate  float64
+	burst int
+
+	mutex   sync.Mutex
+	buckets map[string]*Bucket
+	swept   time.Time
+}
+
+// NewLimiter returns a Limiter giving every key rate events per second with
+// bursts of up to burst.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: burst, buckets: make(map[string]*Bucket)}
+}
+
+// Allow takes a token from key's bucket if there is one.
+func (l *Limiter) Allow(key string) bool {
+	return l.bucket(key, time.Now()).Allow(time.Now())
+}
+
+// Wait returns how long until key's next token is available.
+func (l *Limiter) Wait(key string) time.Duration {
+	return l.bucket(key, time.Now()).Wait(time.Now())
+}
+
+func (l *Limiter) bucket(key string, now time.Time) *Bucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Forget the buckets of clients that have gone quiet now and then so
+	// the map does not grow forever.
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// ClientIP returns the IP address the request came from.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Proxies are the reverse proxies trusted to say which client a request
+// came from. Behind one, every request comes from the proxy's address.
+type Proxies []netip.Prefix
+
+// ParseProxies reads addresses and networks such as "127.0.0.1" or
+// "10.0.0.0/8", skipping empty entries.
+func ParseProxies(entries []string) (Proxies, error) {
+	var proxies Proxies
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q", entry)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p Proxies) trusts(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address the request came from. Requests from a
+// trusted proxy are attributed to the nearest address in X-Forwarded-For
+// that is not a trusted proxy itself, or to X-Real-IP; the headers are
+// ignored from anyone else, as clients can set them to anything.
+func (p Proxies) ClientIP(r *http.Request) string {
+	ip := ClientIP(r)
+	if !p.trusts(ip) {
+		return ip
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			ip = hop
+			if !p.trusts(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+		if _, err := netip.ParseAddr(real); err == nil {
+			return real
+		}
+	}
+	return ip
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Date(2024, 10, 11, 9, 0, 0, 0, time.UTC)
+	b := NewBucket(2, 3) // 2 per second, bursts of 3
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("Expected event %d of the burst to be allowed", i+1)
+		}
+	}
+	if b.Allow(now) {
+		t.Error("Expected the bucket to be empty after the burst")
+	}
+	if wait := b.Wait(now); wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms, got %v", wait)
+	}
+	if !b.Allow(now.Add(500 * time.Millisecond)) {
+		t.Error("Expected a token after 500ms")
+	}
+	if b.Allow(now.Add(500 * time.Millisecond)) {
+		t.Error("Expected only one token after 500ms")
+	}
+	if !b.full(now.Add(time.Hour)) {
+		t.Error("Expected the bucket to refill to its burst")
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	l := NewLimiter(0.001, 1)
+	if !l.Allow("192.0.2.1") || l.Allow("192.0.2.1") {
+		t.Error("Expected one event for the first key")
+	}
+	if !l.Allow("192.0.2.2") {
+		t.Error("Expected another key to have its own bucket")
+	}
+}
+
+func TestProxiesClientIP(t *testing.T) {
+	proxies, err := ParseProxies([]string{"127.0.0.1", " 10.0.0.0/8", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseProxies([]string{"localhost"}); err == nil {
+		t.Error("Expected a host name to be refused")
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		expected  string
+	}{
+		{name: "Direct", remote: "203.0.113.7:5000", expected: "203.0.113.7"},
+		{name: "Direct with a forged header", remote: "203.0.113.7:5000", forwarded: "198.51.100.1", realIP: "198.51.100.2", expected: "203.0.113.7"},
+		{name: "Proxied", remote: "127.0.0.1:5000", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "Proxied with a forged hop", remote: "127.0.0.1:5000", forwarded: "192.0.2.9, 198.51.100.1", expected: "198.51.100.1"},
+		{name: "Through two proxies", remote: "127.0.0.1:5000", forwarded: "198.51.100.1, 10.1.2.3", expected: "198.51.100.1"},
+		{name: "Proxied with X-Real-IP", remote: "127.0.0.1:5000", realIP: "198.51.100.2", expected: "198.51.100.2"},
+		{name: "Proxied without headers", remote: "127.0.0.1:5000", expected: "127.0.0.1"},
+		{name: "Proxied with garbage", remote: "127.0.0.1:5000", forwarded: "nonsense", expected: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := proxies.ClientIP(r); got != tt.expected {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
@@ -1,60 +1,350 @@
 package server
 
 import (
+	"caldave/internal/assets"
+	"caldave/internal/auth"
+	"caldave/internal/certs"
 	"caldave/internal/config"
 	"caldave/internal/handlers"
+	"caldave/internal/metrics"
 	"caldave/internal/middleware"
+	"caldave/internal/notify"
+	"caldave/internal/pow"
+	"caldave/internal/ratelimit"
+	"caldave/internal/store"
+	"caldave/internal/webhook"
+	"caldave/static"
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// setup is what the server is built from, read and checked from the
+// configuration before anything starts.
+type setup struct {
+	hostsFile   *config.HostsFile
+	maxSyncAge  time.Duration
+	origins     *middleware.OriginList
+	proxies     ratelimit.Proxies
+	protection  handlers.Protection
+	limited     func(http.Handler) http.Handler
+	staticFiles *assets.Assets
+	authManager *auth.Manager
+	store       *store.Store
+	certs       *certs.Reloader // nil when a proxy terminates TLS
+}
+
+func load(cfg *config.Config) (*setup, error) {
+	s := &setup{}
+	var err error
+	s.hostsFile, err = config.LoadHosts(cfg.HostsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, wh := range s.hostsFile.Webhooks {
+		if err := wh.ValidateEvents(handlers.BookingEvents); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.HostsFile, err)
+		}
+	}
+	s.maxSyncAge, err = time.ParseDuration(cfg.MaxSyncAge)
+	if err != nil || s.maxSyncAge <= 0 {
+		return nil, fmt.Errorf("invalid MAX_SYNC_AGE %q, use a duration such as 45m", cfg.MaxSyncAge)
+	}
+	s.origins, err = middleware.NewOriginList(cfg.AllowedOrigins)
+	if err != nil {
+		return nil, fmt.Errorf("ALLOWED_ORIGINS: %w", err)
+	}
+	s.proxies, err = ratelimit.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	s.protection, s.limited, err = newProtection(cfg, s.origins)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BasePath = strings.TrimSuffix(cfg.BasePath, "/")
+	if cfg.BasePath != "" && !strings.HasPrefix(cfg.BasePath, "/") {
+		return nil, fmt.Errorf("invalid BASE_PATH %q, use a path such as /caldave", cfg.BasePath)
+	}
+	s.certs, err = newCerts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.staticFiles, err = newAssets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.authManager, err = auth.NewManager(cfg, s.hostsFile.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	s.store, err = store.Open(cfg.StoreFile)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks cfg and the files it names the way Run does before it
+// starts, without connecting any calendars or serving anything. It returns
+// the hosts file it read.
+func Validate(cfg *config.Config) (*config.HostsFile, error) {
+	s, err := load(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := handlers.NewCalendars(s.authManager, s.hostsFile, s.store); err != nil {
+		return nil, err
+	}
+	return s.hostsFile, nil
+}
+
 func Run(cfg *config.Config, ctx context.Context) error {
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
 	defer cancel()
 
+	s, err := load(cfg)
+	if err != nil {
+		return err
+	}
+
 	mux := http.NewServeMux()
-	fs := http.FileServer(http.Dir("static"))
-	wsHandler := handlers.NewWebSocketHandler()
+	wsHandler, err := handlers.NewWebSocketHandler(s.authManager, s.hostsFile, s.store, notify.New(cfg), cfg.BaseURL, handlers.Site{BasePath: cfg.BasePath, Assets: s.staticFiles})
+	if err != nil {
+		return err
+	}
+	if cfg.BaseURLSet {
+		wsHandler.AdvertiseWebSocketURL()
+	}
+	webhooks := webhook.NewDispatcher(s.hostsFile.Webhooks)
+	wsHandler.OnBookingEvent(func(e handlers.BookingEvent) {
+		webhooks.Publish(e.Type, e.Hosts(), e)
+	})
+
+	mux.Handle("GET /static/", http.StripPrefix("/static/", s.staticFiles.Handler()))
+	mux.Handle("GET /ws", s.limited(wsHandler.Handler(s.protection)))
+	mux.Handle("GET /ws/{host}", s.limited(wsHandler.Handler(s.protection)))
+	mux.Handle("GET /booking", s.limited(handlers.BookingHandler(wsHandler)))
+	mux.Handle("GET /book/{host}", s.limited(handlers.BookingHandler(wsHandler)))
+	mux.Handle("GET /api/hosts/{host}/availability", s.limited(handlers.MonthAvailabilityHandler(wsHandler)))
+	mux.Handle("GET /waitlist/{id}", s.limited(handlers.WaitlistOfferPage(wsHandler)))
+	mux.Handle("POST /waitlist/{id}/claim", s.limited(handlers.ClaimWaitlistOffer(wsHandler)))
+	mux.Handle("GET /healthz", handlers.HealthHandler())
+	mux.Handle("GET /readyz", handlers.ReadyHandler(wsHandler, s.maxSyncAge))
+	mux.Handle("GET /", handlers.HomeHandler(wsHandler))
 
-	mux.Handle("GET /static/", http.StripPrefix("/static/", fs))
-	mux.Handle("GET /ws", wsHandler.Handler())
-	mux.Handle("GET /booking", handlers.BookingHandler())
-	mux.Handle("GET /", handlers.HomeHandler())
+	if cfg.AdminToken != "" {
+		admin := func(h http.Handler) http.Handler {
+			return middleware.RequireBearerToken(cfg.AdminToken, h)
+		}
+		mux.Handle("GET /admin/api/hosts/{host}/overrides", admin(handlers.ListOverridesHandler(wsHandler)))
+		mux.Handle("PUT /admin/api/hosts/{host}/overrides/{date}", admin(handlers.SaveOverrideHandler(wsHandler)))
+		mux.Handle("DELETE /admin/api/hosts/{host}/overrides/{date}", admin(handlers.DeleteOverrideHandler(wsHandler)))
+		mux.Handle("POST /admin/api/bookings/{id}/approve", admin(handlers.ApproveBookingHandler(wsHandler)))
+		mux.Handle("POST /admin/api/bookings/{id}/decline", admin(handlers.DeclineBookingHandler(wsHandler)))
+		mux.Handle("POST /admin/api/bookings/{id}/cancel", admin(handlers.CancelBookingHandler(wsHandler)))
+		mux.Handle("POST /admin/api/bookings/{id}/reschedule", admin(handlers.RescheduleBookingHandler(wsHandler)))
+		mux.Handle("GET /admin/api/bookings/{id}/attendees", admin(handlers.AttendeesHandler(wsHandler)))
+		mux.Handle("GET /admin/api/waitlist", admin(handlers.WaitlistHandler(wsHandler)))
+		mux.Handle("DELETE /admin/api/waitlist/{id}", admin(handlers.RemoveWaitlistEntryHandler(wsHandler)))
+		mux.Handle("GET /admin/api/webhooks/deliveries", admin(webhooks.DeliveriesHandler()))
+		mux.Handle("POST /admin/api/hosts/{host}/sync", admin(handlers.SyncHostHandler(wsHandler)))
+		mux.Handle("GET /admin/api/readyz", admin(handlers.ReadinessReportHandler(wsHandler, s.maxSyncAge)))
+		if cfg.MetricsAddr == "" {
+			mux.Handle("GET /metrics", admin(metrics.Default.Handler()))
+		}
+
+		sessions := auth.NewSessions(12*time.Hour, cfg.BasePath+"/admin")
+		sessions.Secure = strings.HasPrefix(cfg.BaseURL, "https://")
+		dashboard := handlers.NewAdminDashboard(wsHandler, sessions, cfg.AdminToken)
+		loggedIn := func(h http.Handler) http.Handler {
+			return sessions.Require(cfg.BasePath+"/admin/login", h)
+		}
+		mux.Handle("GET /admin/login", dashboard.LoginPage())
+		mux.Handle("POST /admin/login", s.limited(dashboard.Login()))
+		mux.Handle("POST /admin/logout", loggedIn(dashboard.Logout()))
+		mux.Handle("GET /admin", loggedIn(dashboard.Dashboard()))
+		mux.Handle("POST /admin/bookings/{id}/cancel", loggedIn(dashboard.CancelBooking()))
+		mux.Handle("POST /admin/bookings/{id}/approve", loggedIn(dashboard.ApproveBooking()))
+		mux.Handle("POST /admin/bookings/{id}/decline", loggedIn(dashboard.DeclineBooking()))
+		mux.Handle("POST /admin/hosts/{host}/connect", s.authManager.StartHandler(sessions, cfg.BasePath+"/admin/login"))
+		mux.Handle("GET /auth/google/callback", s.limited(s.authManager.CallbackHandler()))
+		mux.Handle("POST /admin/hosts/{host}/hours", loggedIn(dashboard.SaveHours()))
+		mux.Handle("POST /admin/hosts/{host}/sync", loggedIn(dashboard.SyncHost()))
+		mux.Handle("POST /admin/hosts/{host}/overrides", loggedIn(dashboard.AddOverride()))
+		mux.Handle("POST /admin/hosts/{host}/overrides/{date}/delete", loggedIn(dashboard.DeleteOverride()))
+	} else {
+		slog.Warn("ADMIN_TOKEN is not set, the admin API and dashboard are disabled and calendars can only be connected with caldave auth")
+	}
 
-	loggedMux := middleware.Logging(mux)
-	corsLoggedMux := middleware.SetupCORS(loggedMux)
+	loggedMux := middleware.RequestID(middleware.Logging(middleware.Metrics(mux)))
+	var handler http.Handler = middleware.CORS(s.origins, loggedMux)
+	if cfg.BasePath != "" {
+		handler = middleware.BasePath(cfg.BasePath, handler)
+	}
+	if len(s.proxies) > 0 {
+		handler = middleware.RealIP(s.proxies, handler)
+	}
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
-		Handler: corsLoggedMux,
+		Handler: handler,
 	}
+	servers := []*http.Server{srv}
 
 	go func() {
-		log.Printf("Starting server on :%s\n", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("error listening and serving: %s\n", err)
+		var err error
+		if s.certs != nil {
+			// With a TLSConfig of its own the server still offers HTTP/2.
+			srv.TLSConfig = s.certs.TLSConfig()
+			slog.Info("Starting server", "port", cfg.Port, "tls", true)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("Starting server", "port", cfg.Port, "tls", false)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("Error listening and serving", "error", err)
+			os.Exit(1)
 		}
 	}()
 
+	if cfg.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Default.Handler())
+		metricsSrv := &http.Server{Addr: cfg.MetricsAddr, Handler: metricsMux}
+		servers = append(servers, metricsSrv)
+		go func() {
+			slog.Info("Serving metrics", "addr", cfg.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Error listening and serving", "error", err)
+				os.Exit(1)
+			}
+		}()
+	} else if cfg.AdminToken == "" {
+		slog.Warn("Neither METRICS_ADDR nor ADMIN_TOKEN is set, /metrics is disabled")
+	}
+
+	if cfg.RedirectPort != "" {
+		redirect := &http.Server{
+			Addr:    ":" + cfg.RedirectPort,
+			Handler: middleware.RedirectToHTTPS(cfg.Port),
+		}
+		servers = append(servers, redirect)
+		go func() {
+			slog.Info("Redirecting HTTP to HTTPS", "port", cfg.RedirectPort)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Error listening and serving", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	var wg sync.WaitGroup
-	wg.Add(1)
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		wsHandler.Run(ctx)
+	}()
 
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
-		log.Println("Shutting down the server...")
+		slog.Info("Shutting down the server")
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-			log.Printf("error shutting down http server: %s\n", err)
+		for _, srv := range servers {
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				slog.Error("Error shutting down the HTTP server", "addr", srv.Addr, "error", err)
+			}
 		}
 	}()
 
 	wg.Wait()
+
+	// Bookings saved while draining have only just published their events.
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if n := webhooks.Shutdown(shutdownCtx); n > 0 {
+		slog.Warn("Abandoned webhook deliveries on shutdown", "deliveries", n)
+	}
 	return nil
 }
+
+// newCerts loads the certificate the server terminates TLS with, if
+// TLS_CERT_FILE and TLS_KEY_FILE are set.
+func newCerts(cfg *config.Config) (*certs.Reloader, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.RedirectPort != "" {
+			return nil, errors.New("HTTP_REDIRECT_PORT needs TLS_CERT_FILE and TLS_KEY_FILE to be set")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.RedirectPort == cfg.Port {
+		return nil, errors.New("HTTP_REDIRECT_PORT must differ from PORT")
+	}
+	return certs.New(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// newAssets serves the static files embedded in the binary, or those in
+// STATIC_DIR while working on them.
+func newAssets(cfg *config.Config) (*assets.Assets, error) {
+	if cfg.StaticDir == "" {
+		return assets.New(static.Files, false)
+	}
+	if _, err := os.Stat(cfg.StaticDir); err != nil {
+		return nil, fmt.Errorf("STATIC_DIR: %w", err)
+	}
+	slog.Info("Serving static files from disk", "dir", cfg.StaticDir)
+	return assets.New(os.DirFS(cfg.StaticDir), true)
+}
+
+// newProtection sets up rate limiting and the booking guard from cfg. limited
+// wraps the public routes in the same per IP limit the WebSocket messages
+// count against.
+func newProtection(cfg *config.Config, origins *middleware.OriginList) (handlers.Protection, func(http.Handler) http.Handler, error) {
+	p := handlers.Protection{
+		AllowOrigin: origins.AllowsRequest,
+		IsAdmin: func(r *http.Request) bool {
+			return middleware.HasBearerToken(r, cfg.AdminToken)
+		},
+	}
+	limited := func(h http.Handler) http.Handler { return h }
+
+	perMinute, err := strconv.Atoi(cfg.RateLimit)
+	if err != nil || perMinute < 0 {
+		return p, nil, fmt.Errorf("invalid RATE_LIMIT %q, use requests per minute or 0", cfg.RateLimit)
+	}
+	if perMinute > 0 {
+		p.IPLimiter = ratelimit.NewLimiter(float64(perMinute)/60, max(10, perMinute/4))
+		p.BookingLimiter = ratelimit.NewLimiter(10.0/3600, 5) // 10 an hour
+		limited = func(h http.Handler) http.Handler {
+			return middleware.RateLimit(p.IPLimiter, h)
+		}
+	}
+
+	difficulty, err := strconv.Atoi(cfg.PowDifficulty)
+	if err != nil || difficulty < 0 {
+		return p, nil, fmt.Errorf("invalid POW_DIFFICULTY %q, use a number of bits or 0", cfg.PowDifficulty)
+	}
+	if difficulty > 0 {
+		issuer, err := pow.NewIssuer(difficulty, 10*time.Minute)
+		if err != nil {
+			return p, nil, err
+		}
+		p.Guard = handlers.ProofOfWorkGuard(issuer)
+	}
+	return p, limited, nil
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+const (
+	JobPending   = "pending" // Waiting for its run time or a retry
+	JobDone      = "done"
+	JobFailed    = "failed" // Gave up after too many attempts
+	JobCancelled = "cancelled"
+)
+
+// Job is background work to be run at a set time, such as a reminder email.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Ref       string          `json:"ref,omitempty"` // What the job is about, usually a booking ID
+	RunAt     time.Time       `json:"runAt"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts,omitempty"`
+	Error     string          `json:"error,omitempty"` // Of the last attempt
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// ScheduleJob saves j as pending. A pending job of the same kind for the same
+// ref is replaced rather than duplicated, keeping its ID.
+func (s *Store) ScheduleJob(j Job) (Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	j.Status = JobPending
+	j.Attempts = 0
+	j.Error = ""
+	j.UpdatedAt = now
+
+	old := slices.Clone(s.data.Jobs)
+	i := slices.IndexFunc(s.data.Jobs, func(existing Job) bool {
+		return existing.Status == JobPending && existing.Kind == j.Kind && existing.Ref == j.Ref
+	})
+	if i >= 0 {
+		j.ID = s.data.Jobs[i].ID
+		j.CreatedAt = s.data.Jobs[i].CreatedAt
+		s.data.Jobs[i] = j
+	} else {
+		id, err := NewID()
+		if err != nil {
+			return Job{}, err
+		}
+		j.ID = id
+		j.CreatedAt = now
+		s.data.Jobs = append(s.data.Jobs, j)
+	}
+	if err := s.save(); err != nil {
+		s.data.Jobs = old
+		return Job{}, err
+	}
+	return j, nil
+}
+
+// Jobs returns the jobs for which keep returns true, in the order they were
+// scheduled.
+func (s *Store) Jobs(keep func(Job) bool) []Job {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var jobs []Job
+	for _, j := range s.data.Jobs {
+		if keep == nil || keep(j) {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// UpdateJob applies fn to the job with the given ID and saves the result.
+// Nothing is changed if fn returns an error.
+func (s *Store) UpdateJob(id string, fn func(*Job) error) (Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.data.Jobs {
+		if s.data.Jobs[i].ID != id {
+			continue
+		}
+		old := s.data.Jobs[i]
+		updated := old
+		if err := fn(&updated); err != nil {
+			return Job{}, err
+		}
+		updated.UpdatedAt = time.Now()
+		s.data.Jobs[i] = updated
+		if err := s.save(); err != nil {
+			s.data.Jobs[i] = old
+			return Job{}, err
+		}
+		return updated, nil
+	}
+	return Job{}, ErrNotFound
+}
+
+// CancelJobs cancels the pending jobs about ref whose kind is one of kinds,
+// or every pending job about ref if no kinds are given. It returns how many
+// were cancelled.
+func (s *Store) CancelJobs(ref string, kinds ...string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := slices.Clone(s.data.Jobs)
+	now := time.Now()
+	n := 0
+	for i, j := range s.data.Jobs {
+		if j.Status != JobPending || j.Ref != ref || (len(kinds) > 0 && !slices.Contains(kinds, j.Kind)) {
+			continue
+		}
+		s.data.Jobs[i].Status = JobCancelled
+		s.data.Jobs[i].UpdatedAt = now
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := s.save(); err != nil {
+		s.data.Jobs = old
+		return 0, err
+	}
+	return n, nil
+}
+
+// PruneJobs deletes finished jobs last changed before cutoff.
+func (s *Store) PruneJobs(cutoff time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.data.Jobs
+	kept := slices.DeleteFunc(slices.Clone(old), func(j Job) bool {
+		return j.Status != JobPending && j.UpdatedAt.Before(cutoff)
+	})
+	if len(kept) == len(old) {
+		return nil
+	}
+	s.data.Jobs = kept
+	if err := s.save(); err != nil {
+		s.data.Jobs = old
+		return err
+	}
+	return nil
+}
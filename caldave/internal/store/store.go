@@ -0,0 +1,306 @@
+package store
+
+import (
+	"caldave/internal/config"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a record does not exist.
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is returned when a change clashes with existing records.
+	ErrConflict = errors.New("store: conflict")
+)
+
+const (
+	StatusPending   = "pending" // Holds the slot until the host approves it or it expires
+	StatusConfirmed = "confirmed"
+	StatusCancelled = "cancelled"
+	StatusDeclined  = "declined" // The host turned a pending booking down
+	StatusExpired   = "expired"  // A pending booking nobody approved in time
+)
+
+// Booking is a slot reserved through caldave.
+type Booking struct {
+	ID                  string            `json:"id"`
+	Host                string            `json:"host"`              // The host the booking was assigned to
+	Team                string            `json:"team,omitempty"`    // Set when booked through a team page
+	Members             []string          `json:"members,omitempty"` // Every host attending a collective booking
+	EventType           string            `json:"eventType"`
+	Start               time.Time         `json:"start"`
+	End                 time.Time         `json:"end"`
+	BufferBeforeMinutes int               `json:"bufferBeforeMinutes,omitempty"`
+	BufferAfterMinutes  int               `json:"bufferAfterMinutes,omitempty"`
+	Name                string            `json:"name"`
+	Email               string            `json:"email"`
+	Answers             map[string]string `json:"answers,omitempty"`
+	Status              string            `json:"status"`
+	ExpiresAt           time.Time         `json:"expiresAt,omitempty"` // When a pending booking stops holding its slot
+	Locale              string            `json:"locale,omitempty"`    // Language the booker's emails are written in
+	CreatedAt           time.Time         `json:"createdAt"`
+}
+
+// Active reports whether the booking still occupies its slot. Pending
+// bookings hold it until they expire.
+func (b Booking) Active() bool {
+	switch b.Status {
+	case StatusConfirmed:
+		return true
+	case StatusPending:
+		return b.ExpiresAt.IsZero() || time.Now().Before(b.ExpiresAt)
+	}
+	return false
+}
+
+// Involves reports whether host attends the booking.
+func (b Booking) Involves(host string) bool {
+	return b.Host == host || slices.Contains(b.Members, host)
+}
+
+// data is everything persisted to the store file.
+type data struct {
+	Bookings     []Booking                          `json:"bookings"`
+	Overrides    map[string][]config.Override       `json:"overrides,omitempty"`    // Made through the admin API, by host
+	WeekdayHours map[string]map[string]config.Hours `json:"weekdayHours,omitempty"` // Made through the admin dashboard, by host
+	Waitlist     []WaitlistEntry                    `json:"waitlist,omitempty"`
+	Jobs         []Job                              `json:"jobs,omitempty"`
+}
+
+// Store keeps caldave's state in a single JSON file. Every change is written
+// to disk before it is returned to the caller.
+type Store struct {
+	path  string
+	mutex sync.RWMutex
+	data  data
+}
+
+// Open loads the store at path, starting empty if the file does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddBooking assigns an ID to b and saves it.
+func (s *Store) AddBooking(b Booking) (Booking, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id, err := NewID()
+	if err != nil {
+		return Booking{}, err
+	}
+	b.ID = id
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now()
+	}
+
+	s.data.Bookings = append(s.data.Bookings, b)
+	if err := s.save(); err != nil {
+		s.data.Bookings = s.data.Bookings[:len(s.data.Bookings)-1]
+		return Booking{}, err
+	}
+	return b, nil
+}
+
+// Bookings returns the bookings for which keep returns true.
+func (s *Store) Bookings(keep func(Booking) bool) []Booking {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var bookings []Booking
+	for _, b := range s.data.Bookings {
+		if keep == nil || keep(b) {
+			bookings = append(bookings, b)
+		}
+	}
+	return bookings
+}
+
+// Booking returns the booking with the given ID.
+func (s *Store) Booking(id string) (Booking, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, b := range s.data.Bookings {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return Booking{}, ErrNotFound
+}
+
+// UpdateBooking applies fn to the booking with the given ID and saves the
+// result. Nothing is changed if fn returns an error.
+func (s *Store) UpdateBooking(id string, fn func(*Booking) error) (Booking, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.data.Bookings {
+		if s.data.Bookings[i].ID != id {
+			continue
+		}
+		old := s.data.Bookings[i]
+		updated := old
+		if err := fn(&updated); err != nil {
+			return Booking{}, err
+		}
+		s.data.Bookings[i] = updated
+		if err := s.save(); err != nil {
+			s.data.Bookings[i] = old
+			return Booking{}, err
+		}
+		return updated, nil
+	}
+	return Booking{}, ErrNotFound
+}
+
+// Overrides returns the date overrides saved for host.
+func (s *Store) Overrides(host string) []config.Override {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return slices.Clone(s.data.Overrides[host])
+}
+
+// SetOverride saves o for host, replacing any override starting on the same
+// date. It returns ErrConflict if the result would cover a day twice.
+func (s *Store) SetOverride(host string, o config.Override) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.data.Overrides[host]
+	updated := slices.DeleteFunc(slices.Clone(old), func(existing config.Override) bool {
+		return existing.Date == o.Date
+	})
+	updated = append(updated, o)
+	slices.SortFunc(updated, func(a, b config.Override) int { return strings.Compare(a.Date, b.Date) })
+	if err := config.ValidateOverrides(updated); err != nil {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+
+	if s.data.Overrides == nil {
+		s.data.Overrides = make(map[string][]config.Override)
+	}
+	s.data.Overrides[host] = updated
+	if err := s.save(); err != nil {
+		s.data.Overrides[host] = old
+		return err
+	}
+	return nil
+}
+
+// DeleteOverride removes the override for host starting on date.
+func (s *Store) DeleteOverride(host, date string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.data.Overrides[host]
+	i := slices.IndexFunc(old, func(o config.Override) bool { return o.Date == date })
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.data.Overrides[host] = slices.Delete(slices.Clone(old), i, i+1)
+	if err := s.save(); err != nil {
+		s.data.Overrides[host] = old
+		return err
+	}
+	return nil
+}
+
+// WeekdayHours returns the weekday hours saved for host, keyed by weekday
+// name, or nil if the host uses the hosts file.
+func (s *Store) WeekdayHours(host string) map[string]config.Hours {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return maps.Clone(s.data.WeekdayHours[host])
+}
+
+// SetWeekdayHours saves the weekday hours for host, replacing those from the
+// hosts file.
+func (s *Store) SetWeekdayHours(host string, hours map[string]config.Hours) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.WeekdayHours == nil {
+		s.data.WeekdayHours = make(map[string]map[string]config.Hours)
+	}
+	old, existed := s.data.WeekdayHours[host]
+	s.data.WeekdayHours[host] = maps.Clone(hours)
+	if err := s.save(); err != nil {
+		if existed {
+			s.data.WeekdayHours[host] = old
+		} else {
+			delete(s.data.WeekdayHours, host)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the store to a temporary file and renames it into place so a
+// crash never leaves a half written file behind. Callers must hold the lock.
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// CheckWritable reports whether changes could be saved right now, by
+// writing and removing a file next to the store.
+func (s *Store) CheckWritable() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".caldave-probe-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// NewID returns a random identifier suitable for URLs.
+func NewID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
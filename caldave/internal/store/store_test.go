@@ -0,0 +1,29 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBookingActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		booking  Booking
+		expected bool
+	}{
+		{name: "Confirmed", booking: Booking{Status: StatusConfirmed}, expected: true},
+		{name: "Cancelled", booking: Booking{Status: StatusCancelled}, expected: false},
+		{name: "Declined", booking: Booking{Status: StatusDeclined}, expected: false},
+		{name: "Expired", booking: Booking{Status: StatusExpired}, expected: false},
+		{name: "Pending hold", booking: Booking{Status: StatusPending, ExpiresAt: time.Now().Add(time.Hour)}, expected: true},
+		{name: "Pending past its hold", booking: Booking{Status: StatusPending, ExpiresAt: time.Now().Add(-time.Minute)}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.booking.Active(); got != tt.expected {
+				t.Errorf("Active() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
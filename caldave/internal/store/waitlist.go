@@ -0,0 +1,133 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	WaitlistWaiting = "waiting" // In the queue for the slot
+	WaitlistOffered = "offered" // Holds a freed seat until the offer expires
+	WaitlistClaimed = "claimed" // Took the offer and has a booking
+	WaitlistLapsed  = "lapsed"  // Did not claim the offer in time
+	WaitlistClosed  = "closed"  // The slot started before a seat came free
+	WaitlistRemoved = "removed" // Taken off the list by an admin
+)
+
+// WaitlistEntry is a visitor queueing for a slot that was fully booked.
+type WaitlistEntry struct {
+	ID                  string            `json:"id"`
+	Host                string            `json:"host"`
+	EventType           string            `json:"eventType"`
+	Start               time.Time         `json:"start"`
+	End                 time.Time         `json:"end"`
+	BufferBeforeMinutes int               `json:"bufferBeforeMinutes,omitempty"`
+	BufferAfterMinutes  int               `json:"bufferAfterMinutes,omitempty"`
+	Name                string            `json:"name"`
+	Email               string            `json:"email"`
+	Answers             map[string]string `json:"answers,omitempty"`
+	Status              string            `json:"status"`
+	Token               string            `json:"token,omitempty"`          // Secret in the claim link, set when offered
+	OfferExpiresAt      time.Time         `json:"offerExpiresAt,omitempty"` // When an offer passes to the next person
+	BookingID           string            `json:"bookingId,omitempty"`      // The booking made by claiming the offer
+	Locale              string            `json:"locale,omitempty"`         // Language the emails are written in
+	CreatedAt           time.Time         `json:"createdAt"`
+}
+
+// Holding reports whether the entry has an offer that still holds a seat.
+func (e WaitlistEntry) Holding() bool {
+	return e.Status == WaitlistOffered && time.Now().Before(e.OfferExpiresAt)
+}
+
+// Queued reports whether the entry is waiting for or holding a seat.
+func (e WaitlistEntry) Queued() bool {
+	return e.Status == WaitlistWaiting || e.Status == WaitlistOffered
+}
+
+// SameSlot reports whether e queues for the same slot as other.
+func (e WaitlistEntry) SameSlot(other WaitlistEntry) bool {
+	return e.Host == other.Host && e.EventType == other.EventType && e.Start.Equal(other.Start)
+}
+
+// AddWaitlistEntry assigns an ID to e and appends it to the queue for its
+// slot. It returns ErrConflict if the same email address is already queued
+// for the slot.
+func (s *Store) AddWaitlistEntry(e WaitlistEntry) (WaitlistEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, existing := range s.data.Waitlist {
+		if existing.Queued() && existing.SameSlot(e) && strings.EqualFold(existing.Email, e.Email) {
+			return WaitlistEntry{}, ErrConflict
+		}
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	e.ID = id
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	s.data.Waitlist = append(s.data.Waitlist, e)
+	if err := s.save(); err != nil {
+		s.data.Waitlist = s.data.Waitlist[:len(s.data.Waitlist)-1]
+		return WaitlistEntry{}, err
+	}
+	return e, nil
+}
+
+// Waitlist returns the entries for which keep returns true, in the order
+// they joined.
+func (s *Store) Waitlist(keep func(WaitlistEntry) bool) []WaitlistEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var entries []WaitlistEntry
+	for _, e := range s.data.Waitlist {
+		if keep == nil || keep(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// WaitlistEntry returns the entry with the given ID.
+func (s *Store) WaitlistEntry(id string) (WaitlistEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, e := range s.data.Waitlist {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return WaitlistEntry{}, ErrNotFound
+}
+
+// UpdateWaitlistEntry applies fn to the entry with the given ID and saves
+// the result. Nothing is changed if fn returns an error.
+func (s *Store) UpdateWaitlistEntry(id string, fn func(*WaitlistEntry) error) (WaitlistEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.data.Waitlist {
+		if s.data.Waitlist[i].ID != id {
+			continue
+		}
+		old := s.data.Waitlist[i]
+		updated := old
+		if err := fn(&updated); err != nil {
+			return WaitlistEntry{}, err
+		}
+		s.data.Waitlist[i] = updated
+		if err := s.save(); err != nil {
+			s.data.Waitlist[i] = old
+			return WaitlistEntry{}, err
+		}
+		return updated, nil
+	}
+	return WaitlistEntry{}, ErrNotFound
+}
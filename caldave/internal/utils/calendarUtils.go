@@ -2,14 +2,11 @@ package utils
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
 	"time"
 
-	"golang.org/x/oauth2"
 	"google.golang.org/api/calendar/v3"
 )
 
@@ -31,61 +28,6 @@ type Availaibility struct {
 	EndTime   time.Time
 }
 
-// Retrieve a token, saves the token, then returns the generated client.
-func GetClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
-	}
-	return config.Client(context.Background(), tok)
-}
-
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
-	}
-
-	tok, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
-	}
-	return tok
-}
-
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
 // func RunCalendarService() {
 // 	cldDta := getCalendars(srv)
 // 	startDay := time.Now().AddDate(0, 0, -30).Format(time.RFC3339)
@@ -94,11 +36,11 @@ func saveToken(path string, token *oauth2.Token) {
 
 // }
 
-func GetCalendars(srv *calendar.Service) []CalendarData {
+func GetCalendars(ctx context.Context, srv *calendar.Service) ([]CalendarData, error) {
 	calendarList := calendar.NewCalendarListService(srv)
-	lst, err := calendarList.List().Do()
+	lst, err := calendarList.List().Context(ctx).Do()
 	if err != nil {
-		log.Printf("Unable to retrieve Calendars: %v", err)
+		return nil, fmt.Errorf("unable to retrieve calendars: %w", err)
 	}
 	var data []CalendarData
 	for _, item := range lst.Items {
@@ -108,17 +50,21 @@ func GetCalendars(srv *calendar.Service) []CalendarData {
 		}
 		data = append(data, tmp)
 	}
-	return data
+	return data, nil
 }
 
-func GetEvents(startDay, endDay string, srv *calendar.Service, cldData []CalendarData) []EventData {
+// GetEvents fetches the events of every calendar. A calendar that fails is
+// skipped and its error returned alongside the events of the others.
+func GetEvents(ctx context.Context, startDay, endDay string, srv *calendar.Service, cldData []CalendarData) ([]EventData, error) {
 	var calendarEvents []EventData
+	var errs []error
 
 	for _, cld := range cldData {
 		events, err := srv.Events.List(cld.CalendarID).ShowDeleted(true).
-			SingleEvents(false).TimeMin(startDay).TimeMax(endDay).MaxResults(90).Do()
+			SingleEvents(false).TimeMin(startDay).TimeMax(endDay).MaxResults(90).Context(ctx).Do()
 		if err != nil {
-			log.Fatalf("Unable to retrieve the user's events: %v", err)
+			errs = append(errs, &CalendarError{Calendar: cld, Err: err})
+			continue
 		}
 		if len(events.Items) == 0 {
 
@@ -136,14 +82,14 @@ func GetEvents(startDay, endDay string, srv *calendar.Service, cldData []Calenda
 				parsedStartTime, err := parseDateTime(date)
 				if err != nil {
 					// Handle error
-					log.Printf("Error parsing start time: %v", err)
+					slog.WarnContext(ctx, "Error parsing event start time", "calendar", cld.CalendarName, "error", err)
 					continue
 				}
 
 				parsedEndTime, err := parseDateTime(endDate)
 				if err != nil {
 					// Handle error
-					log.Printf("Error parsing end time: %v", err)
+					slog.WarnContext(ctx, "Error parsing event end time", "calendar", cld.CalendarName, "error", err)
 					continue
 				}
 
@@ -158,7 +104,38 @@ func GetEvents(startDay, endDay string, srv *calendar.Service, cldData []Calenda
 			}
 		}
 	}
-	return calendarEvents
+	return calendarEvents, errors.Join(errs...)
+}
+
+// CalendarError is why the events of one calendar could not be fetched.
+type CalendarError struct {
+	Calendar CalendarData
+	Err      error
+}
+
+func (e *CalendarError) Error() string {
+	return fmt.Sprintf("unable to retrieve events of %s: %v", e.Calendar.CalendarName, e.Err)
+}
+
+func (e *CalendarError) Unwrap() error {
+	return e.Err
+}
+
+// FailedCalendars returns the ids of the calendars GetEvents could not fetch,
+// given the error it returned.
+func FailedCalendars(err error) map[string]bool {
+	failed := make(map[string]bool)
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return failed
+	}
+	for _, err := range joined.Unwrap() {
+		var calErr *CalendarError
+		if errors.As(err, &calErr) {
+			failed[calErr.Calendar.CalendarID] = true
+		}
+	}
+	return failed
 }
 
 func parseDateTime(datetime string) (time.Time, error) {
@@ -0,0 +1,282 @@
+// Package webhook sends booking events to the endpoints configured in the
+// hosts file as signed JSON, retrying failed deliveries with exponential
+// backoff until the server shuts down.
+package webhook
+
+import (
+	"bytes"
+	"caldave/internal/config"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-Caldave-Event"
+	DeliveryHeader  = "X-Caldave-Delivery"
+	SignatureHeader = "X-Caldave-Signature"
+)
+
+// Delivery states.
+const (
+	StatusPending   = "pending" // Being sent or waiting for a retry
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed" // Gave up
+)
+
+const logSize = 200
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	ID        string          `json:"id"` // Same for every attempt, so receivers can ignore repeats
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Delivery records the sending of one event to one webhook.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Event       string    `json:"event"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"statusCode,omitempty"` // Of the last attempt
+	Error       string    `json:"error,omitempty"`      // Of the last attempt
+	CreatedAt   time.Time `json:"createdAt"`
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+}
+
+// Dispatcher delivers events to webhooks in the background. Set its fields
+// before the first Publish.
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int           // Including the first
+	Backoff     time.Duration // Wait before the first retry, doubled for each one after
+	MaxBackoff  time.Duration
+
+	subs     []config.WebhookConfig
+	sem      chan struct{} // Limits concurrent requests
+	wg       sync.WaitGroup
+	stopping chan struct{} // Closed by Shutdown, after which failed deliveries are not retried
+	stopOnce sync.Once
+	ctx      context.Context // Of every request, cancelled once Shutdown stops waiting
+	cancel   context.CancelFunc
+
+	mutex     sync.Mutex
+	log       []*Delivery // Oldest first, at most logSize
+	abandoned int
+}
+
+func NewDispatcher(subs []config.WebhookConfig) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 6,
+		Backoff:     30 * time.Second,
+		MaxBackoff:  30 * time.Minute,
+		subs:        subs,
+		sem:         make(chan struct{}, 8),
+		stopping:    make(chan struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Publish sends event to every webhook subscribed to it and to one of hosts.
+// data is marshalled as the payload's data.
+func (d *Dispatcher) Publish(event string, hosts []string, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Error encoding webhook", "event", event, "error", err)
+		return
+	}
+	now := time.Now()
+	for _, sub := range d.subs {
+		if !sub.Subscribed(event, hosts) {
+			continue
+		}
+		id, err := newID()
+		if err != nil {
+			slog.Error("Error creating webhook delivery", "event", event, "error", err)
+			return
+		}
+		body, err := json.Marshal(Payload{ID: id, Event: event, CreatedAt: now, Data: raw})
+		if err != nil {
+			slog.Error("Error encoding webhook", "event", event, "error", err)
+			return
+		}
+		delivery := &Delivery{ID: id, Webhook: sub.ID, Event: event, Status: StatusPending, CreatedAt: now}
+		d.record(delivery)
+
+		d.wg.Add(1)
+		go func(sub config.WebhookConfig) {
+			defer d.wg.Done()
+			d.deliver(sub, delivery, body)
+		}(sub)
+	}
+}
+
+// Wait blocks until every delivery has succeeded or given up.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Shutdown stops retrying failed deliveries and waits for the attempts in
+// flight until ctx is done, then cancels them. Deliveries that did not
+// succeed by then are logged and given up; it returns how many.
+func (d *Dispatcher) Shutdown(ctx context.Context) int {
+	d.stopOnce.Do(func() { close(d.stopping) })
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.abandoned
+}
+
+// Deliveries returns the most recent deliveries, newest first.
+func (d *Dispatcher) Deliveries() []Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	deliveries := make([]Delivery, 0, len(d.log))
+	for i := len(d.log) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *d.log[i])
+	}
+	return deliveries
+}
+
+// DeliveriesHandler serves the delivery log as JSON.
+func (d *Dispatcher) DeliveriesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.Deliveries()); err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding webhook deliveries", "error", err)
+		}
+	})
+}
+
+func (d *Dispatcher) record(delivery *Delivery) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.log) == logSize {
+		d.log = slices.Delete(d.log, 0, 1)
+	}
+	d.log = append(d.log, delivery)
+}
+
+// deliver sends body until the webhook accepts it, it is rejected for good,
+// MaxAttempts is reached or the dispatcher shuts down.
+func (d *Dispatcher) deliver(sub config.WebhookConfig, delivery *Delivery, body []byte) {
+	wait := d.Backoff
+	for attempt := 1; ; attempt++ {
+		d.sem <- struct{}{}
+		code, err := d.send(sub, delivery, body)
+		<-d.sem
+
+		d.mutex.Lock()
+		delivery.Attempts = attempt
+		delivery.LastAttempt = time.Now()
+		delivery.StatusCode = code
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		switch {
+		case err == nil:
+			delivery.Status = StatusDelivered
+		case !retryable(code) || attempt >= d.MaxAttempts:
+			delivery.Status = StatusFailed
+		}
+		status := delivery.Status
+		d.mutex.Unlock()
+
+		if status != StatusPending {
+			if status == StatusFailed {
+				slog.Warn("Giving up on webhook delivery",
+					"webhook", sub.ID, "event", delivery.Event, "delivery", delivery.ID, "attempts", attempt, "error", err)
+			}
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-d.stopping:
+			timer.Stop()
+			d.abandon(sub, delivery, err)
+			return
+		}
+		wait = min(wait*2, d.MaxBackoff)
+	}
+}
+
+// abandon gives up on a delivery that would have been retried, as the server
+// is shutting down.
+func (d *Dispatcher) abandon(sub config.WebhookConfig, delivery *Delivery, err error) {
+	d.mutex.Lock()
+	delivery.Status = StatusFailed
+	attempts := delivery.Attempts
+	d.abandoned++
+	d.mutex.Unlock()
+	slog.Warn("Abandoning webhook delivery on shutdown",
+		"webhook", sub.ID, "event", delivery.Event, "delivery", delivery.ID, "attempts", attempts, "error", err)
+}
+
+// send makes one attempt and returns the response status, if there was one.
+func (d *Dispatcher) send(sub config.WebhookConfig, delivery *Delivery, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.Client.Timeout+time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "caldave-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether a failed attempt may succeed later: the endpoint
+// could not be reached, is overloaded or had a server error. Other client
+// errors will not go away by retrying.
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("generating delivery id: " + err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,169 @@
+package webhook_test
+
+import (
+	"caldave/internal/config"
+	"caldave/internal/webhook"
+	"caldave/internal/webhook/webhooktest"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2024, 10, 11, 9, 0, 0, 0, time.UTC)
+	body := []byte(`{"event":"booking.created"}`)
+	signed := webhook.Sign("secret", now, body)
+
+	tests := []struct {
+		name     string
+		secret   string
+		header   string
+		body     []byte
+		now      time.Time
+		expected error
+	}{
+		{name: "Valid", secret: "secret", header: signed, body: body, now: now},
+		{name: "Within tolerance", secret: "secret", header: signed, body: body, now: now.Add(4 * time.Minute)},
+		{name: "Wrong secret", secret: "other", header: signed, body: body, now: now, expected: webhook.ErrInvalidSignature},
+		{name: "Changed body", secret: "secret", header: signed, body: []byte(`{}`), now: now, expected: webhook.ErrInvalidSignature},
+		{name: "Too old", secret: "secret", header: signed, body: body, now: now.Add(10 * time.Minute), expected: webhook.ErrSignatureExpired},
+		{name: "Missing signature", secret: "secret", header: "t=1728637200", body: body, now: now, expected: webhook.ErrInvalidSignature},
+		{name: "Garbage", secret: "secret", header: "nonsense", body: body, now: now, expected: webhook.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhook.Verify(tt.secret, tt.header, tt.body, tt.now, 5*time.Minute)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func newDispatcher(url string, sub config.WebhookConfig) *webhook.Dispatcher {
+	sub.URL, sub.Secret = url, "secret"
+	if sub.ID == "" {
+		sub.ID = "test"
+	}
+	d := webhook.NewDispatcher([]config.WebhookConfig{sub})
+	d.MaxAttempts = 3
+	d.Backoff = time.Millisecond
+	return d
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	receiver := webhooktest.NewReceiver("secret")
+	defer receiver.Close()
+	receiver.FailNext(2)
+
+	d := newDispatcher(receiver.URL, config.WebhookConfig{})
+	d.Publish("booking.created", []string{"dave"}, map[string]string{"id": "b1"})
+	d.Wait()
+
+	requests := receiver.Requests()
+	if len(requests) != 1 || requests[0].Event != "booking.created" || string(requests[0].Payload.Data) != `{"id":"b1"}` {
+		t.Fatalf("Expected the booking.created delivery, got %+v", requests)
+	}
+	deliveries := d.Deliveries()
+	if len(deliveries) != 1 || deliveries[0].Status != webhook.StatusDelivered || deliveries[0].Attempts != 3 {
+		t.Errorf("Expected one delivery after 3 attempts, got %+v", deliveries)
+	}
+	if deliveries[0].ID != requests[0].Delivery || requests[0].Payload.ID != requests[0].Delivery {
+		t.Errorf("Expected the delivery id %s in the header and payload, got %+v", deliveries[0].ID, requests[0])
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	receiver := webhooktest.NewReceiver("secret")
+	defer receiver.Close()
+	receiver.FailNext(10)
+
+	d := newDispatcher(receiver.URL, config.WebhookConfig{})
+	d.Publish("booking.cancelled", []string{"dave"}, nil)
+	d.Wait()
+
+	if receiver.Attempts() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", receiver.Attempts())
+	}
+	deliveries := d.Deliveries()
+	if len(deliveries) != 1 || deliveries[0].Status != webhook.StatusFailed || deliveries[0].StatusCode != 503 {
+		t.Errorf("Expected a failed delivery, got %+v", deliveries)
+	}
+}
+
+func TestDispatcherDoesNotRetryRejections(t *testing.T) {
+	receiver := webhooktest.NewReceiver("another secret")
+	defer receiver.Close()
+
+	d := newDispatcher(receiver.URL, config.WebhookConfig{})
+	d.Publish("booking.created", []string{"dave"}, nil)
+	d.Wait()
+
+	if receiver.Attempts() != 1 {
+		t.Errorf("Expected a 401 not to be retried, got %d attempts", receiver.Attempts())
+	}
+}
+
+func TestDispatcherFilters(t *testing.T) {
+	receiver := webhooktest.NewReceiver("secret")
+	defer receiver.Close()
+
+	d := newDispatcher(receiver.URL, config.WebhookConfig{
+		Events: []string{"booking.created", "booking.cancelled"},
+		Hosts:  []string{"alice"},
+	})
+	d.Publish("booking.created", []string{"dave"}, nil)
+	d.Publish("booking.confirmed", []string{"alice"}, nil)
+	d.Publish("booking.cancelled", []string{"dave", "alice"}, nil)
+	d.Wait()
+
+	requests := receiver.Requests()
+	if len(requests) != 1 || requests[0].Event != "booking.cancelled" {
+		t.Errorf("Expected only the team booking.cancelled delivery, got %+v", requests)
+	}
+}
+
+func TestDispatcherShutdown(t *testing.T) {
+	receiver := webhooktest.NewReceiver("secret")
+	defer receiver.Close()
+	receiver.FailNext(10)
+
+	d := newDispatcher(receiver.URL, config.WebhookConfig{})
+	d.Backoff = time.Hour
+	d.Publish("booking.created", []string{"dave"}, nil)
+	for deadline := time.Now().Add(5 * time.Second); receiver.Attempts() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a first attempt")
+		}
+	}
+
+	// An endpoint that never answers is cut off at the deadline.
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	slow := newDispatcher(hung.URL, config.WebhookConfig{})
+	slow.Publish("booking.created", []string{"dave"}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if n := d.Shutdown(ctx); n != 1 {
+		t.Errorf("Expected the delivery waiting for a retry to be abandoned, got %d", n)
+	}
+	if n := slow.Shutdown(ctx); n != 1 {
+		t.Errorf("Expected the hung delivery to be abandoned, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected shutdown to stop at the deadline, took %s", elapsed)
+	}
+	if deliveries := d.Deliveries(); deliveries[0].Status != webhook.StatusFailed || deliveries[0].Attempts != 1 {
+		t.Errorf("Expected the delivery to be given up after one attempt, got %+v", deliveries)
+	}
+}
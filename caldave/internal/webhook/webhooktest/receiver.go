@@ -0,0 +1,93 @@
+// Package webhooktest provides a local webhook endpoint for tests.
+package webhooktest
+
+import (
+	"caldave/internal/webhook"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Request is a delivery the Receiver accepted.
+type Request struct {
+	Event    string
+	Delivery string
+	Payload  webhook.Payload
+}
+
+// Receiver is an HTTP server that checks webhook signatures and records the
+// deliveries it accepts. Deliveries with a bad signature get 401.
+type Receiver struct {
+	*httptest.Server
+
+	secret   string
+	mutex    sync.Mutex
+	requests []Request
+	failNext int
+	attempts int
+}
+
+// NewReceiver starts a Receiver expecting deliveries signed with secret.
+// Close it when done.
+func NewReceiver(secret string) *Receiver {
+	r := &Receiver{secret: secret}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// FailNext makes the next n deliveries fail with 503.
+func (r *Receiver) FailNext(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failNext = n
+}
+
+// Requests returns the accepted deliveries in the order they arrived.
+func (r *Receiver) Requests() []Request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Request(nil), r.requests...)
+}
+
+// Attempts returns how many deliveries arrived, accepted or not.
+func (r *Receiver) Attempts() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.attempts
+}
+
+func (r *Receiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.attempts++
+	if err := webhook.Verify(r.secret, req.Header.Get(webhook.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.failNext > 0 {
+		r.failNext--
+		http.Error(w, "failing on purpose", http.StatusServiceUnavailable)
+		return
+	}
+
+	var payload webhook.Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, Request{
+		Event:    req.Header.Get(webhook.EventHeader),
+		Delivery: req.Header.Get(webhook.DeliveryHeader),
+		Payload:  payload,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,16 +1,40 @@
 package main
 
 import (
+	"caldave/internal/cli"
 	"caldave/internal/config"
+	"caldave/internal/logging"
 	"caldave/internal/server"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 )
 
 func main() {
 	cfg := config.NewConfig()
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid LOG_LEVEL %q, use debug, info, warn or error\n", cfg.LogLevel)
+		os.Exit(1)
+	}
+	// Also routes the standard log package through slog.
+	slog.SetDefault(logging.New(os.Stderr, level))
+
 	ctx := context.Background()
+	if args := os.Args[1:]; len(args) > 0 && args[0] != "serve" {
+		err := cli.Run(ctx, cfg, args, os.Stdin, os.Stdout, os.Stderr)
+		if errors.Is(err, cli.ErrUsage) {
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := server.Run(cfg, ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Server error: %s\n", err)
 		os.Exit(1)
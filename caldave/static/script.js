@@ -1,42 +1,193 @@
 // Parts of this script was gotten from
 // https://webdesign.tutsplus.com/learn-how-to-code-a-simple-javascript-calendar-and-datepicker--cms-108322t
 
-const socket = new WebSocket("ws://localhost:8080/ws");
+const host = document.body.dataset.host;
+const socketURL =
+  document.body.dataset.wsUrl ||
+  `${location.protocol === "https:" ? "wss" : "ws"}://${location.host}${document.body.dataset.base}/ws/${host}`;
+const sessionKey = `caldave-session-${host}`;
+
+const eventTypes = JSON.parse(
+  document.getElementById("event-types").textContent,
+);
+const messages = JSON.parse(document.getElementById("messages").textContent);
+const lang = document.documentElement.lang || "en";
+const plurals = new Intl.PluralRules(lang);
+
+// t translates the message with key, filling its {name} placeholders from
+// vars. With a count the plural form for it is used, as in "seatsLeft.one".
+function t(key, vars = {}) {
+  if ("count" in vars) {
+    const plural = `${key}.${plurals.select(vars.count)}`;
+    key = plural in messages ? plural : `${key}.other`;
+  }
+  const message = messages[key] ?? key;
+  return message.replace(/\{(\w+)\}/g, (match, name) =>
+    name in vars ? String(vars[name]) : match,
+  );
+}
+const eventTypeSelect = document.querySelector(".event-type");
+const bookingForm = document.querySelector(".booking-form");
+const bookingStatus = document.querySelector(".booking-status");
+const questions = document.querySelector(".questions");
+
+let selectedDate = null;
+let selectedSlot = null;
+
+// The last availability request, sent again after reconnecting.
+let lastAvailabilityRequest = null;
 
 function requestAvailability(dateTo) {
-  sendMessage({
+  selectedDate = dateTo;
+  lastAvailabilityRequest = {
     type: "REQUEST_AVAILABILITY",
     payload: {
       date: dateTo,
+      eventType: eventTypeSelect.value,
     },
-  });
+  };
+  sendMessage(lastAvailabilityRequest);
 }
 
-function updateEvents(startDate, endDate) {
+function requestMonthAvailability() {
   sendMessage({
-    type: "UPDATE_AVAILABILITY",
+    type: "REQUEST_MONTH_AVAILABILITY",
     payload: {
-      startDate: startDate,
-      endDate: endDate,
+      month: formatDate(new Date(year, month, 1)).slice(0, 7),
+      eventType: eventTypeSelect.value,
     },
   });
 }
 
+function createBooking(booking) {
+  sendMessage({
+    type: "CREATE_BOOKING",
+    payload: booking,
+  });
+}
+
+function joinWaitlist(booking) {
+  sendMessage({
+    type: "JOIN_WAITLIST",
+    payload: booking,
+  });
+}
+
+// Booking waiting for the server's challenge before it is sent.
+let pendingBooking = null;
+
+function requestChallenge(booking) {
+  pendingBooking = booking;
+  sendMessage({ type: "REQUEST_CHALLENGE" });
+}
+
+// solveChallenge finds a nonce that gives SHA-256(token:nonce) enough
+// leading zero bits. crypto.subtle needs the page to be served over HTTPS
+// or from localhost.
+async function solveChallenge(challenge) {
+  const encoder = new TextEncoder();
+  for (let nonce = 0; ; nonce++) {
+    const proof = `${challenge.token}:${nonce}`;
+    const digest = new Uint8Array(
+      await crypto.subtle.digest("SHA-256", encoder.encode(proof)),
+    );
+    if (zeroBits(digest) >= challenge.difficulty) {
+      return proof;
+    }
+  }
+}
+
+function zeroBits(bytes) {
+  let n = 0;
+  for (const b of bytes) {
+    if (b !== 0) {
+      return n + Math.clz32(b) - 24;
+    }
+    n += 8;
+  }
+  return n;
+}
+
+async function handleChallenge(challenge) {
+  const booking = pendingBooking;
+  pendingBooking = null;
+  if (!booking) {
+    return;
+  }
+  const { waitlist, ...request } = booking;
+  if (challenge.type === "pow") {
+    showStatus(t("checking"), false);
+    request.proof = await solveChallenge(challenge);
+  }
+  if (waitlist) {
+    joinWaitlist(request);
+  } else {
+    createBooking(request);
+  }
+}
+
+function showStatus(text, isError) {
+  bookingStatus.className = `booking-status text-sm text-center mb-2 ${isError ? "text-red-600" : "text-green-600"}`;
+  bookingStatus.textContent = text;
+}
+
+let socket = null;
 let isWebSocketReady = false;
 const pendingMessages = [];
 
-socket.onopen = (event) => {
-  console.log("WebSocket connection established");
+// Reconnects wait longer after each failed attempt, up to maxReconnectDelay.
+const minReconnectDelay = 1000;
+const maxReconnectDelay = 30000;
+let reconnectDelay = minReconnectDelay;
+let shutdownDelay = null; // Set when the server says when to come back
+let showingRestart = false;
+
+function connect() {
+  const token = sessionStorage.getItem(sessionKey);
+  socket = new WebSocket(
+    token ? `${socketURL}?session=${encodeURIComponent(token)}` : socketURL,
+  );
+  socket.onopen = onOpen;
+  socket.onmessage = onMessage;
+  socket.onclose = onClose;
+  socket.onerror = (error) => {
+    console.error("WebSocket error:", error);
+  };
+}
+
+// onOpen fetches the month shown, and the day picked, whenever the socket
+// opens, so a reconnect brings the page up to date.
+function onOpen() {
   isWebSocketReady = true;
+  reconnectDelay = minReconnectDelay;
   sendPendingMessages();
-  displayCalendar();
-  displaySelected();
-};
+  if (showingRestart) {
+    showingRestart = false;
+    showStatus("", false);
+  }
+  requestMonthAvailability();
+  if (lastAvailabilityRequest) {
+    sendMessage(lastAvailabilityRequest);
+  }
+}
+
+function onClose() {
+  isWebSocketReady = false;
+  const delay = shutdownDelay ?? reconnectDelay * (0.5 + Math.random());
+  shutdownDelay = null;
+  reconnectDelay = Math.min(reconnectDelay * 2, maxReconnectDelay);
+  setTimeout(connect, delay);
+}
 
+// Availability is requested again on reconnecting, so only other messages
+// wait for the connection.
 function sendMessage(message) {
   if (isWebSocketReady) {
     socket.send(JSON.stringify(message));
-  } else {
+  } else if (
+    message.type !== "REQUEST_AVAILABILITY" &&
+    message.type !== "REQUEST_MONTH_AVAILABILITY"
+  ) {
     pendingMessages.push(message);
   }
 }
@@ -48,26 +199,49 @@ function sendPendingMessages() {
   }
 }
 
-socket.onmessage = (event) => {
+function onMessage(event) {
   const message = JSON.parse(event.data);
-  if (message.type === "AVAILABILITY_RESPONSE") {
-    const availableTimes = message.payload.availableTimes;
-    console.log("Available times:", availableTimes);
-    displayAvailableTimes(availableTimes);
+  if (message.type === "SESSION") {
+    sessionStorage.setItem(sessionKey, message.payload.token);
+  } else if (message.type === "AVAILABILITY_RESPONSE") {
+    const slots = message.payload.slots || [];
+    console.log("Available slots:", slots);
+    displayAvailableTimes(slots, message.payload.waitlist || []);
+  } else if (message.type === "MONTH_AVAILABILITY_RESPONSE") {
+    displayMonthAvailability(message.payload);
   } else if (message.type === "EVENTS_UPDATED") {
     console.log("Events updated successfully");
+    requestMonthAvailability();
+    if (selectedDate) {
+      requestAvailability(selectedDate);
+    }
+  } else if (message.type === "BOOKING_CREATED") {
+    const booking = message.payload;
+    bookingStatus.className = "booking-status text-sm text-center mb-2 text-green-600";
+    bookingStatus.textContent =
+      booking.status === "pending" ? t("requested", booking) : t("booked", booking);
+    bookingForm.reset();
+    eventTypeSelect.value = booking.eventType;
+    selectedSlot = null;
+  } else if (message.type === "WAITLIST_JOINED") {
+    const entry = message.payload;
+    showStatus(t("waitlisted", entry), false);
+    bookingForm.reset();
+    eventTypeSelect.value = entry.eventType;
+    selectedSlot = null;
+  } else if (message.type === "CHALLENGE") {
+    handleChallenge(message.payload);
+  } else if (message.type === "BOOKING_ERROR" || message.type === "ERROR") {
+    showStatus(message.payload.error, true);
+  } else if (message.type === "SERVER_SHUTDOWN") {
+    const seconds = message.payload.reconnectAfter;
+    shutdownDelay = seconds * 1000;
+    showingRestart = true;
+    showStatus(t("restarting", { seconds }), true);
   }
-};
-
-socket.onclose = (event) => {
-  console.log("WebSocket connection closed");
-  isWebSocketReady = false;
-};
+}
 
-socket.onerror = (error) => {
-  console.error("WebSocket error:", error);
-  isWebSocketReady = false;
-};
+connect();
 
 const display = document.querySelector(".display");
 const previous = document.querySelector(".left");
@@ -86,7 +260,7 @@ function displayCalendar() {
   const lastDay = new Date(year, month + 1, 0);
   const numberOfDays = lastDay.getDate();
 
-  const formattedDate = date.toLocaleString("en-UK", {
+  const formattedDate = date.toLocaleString(lang, {
     month: "long",
     year: "numeric",
   });
@@ -125,6 +299,7 @@ function displayCalendar() {
     days.appendChild(dayDiv);
 
     button.dataset.date = currentDate.toDateString();
+    button.dataset.day = formatDate(currentDate);
     days.dataset.date = currentDate.toDateString();
     time.dataset.date = currentDate.toDateString();
 
@@ -136,16 +311,35 @@ function displayCalendar() {
       dayDiv.classList.add("current-date");
     }
   }
-  updateEventsForCurrentMonth();
+  requestMonthAvailability();
 }
 
-function updateEventsForCurrentMonth() {
-  const startDate = new Date(year, month, 1);
-  const endDate = new Date(year, month + 1, 0);
-  updateEvents(
-    startDate.toISOString().split("T")[0],
-    endDate.toISOString().split("T")[0],
-  );
+function formatDate(dateObj) {
+  const year = dateObj.getFullYear();
+  const month = String(dateObj.getMonth() + 1).padStart(2, "0");
+  const day = String(dateObj.getDate()).padStart(2, "0");
+  return `${year}-${month}-${day}`;
+}
+
+// Grey out the days of the displayed month that have nothing left to book.
+function displayMonthAvailability(summary) {
+  if (summary.month !== formatDate(new Date(year, month, 1)).slice(0, 7)) {
+    return; // The visitor has moved to another month since asking
+  }
+  summary.days.forEach((day) => {
+    const button = days.querySelector(`button[data-day="${day.date}"]`);
+    if (!button) {
+      return;
+    }
+    button.disabled = !day.available;
+    button.classList.toggle("line-through", !day.available);
+    button.classList.toggle("opacity-40", !day.available);
+    button.classList.toggle("cursor-not-allowed", !day.available);
+    button.classList.toggle("font-semibold", day.available);
+    button.title = day.available
+      ? t("slotsAvailable", { count: day.slots })
+      : t("fullyBooked");
+  });
 }
 
 function displaySelected() {
@@ -165,50 +359,124 @@ function displaySelected() {
       selectedButton.classList.add("bg-blue-600");
       selectedButton.classList.add("text-white");
 
-      const selectedDate = e.target.dataset.date;
-      selectedDay.innerHTML = `${selectedDate}`;
-      const dateObj = new Date(selectedDate);
-
-      const year = dateObj.getFullYear();
-      const month = String(dateObj.getMonth() + 1).padStart(2, "0");
-      const day = String(dateObj.getDate()).padStart(2, "0");
-
-      const formattedDate = `${year}-${month}-${day}`;
+      const selectedDate = new Date(e.target.dataset.date);
+      selectedDay.textContent = selectedDate.toLocaleDateString(lang, {
+        weekday: "long",
+        day: "numeric",
+        month: "long",
+        year: "numeric",
+      });
+      const formattedDate = formatDate(selectedDate);
       console.log(formattedDate);
       requestAvailability(formattedDate);
     });
   });
 }
 
-function displayAvailableTimes(availableTimes) {
+// displayAvailableTimes lists the bookable slots, followed by the fully
+// booked ones that can be waitlisted.
+function displayAvailableTimes(slots, waitlist) {
   const timeSlotsContainer = document.querySelector(".time-slots");
   timeSlotsContainer.innerHTML = "";
+  selectedSlot = null;
+
+  const all = slots.concat(waitlist.map((slot) => ({ ...slot, waitlist: true })));
+  all.forEach((timeSlot) => {
+    const timeSlotDiv = document.createElement("div");
+    // class="available text-gray-500 hover:text-gray-800 cursor-pointer border-[1.5px] border-gray-400 px-2 text-center py-1 rounded-lg"
+    timeSlotDiv.className = "flex items-center justify-center";
+    timeSlotDiv.innerHTML = `
+         <span class="text-gray-500 w-full inline-block hover:text-gray-800 cursor-pointer border-[1.5px] border-gray-400 px-2 text-center py-1 rounded-lg transition-colors duration-200 ease-in-out hover:bg-gray-100">
+           ${timeSlot.start} - ${timeSlot.end}${timeSlot.seats ? ` <span class="text-xs">(${t("seatsLeft", { count: timeSlot.seats })})</span>` : ""}${timeSlot.waitlist ? ` <span class="text-xs">(${t("joinWaitlist")})</span>` : ""}
+         </span>
+       `;
+    timeSlotDiv.addEventListener("click", () => {
+      timeSlotsContainer
+        .querySelectorAll("span")
+        .forEach((span) => span.classList.remove("bg-blue-600", "text-white"));
+      timeSlotDiv.querySelector("span").classList.add("bg-blue-600", "text-white");
+      selectedSlot = timeSlot;
+    });
+    timeSlotsContainer.appendChild(timeSlotDiv);
+  });
+}
+
+function displayQuestions() {
+  const eventType = eventTypes.find((et) => et.slug === eventTypeSelect.value);
+  questions.innerHTML = "";
+  if (!eventType) {
+    return;
+  }
 
-  availableTimes.forEach((timeSlot) => {
-    const start = timeSlot.start;
-    const end = timeSlot.end;
-    const startTime = new Date(`2000-01-01T${start}`);
-    const endTime = new Date(`2000-01-01T${end}`);
-
-    while (startTime < endTime) {
-      const slotStart = startTime.toTimeString().slice(0, 5);
-      startTime.setMinutes(startTime.getMinutes() + 30);
-      const slotEnd = startTime.toTimeString().slice(0, 5);
-
-      const timeSlotDiv = document.createElement("div");
-      // class="available text-gray-500 hover:text-gray-800 cursor-pointer border-[1.5px] border-gray-400 px-2 text-center py-1 rounded-lg"
-      timeSlotDiv.className = "flex items-center justify-center";
-      timeSlotDiv.innerHTML = `
-           <span class="text-gray-500 w-full inline-block hover:text-gray-800 cursor-pointer border-[1.5px] border-gray-400 px-2 text-center py-1 rounded-lg transition-colors duration-200 ease-in-out hover:bg-gray-100">
-             ${slotStart} - ${slotEnd}
-           </span>
-         `;
-      timeSlotsContainer.appendChild(timeSlotDiv);
+  eventType.questions.forEach((question) => {
+    const label = document.createElement("label");
+    label.className = "flex flex-col gap-1 text-gray-600";
+    label.textContent = question.label;
+
+    let input;
+    if (question.type === "textarea") {
+      input = document.createElement("textarea");
+    } else if (question.type === "select") {
+      input = document.createElement("select");
+      question.options.forEach((option) => {
+        const opt = document.createElement("option");
+        opt.value = option;
+        opt.textContent = option;
+        input.appendChild(opt);
+      });
+    } else {
+      input = document.createElement("input");
+      input.type = question.type;
     }
+    input.name = question.id;
+    input.required = question.required;
+    input.dataset.question = question.id;
+    input.className =
+      question.type === "checkbox" ? "rounded" : "rounded-lg border-gray-300";
+
+    label.appendChild(input);
+    questions.appendChild(label);
+  });
+}
+
+function submitBooking(e) {
+  e.preventDefault();
+  if (!selectedDate || !selectedSlot) {
+    bookingStatus.className = "booking-status text-sm text-center mb-2 text-red-600";
+    bookingStatus.textContent = t("pickSlot");
+    return;
+  }
+
+  const answers = {};
+  questions.querySelectorAll("[data-question]").forEach((input) => {
+    answers[input.dataset.question] =
+      input.type === "checkbox" ? String(input.checked) : input.value;
+  });
+
+  requestChallenge({
+    eventType: eventTypeSelect.value,
+    date: selectedDate,
+    start: selectedSlot.start,
+    name: bookingForm.elements.name.value,
+    email: bookingForm.elements.email.value,
+    answers: answers,
+    waitlist: Boolean(selectedSlot.waitlist),
   });
 }
 
 document.addEventListener("DOMContentLoaded", () => {
+  displayCalendar();
+  displaySelected();
+  displayQuestions();
+  eventTypeSelect.addEventListener("change", () => {
+    displayQuestions();
+    requestMonthAvailability();
+    if (selectedDate) {
+      requestAvailability(selectedDate);
+    }
+  });
+  bookingForm.addEventListener("submit", submitBooking);
+
   previous.addEventListener("click", () => {
     days.innerHTML = "";
     selected.innerHTML = "";
@@ -0,0 +1,8 @@
+// Package static holds the booking page's script and icons, embedded into
+// the binary so it runs from any working directory.
+package static
+
+import "embed"
+
+//go:embed *.js *.ico *.png
+var Files embed.FS